@@ -0,0 +1,347 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package archive converts between the objects under a prefix of a
+// blob.Bucket and tar or zip archives.
+//
+// Write streams the objects into an archive, with entry names relative to
+// the prefix; for example, to serve a "download this folder" request:
+//   err := archive.Write(ctx, w, bucket, "photos/2018/", &archive.WriteOptions{Format: archive.Zip})
+//
+// Extract does the reverse, writing each regular file in an archive to the
+// bucket under a prefix.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/google/go-cloud/blob"
+)
+
+// Format is an archive format.
+type Format int
+
+const (
+	// Tar is an uncompressed tar archive.
+	Tar Format = iota
+	// TarGzip is a gzip-compressed tar archive.
+	TarGzip
+	// Zip is a zip archive. Entries are compressed with Deflate.
+	Zip
+)
+
+// DefaultConcurrency is the default for WriteOptions.Concurrency.
+const DefaultConcurrency = 4
+
+// WriteOptions controls Write.
+type WriteOptions struct {
+	// Format is the format of the archive. The default is Tar.
+	Format Format
+	// Concurrency is the maximum number of objects that are opened ahead of
+	// the one being written to the archive, to hide the latency of each
+	// read. If zero, DefaultConcurrency is used.
+	Concurrency int
+}
+
+// Write writes an archive of all the objects in b whose keys start with
+// prefix to w, in lexicographical order of their keys. The name of each entry
+// is the object's key with prefix and any leading "/" removed.
+//
+// Write doesn't close w.
+func Write(ctx context.Context, w io.Writer, b *blob.Bucket, prefix string, opts *WriteOptions) error {
+	if opts == nil {
+		opts = &WriteOptions{}
+	}
+	n := opts.Concurrency
+	if n <= 0 {
+		n = DefaultConcurrency
+	}
+	var aw entryWriter
+	switch opts.Format {
+	case Tar:
+		aw = &tarWriter{tw: tar.NewWriter(w)}
+	case TarGzip:
+		gw := gzip.NewWriter(w)
+		aw = &tarWriter{tw: tar.NewWriter(gw), gw: gw}
+	case Zip:
+		aw = &zipWriter{zw: zip.NewWriter(w)}
+	default:
+		return fmt.Errorf("archive.Write: unknown format %d", opts.Format)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	fetches := prefetch(ctx, b, prefix, n)
+	defer func() {
+		// Close any readers that were opened ahead of a failure.
+		cancel()
+		for f := range fetches {
+			<-f.done
+			if f.r != nil {
+				f.r.Close()
+			}
+		}
+	}()
+	for f := range fetches {
+		<-f.done
+		if f.err != nil {
+			return f.err
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(f.obj.Key, prefix), "/")
+		if name == "" {
+			name = path.Base(f.obj.Key)
+		}
+		err := aw.writeEntry(name, f.r)
+		f.r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return aw.close()
+}
+
+// fetch is an object whose reader is being opened by prefetch.
+type fetch struct {
+	obj *blob.ListObject
+	// r and err are set when done is closed.
+	r    *blob.Reader
+	err  error
+	done chan struct{}
+}
+
+// prefetch lists the objects under prefix, and opens readers for up to n of
+// them at a time. It returns the objects in listing order; the receiver must
+// wait for each fetch to be done. A listing error is returned as a final
+// fetch with a nil obj.
+func prefetch(ctx context.Context, b *blob.Bucket, prefix string, n int) <-chan *fetch {
+	// One more fetch waits to be sent while the channel is full.
+	fetches := make(chan *fetch, n-1)
+	go func() {
+		defer close(fetches)
+		iter := b.List(&blob.ListOptions{Prefix: prefix})
+		for {
+			obj, err := iter.Next(ctx)
+			if err == io.EOF {
+				return
+			}
+			f := &fetch{obj: obj, done: make(chan struct{})}
+			if err != nil {
+				f.err = err
+				close(f.done)
+			} else {
+				go func() {
+					f.r, f.err = b.NewReader(ctx, obj.Key)
+					close(f.done)
+				}()
+			}
+			select {
+			case fetches <- f:
+			case <-ctx.Done():
+				<-f.done
+				if f.r != nil {
+					f.r.Close()
+				}
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return fetches
+}
+
+// entryWriter writes entries to an archive.
+type entryWriter interface {
+	writeEntry(name string, r *blob.Reader) error
+	close() error
+}
+
+type tarWriter struct {
+	tw *tar.Writer
+	gw *gzip.Writer // nil if not compressed
+}
+
+func (w *tarWriter) writeEntry(name string, r *blob.Reader) error {
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     r.Size(),
+		ModTime:  r.ModTime(),
+	}
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.Copy(w.tw, r)
+	return err
+}
+
+func (w *tarWriter) close() error {
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	if w.gw != nil {
+		return w.gw.Close()
+	}
+	return nil
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (w *zipWriter) writeEntry(name string, r *blob.Reader) error {
+	hdr := &zip.FileHeader{
+		Name:   name,
+		Method: zip.Deflate,
+	}
+	hdr.SetModTime(r.ModTime())
+	hdr.SetMode(0644)
+	ew, err := w.zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(ew, r)
+	return err
+}
+
+func (w *zipWriter) close() error {
+	return w.zw.Close()
+}
+
+// ExtractOptions controls Extract.
+type ExtractOptions struct {
+	// Format is the format of the archive. The default is Tar.
+	Format Format
+}
+
+// Extract writes each regular file in the archive read from r to b, with key
+// prefix followed by the entry's name. Directories, links and other special
+// entries are skipped. The content type of each object is inferred as
+// described at blob.DetectContentType.
+//
+// Entry names are cleaned, and Extract fails on absolute names and names
+// that would escape prefix, such as "../x".
+//
+// A zip archive has to be read in random order, so Extract first copies it
+// to a temporary file.
+func Extract(ctx context.Context, b *blob.Bucket, prefix string, r io.Reader, opts *ExtractOptions) error {
+	if opts == nil {
+		opts = &ExtractOptions{}
+	}
+	switch opts.Format {
+	case Tar:
+		return extractTar(ctx, b, prefix, r)
+	case TarGzip:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		return extractTar(ctx, b, prefix, gr)
+	case Zip:
+		return extractZip(ctx, b, prefix, r)
+	default:
+		return fmt.Errorf("archive.Extract: unknown format %d", opts.Format)
+	}
+}
+
+func extractTar(ctx context.Context, b *blob.Bucket, prefix string, r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		if err := extractEntry(ctx, b, prefix, hdr.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(ctx context.Context, b *blob.Bucket, prefix string, r io.Reader) error {
+	f, err := ioutil.TempFile("", "archive")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	size, err := io.Copy(f, r)
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		err = extractEntry(ctx, b, prefix, zf.Name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractEntry writes the content of the archive entry called name to b.
+func extractEntry(ctx context.Context, b *blob.Bucket, prefix, name string, r io.Reader) error {
+	if strings.HasSuffix(name, "/") {
+		// A directory.
+		return nil
+	}
+	clean := path.Clean(name)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("archive.Extract: entry name %q is outside the archive", name)
+	}
+	// Canceling ctx aborts the write if the entry can't be read, so that a
+	// truncated object isn't left in b.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := b.NewWriter(ctx, prefix+clean, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		cancel()
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,278 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cloud/blob"
+	"github.com/google/go-cloud/blob/fileblob"
+	"github.com/google/go-cmp/cmp"
+)
+
+func newBucket(t *testing.T) (*blob.Bucket, func()) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := fileblob.OpenBucket(dir, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return b, func() { os.RemoveAll(dir) }
+}
+
+// readArchive returns the names and contents of the entries in an archive.
+func readArchive(t *testing.T, format Format, data []byte) map[string]string {
+	got := map[string]string{}
+	switch format {
+	case Tar, TarGzip:
+		var r io.Reader = bytes.NewReader(data)
+		if format == TarGzip {
+			gr, err := gzip.NewReader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = gr
+		}
+		tr := tar.NewReader(r)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got[hdr.Name] = string(b)
+		}
+	case Zip:
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got[f.Name] = string(b)
+		}
+	}
+	return got
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	objects := map[string]string{
+		"folder/a.txt":      "hello",
+		"folder/b/c.json":   `{"x": 1}`,
+		"folder/b/d":        "<html><body>sniffed</body></html>",
+		"folder/empty.bin":  "",
+		"folder/large.data": strings.Repeat("0123456789", 10000),
+		"other/e.txt":       "not in the archive",
+	}
+	want := map[string]string{}
+	for key, content := range objects {
+		if strings.HasPrefix(key, "folder/") {
+			want[strings.TrimPrefix(key, "folder/")] = content
+		}
+	}
+
+	for _, format := range []Format{Tar, TarGzip, Zip} {
+		for _, concurrency := range []int{0, 1, 10} {
+			b, done := newBucket(t)
+			defer done()
+			for key, content := range objects {
+				if err := b.WriteAll(ctx, key, []byte(content), nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var buf bytes.Buffer
+			if err := Write(ctx, &buf, b, "folder/", &WriteOptions{Format: format, Concurrency: concurrency}); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(readArchive(t, format, buf.Bytes()), want); diff != "" {
+				t.Errorf("format %d, concurrency %d: got archive diff %s", format, concurrency, diff)
+			}
+
+			if err := Extract(ctx, b, "copy/", bytes.NewReader(buf.Bytes()), &ExtractOptions{Format: format}); err != nil {
+				t.Fatal(err)
+			}
+			for name, content := range want {
+				got, err := b.ReadAll(ctx, "copy/"+name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != content {
+					t.Errorf("format %d: got extracted %s %q want %q", format, name, got, content)
+				}
+			}
+			for name, wantType := range map[string]string{
+				"a.txt":    "text/plain; charset=utf-8",
+				"b/c.json": "application/json",
+				"b/d":      "text/html; charset=utf-8",
+			} {
+				attrs, err := b.Attributes(ctx, "copy/"+name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if attrs.ContentType != wantType {
+					t.Errorf("format %d: got content type %q for %s, want %q", format, attrs.ContentType, name, wantType)
+				}
+			}
+		}
+	}
+}
+
+func TestExtractSkipsDirsAndRejectsEscapes(t *testing.T) {
+	ctx := context.Background()
+	tarOf := func(hdrs ...*tar.Header) []byte {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, hdr := range hdrs {
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Size > 0 {
+				tw.Write(bytes.Repeat([]byte("x"), int(hdr.Size)))
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	b, done := newBucket(t)
+	defer done()
+	data := tarOf(
+		&tar.Header{Typeflag: tar.TypeDir, Name: "dir/", Mode: 0755},
+		&tar.Header{Typeflag: tar.TypeSymlink, Name: "dir/link", Linkname: "/etc/passwd"},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "dir/../file", Mode: 0644, Size: 1},
+	)
+	if err := Extract(ctx, b, "out/", bytes.NewReader(data), nil); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	iter := b.List(nil)
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, obj.Key)
+	}
+	if diff := cmp.Diff(keys, []string{"out/file"}); diff != "" {
+		t.Errorf("got keys %v diff %s", keys, diff)
+	}
+
+	for _, name := range []string{"../escape", "a/../../escape", "/abs"} {
+		data := tarOf(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: 1})
+		if err := Extract(ctx, b, "out/", bytes.NewReader(data), nil); err == nil {
+			t.Errorf("%s: got nil error, want error", name)
+		}
+	}
+}
+
+type errWriter struct{}
+
+func (errWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("fail")
+}
+
+func TestWriteError(t *testing.T) {
+	ctx := context.Background()
+	b, done := newBucket(t)
+	defer done()
+	for i := 0; i < 10; i++ {
+		if err := b.WriteAll(ctx, fmt.Sprintf("key%d", i), bytes.Repeat([]byte("x"), 10000), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Write(ctx, errWriter{}, b, "", &WriteOptions{Concurrency: 2}); err == nil {
+		t.Error("got nil error for failing writer, want error")
+	}
+}
+
+func TestExtractTruncated(t *testing.T) {
+	ctx := context.Background()
+	content := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(content)
+	for _, tc := range []struct {
+		name   string
+		format Format
+	}{{"tar", Tar}, {"tar.gz", TarGzip}} {
+		var buf bytes.Buffer
+		var w io.WriteCloser = nopWriteCloser{&buf}
+		if tc.format == TarGzip {
+			w = gzip.NewWriter(&buf)
+		}
+		tw := tar.NewWriter(w)
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "big", Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(content)
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		b, done := newBucket(t)
+		defer done()
+		// Cut the archive off in the middle of the entry.
+		data := buf.Bytes()[:buf.Len()/2]
+		if err := Extract(ctx, b, "out/", bytes.NewReader(data), &ExtractOptions{Format: tc.format}); err == nil {
+			t.Errorf("%s: got nil error extracting a truncated archive, want error", tc.name)
+		}
+		if _, err := b.Attributes(ctx, "out/big"); !blob.IsNotExist(err) {
+			t.Errorf("%s: got err %v for the truncated entry, want IsNotExist", tc.name, err)
+		}
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
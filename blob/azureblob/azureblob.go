@@ -0,0 +1,655 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package azureblob provides an implementation of blob that uses Azure Blob
+// Storage. Blobs are written as block blobs.
+//
+// Azure only allows C# identifiers as metadata keys, so other characters in
+// keys are escaped as "__0x<hex>__"; metadata values are path-escaped. Both
+// are unescaped when read back.
+//
+// For blob.Open URLs, azureblob registers for the "azblob" scheme.
+// The URL's Host is used as the container name.
+// The storage account name is read from the AZURE_STORAGE_ACCOUNT
+// environment variable. If AZURE_STORAGE_KEY is set, it is used as a shared
+// key; otherwise, if AZURE_STORAGE_SAS_TOKEN is set, it is used as a SAS
+// token. No query options are supported.
+// Example URL: blob.Open("azblob://mycontainer")
+//
+// It exposes the following types for As:
+// Bucket: *azblob.ContainerURL
+// Error: azblob.StorageError
+// ListObject: azblob.BlobItem for objects, azblob.BlobPrefix for "directories"
+// ListOptions.BeforeList: *azblob.ListBlobsSegmentOptions
+// Reader: azblob.DownloadResponse
+// Attributes: azblob.BlobGetPropertiesResponse
+// WriterOptions.BeforeWrite: *azblob.BlobHTTPHeaders
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/google/go-cloud/blob"
+	"github.com/google/go-cloud/blob/driver"
+)
+
+const (
+	defaultPageSize = 1000
+	// defaultBufferSize is the size of each block staged by a Writer, unless
+	// overridden by WriterOptions.BufferSize.
+	defaultBufferSize = 5 * 1024 * 1024
+)
+
+func init() {
+	blob.Register("azblob", func(ctx context.Context, u *url.URL) (driver.Bucket, error) {
+		accountName := os.Getenv("AZURE_STORAGE_ACCOUNT")
+		if accountName == "" {
+			return nil, errors.New("azureblob: AZURE_STORAGE_ACCOUNT must be set")
+		}
+		opts := &Options{}
+		var cred azblob.Credential
+		if key := os.Getenv("AZURE_STORAGE_KEY"); key != "" {
+			sharedKey, err := azblob.NewSharedKeyCredential(accountName, key)
+			if err != nil {
+				return nil, err
+			}
+			cred = sharedKey
+			opts.Credential = sharedKey
+		} else if sas := os.Getenv("AZURE_STORAGE_SAS_TOKEN"); sas != "" {
+			cred = azblob.NewAnonymousCredential()
+			opts.SASToken = sas
+		} else {
+			return nil, errors.New("azureblob: one of AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN must be set")
+		}
+		p := azblob.NewPipeline(cred, azblob.PipelineOptions{})
+		return openBucket(ctx, p, accountName, u.Host, opts)
+	})
+}
+
+// Options sets options for constructing a *blob.Bucket backed by Azure Blob
+// Storage.
+type Options struct {
+	// Credential is used to sign URLs returned by SignedURL. If nil,
+	// SignedURL returns an error for which blob.IsNotImplemented is true.
+	Credential *azblob.SharedKeyCredential
+	// SASToken is a shared access signature, without a leading "?", that is
+	// appended to every request. Use it with a pipeline created with
+	// azblob.NewAnonymousCredential.
+	SASToken string
+	// StorageDomain is the domain of the Blob Storage service. Defaults to
+	// "blob.core.windows.net"; set it to use a national cloud.
+	StorageDomain string
+}
+
+// openBucket returns an Azure Blob Storage bucket for the given container
+// in accountName, using pipeline p to make requests.
+func openBucket(ctx context.Context, p pipeline.Pipeline, accountName, containerName string, opts *Options) (driver.Bucket, error) {
+	if p == nil {
+		return nil, errors.New("azureblob.OpenBucket: pipeline is required")
+	}
+	if accountName == "" {
+		return nil, errors.New("azureblob.OpenBucket: accountName is required")
+	}
+	if containerName == "" {
+		return nil, errors.New("azureblob.OpenBucket: containerName is required")
+	}
+	if opts == nil {
+		opts = &Options{}
+	}
+	domain := opts.StorageDomain
+	if domain == "" {
+		domain = "blob.core.windows.net"
+	}
+	u, err := url.Parse(fmt.Sprintf("https://%s.%s", accountName, domain))
+	if err != nil {
+		return nil, err
+	}
+	if opts.SASToken != "" {
+		u.RawQuery = opts.SASToken
+	}
+	return &bucket{
+		name:         containerName,
+		containerURL: azblob.NewServiceURL(*u, p).NewContainerURL(containerName),
+		credential:   opts.Credential,
+	}, nil
+}
+
+// OpenBucket returns a *blob.Bucket backed by the Azure Blob Storage
+// container containerName in the storage account accountName. p is used to
+// make requests; see azblob.NewPipeline.
+func OpenBucket(ctx context.Context, p pipeline.Pipeline, accountName, containerName string, opts *Options) (*blob.Bucket, error) {
+	drv, err := openBucket(ctx, p, accountName, containerName, opts)
+	if err != nil {
+		return nil, err
+	}
+	return blob.NewBucket(drv), nil
+}
+
+// bucket represents an Azure Blob Storage container and handles read, write
+// and delete operations.
+type bucket struct {
+	name         string
+	containerURL azblob.ContainerURL
+	credential   *azblob.SharedKeyCredential
+}
+
+var errNotImplemented = errors.New("not implemented")
+
+// IsNotExist implements driver.IsNotExist.
+func (b *bucket) IsNotExist(err error) bool {
+	if e, ok := err.(azblob.StorageError); ok {
+		if resp := e.Response(); resp != nil && resp.StatusCode == http.StatusNotFound {
+			return true
+		}
+		return e.ServiceCode() == azblob.ServiceCodeBlobNotFound
+	}
+	return false
+}
+
+// IsNotImplemented implements driver.IsNotImplemented.
+func (b *bucket) IsNotImplemented(err error) bool {
+	return err == errNotImplemented
+}
+
+// IsRetryable implements driver.IsRetryable.
+func (b *bucket) IsRetryable(err error) bool {
+	if e, ok := err.(azblob.StorageError); ok {
+		if resp := e.Response(); resp != nil {
+			return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		}
+	}
+	if e, ok := err.(net.Error); ok {
+		return e.Timeout() || e.Temporary()
+	}
+	return err == io.ErrUnexpectedEOF
+}
+
+// As implements driver.As.
+func (b *bucket) As(i interface{}) bool {
+	p, ok := i.(**azblob.ContainerURL)
+	if !ok {
+		return false
+	}
+	*p = &b.containerURL
+	return true
+}
+
+// ErrorAs implements driver.ErrorAs.
+func (b *bucket) ErrorAs(err error, i interface{}) bool {
+	e, ok := err.(azblob.StorageError)
+	if !ok {
+		return false
+	}
+	p, ok := i.(*azblob.StorageError)
+	if !ok {
+		return false
+	}
+	*p = e
+	return true
+}
+
+// ListPaged implements driver.ListPaged.
+func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	pageSize := opts.PageSize
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+	marker := azblob.Marker{}
+	if len(opts.PageToken) > 0 {
+		marker.Val = azblobString(string(opts.PageToken))
+	}
+	in := azblob.ListBlobsSegmentOptions{
+		MaxResults: int32(pageSize),
+		Prefix:     opts.Prefix,
+		Details:    azblob.BlobListingDetails{Metadata: true},
+	}
+	if opts.BeforeList != nil {
+		asFunc := func(i interface{}) bool {
+			p, ok := i.(**azblob.ListBlobsSegmentOptions)
+			if !ok {
+				return false
+			}
+			*p = &in
+			return true
+		}
+		if err := opts.BeforeList(asFunc); err != nil {
+			return nil, err
+		}
+	}
+	var items []azblob.BlobItem
+	var prefixes []azblob.BlobPrefix
+	var next azblob.Marker
+	if opts.Delimiter == "" {
+		resp, err := b.containerURL.ListBlobsFlatSegment(ctx, marker, in)
+		if err != nil {
+			return nil, err
+		}
+		items, next = resp.Segment.BlobItems, resp.NextMarker
+	} else {
+		resp, err := b.containerURL.ListBlobsHierarchySegment(ctx, marker, opts.Delimiter, in)
+		if err != nil {
+			return nil, err
+		}
+		items, prefixes, next = resp.Segment.BlobItems, resp.Segment.BlobPrefixes, resp.NextMarker
+	}
+	page := driver.ListPage{}
+	if next.NotDone() && next.Val != nil {
+		page.NextPageToken = []byte(*next.Val)
+	}
+	for _, item := range items {
+		item := item
+		page.Objects = append(page.Objects, &driver.ListObject{
+			Key:     item.Name,
+			ModTime: item.Properties.LastModified,
+			Size:    int64Value(item.Properties.ContentLength),
+			AsFunc: func(i interface{}) bool {
+				p, ok := i.(*azblob.BlobItem)
+				if !ok {
+					return false
+				}
+				*p = item
+				return true
+			},
+		})
+	}
+	for _, prefix := range prefixes {
+		prefix := prefix
+		page.Objects = append(page.Objects, &driver.ListObject{
+			Key:   prefix.Name,
+			IsDir: true,
+			AsFunc: func(i interface{}) bool {
+				p, ok := i.(*azblob.BlobPrefix)
+				if !ok {
+					return false
+				}
+				*p = prefix
+				return true
+			},
+		})
+	}
+	// Azure returns blobs and prefixes separately; merge them.
+	if len(prefixes) > 0 && len(items) > 0 {
+		sort.Slice(page.Objects, func(i, j int) bool {
+			return page.Objects[i].Key < page.Objects[j].Key
+		})
+	}
+	return &page, nil
+}
+
+// Attributes implements driver.Attributes.
+func (b *bucket) Attributes(ctx context.Context, key string) (driver.Attributes, error) {
+	resp, err := b.containerURL.NewBlobURL(key).GetProperties(ctx, azblob.BlobAccessConditions{})
+	if err != nil {
+		return driver.Attributes{}, err
+	}
+	return driver.Attributes{
+		ContentType: resp.ContentType(),
+		Metadata:    unescapeMetadata(resp.NewMetadata()),
+		ModTime:     resp.LastModified(),
+		Size:        resp.ContentLength(),
+		ETag:        string(resp.ETag()),
+		AsFunc: func(i interface{}) bool {
+			p, ok := i.(*azblob.BlobGetPropertiesResponse)
+			if !ok {
+				return false
+			}
+			*p = *resp
+			return true
+		},
+	}, nil
+}
+
+// NewRangeReader implements driver.NewRangeReader.
+func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length int64) (driver.Reader, error) {
+	count := length
+	if count < 0 {
+		count = azblob.CountToEnd
+	}
+	resp, err := b.containerURL.NewBlobURL(key).Download(ctx, offset, count, azblob.BlobAccessConditions{}, false)
+	if err != nil {
+		return nil, err
+	}
+	return &reader{
+		body: resp.Body(azblob.RetryReaderOptions{MaxRetryRequests: 3}),
+		attrs: driver.ReaderAttributes{
+			ContentType: resp.ContentType(),
+			ModTime:     resp.LastModified(),
+			Size:        getSize(resp),
+		},
+		raw: resp,
+	}, nil
+}
+
+// getSize returns the size of the whole blob from a download response.
+func getSize(resp *azblob.DownloadResponse) int64 {
+	// ContentLength is the size of the returned range; ContentRange has the
+	// full size. Sample: bytes 10-14/27 (where 27 is the full size).
+	size := resp.ContentLength()
+	if cr := resp.ContentRange(); cr != "" {
+		parts := strings.Split(cr, "/")
+		if len(parts) == 2 {
+			if i, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
+				size = i
+			}
+		}
+	}
+	return size
+}
+
+type reader struct {
+	body  io.ReadCloser
+	attrs driver.ReaderAttributes
+	raw   *azblob.DownloadResponse
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	return r.body.Read(p)
+}
+
+// Close closes the reader itself. It must be called when done reading.
+func (r *reader) Close() error {
+	return r.body.Close()
+}
+
+func (r *reader) Attributes() driver.ReaderAttributes {
+	return r.attrs
+}
+
+func (r *reader) As(i interface{}) bool {
+	p, ok := i.(*azblob.DownloadResponse)
+	if !ok {
+		return false
+	}
+	*p = *r.raw
+	return true
+}
+
+// NewTypedWriter implements driver.NewTypedWriter.
+func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	bufferSize := opts.BufferSize
+	if bufferSize == 0 {
+		bufferSize = defaultBufferSize
+	}
+	headers := azblob.BlobHTTPHeaders{
+		ContentType: contentType,
+		ContentMD5:  opts.ContentMD5,
+	}
+	if opts.BeforeWrite != nil {
+		asFunc := func(i interface{}) bool {
+			p, ok := i.(**azblob.BlobHTTPHeaders)
+			if !ok {
+				return false
+			}
+			*p = &headers
+			return true
+		}
+		if err := opts.BeforeWrite(asFunc); err != nil {
+			return nil, err
+		}
+	}
+	var prefix [16]byte
+	if _, err := rand.Read(prefix[:]); err != nil {
+		return nil, err
+	}
+	var conditions azblob.BlobAccessConditions
+	if opts.IfNotExist {
+		conditions.ModifiedAccessConditions.IfNoneMatch = azblob.ETagAny
+	}
+	w := &writer{
+		ctx:           ctx,
+		blockBlobURL:  b.containerURL.NewBlockBlobURL(key),
+		conditions:    conditions,
+		headers:       headers,
+		metadata:      escapeMetadata(opts.Metadata),
+		bufferSize:    bufferSize,
+		blockIDPrefix: hex.EncodeToString(prefix[:]),
+	}
+	if len(opts.ContentMD5) > 0 {
+		w.md5hash = md5.New()
+	}
+	return w, nil
+}
+
+// writer stages data as blocks of up to bufferSize bytes, and commits them
+// in Close. Blobs that fit in a single block are uploaded with one request.
+type writer struct {
+	ctx           context.Context
+	blockBlobURL  azblob.BlockBlobURL
+	headers       azblob.BlobHTTPHeaders
+	metadata      azblob.Metadata
+	conditions    azblob.BlobAccessConditions
+	bufferSize    int
+	blockIDPrefix string
+	md5hash       hash.Hash
+
+	buf      []byte
+	blockIDs []string
+}
+
+// Write appends p to w. User must call Close to close the w after done writing.
+func (w *writer) Write(p []byte) (int, error) {
+	if w.md5hash != nil {
+		w.md5hash.Write(p)
+	}
+	n := len(p)
+	for len(p) > 0 {
+		m := w.bufferSize - len(w.buf)
+		if m > len(p) {
+			m = len(p)
+		}
+		w.buf = append(w.buf, p[:m]...)
+		p = p[m:]
+		if len(w.buf) == w.bufferSize && len(p) > 0 {
+			if err := w.stage(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// stage uploads the buffered data as an uncommitted block.
+func (w *writer) stage() error {
+	// All block IDs for a blob must have the same length.
+	id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s-%08d", w.blockIDPrefix, len(w.blockIDs))))
+	if _, err := w.blockBlobURL.StageBlock(w.ctx, id, bytes.NewReader(w.buf), azblob.LeaseAccessConditions{}, nil); err != nil {
+		return err
+	}
+	w.blockIDs = append(w.blockIDs, id)
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Close completes the writer and closes it. Any error occurring during write
+// will be returned. Nothing is visible in the container unless Close
+// succeeds.
+func (w *writer) Close() error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	// Azure doesn't verify the content MD5 of a block list, so check it
+	// before committing anything.
+	if w.md5hash != nil {
+		if md5sum := w.md5hash.Sum(nil); !bytes.Equal(md5sum, w.headers.ContentMD5) {
+			return fmt.Errorf(
+				"the ContentMD5 you specified did not match what we received (%s != %s)",
+				base64.StdEncoding.EncodeToString(md5sum),
+				base64.StdEncoding.EncodeToString(w.headers.ContentMD5),
+			)
+		}
+	}
+	if len(w.blockIDs) == 0 {
+		_, err := w.blockBlobURL.Upload(w.ctx, bytes.NewReader(w.buf), w.headers, w.metadata, w.conditions)
+		return err
+	}
+	if len(w.buf) > 0 {
+		if err := w.stage(); err != nil {
+			return err
+		}
+	}
+	_, err := w.blockBlobURL.CommitBlockList(w.ctx, w.blockIDs, w.headers, w.metadata, w.conditions)
+	return err
+}
+
+// Delete implements driver.Delete.
+func (b *bucket) Delete(ctx context.Context, key string) error {
+	_, err := b.containerURL.NewBlobURL(key).Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
+	return err
+}
+
+// Compose implements driver.Compose.
+func (b *bucket) Compose(ctx context.Context, dst string, srcs []string, opts *driver.ComposeOptions) error {
+	return errNotImplemented
+}
+
+// SignedURL implements driver.SignedURL.
+func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
+	if b.credential == nil {
+		return "", errNotImplemented
+	}
+	sas, err := azblob.BlobSASSignatureValues{
+		Protocol:      azblob.SASProtocolHTTPS,
+		ExpiryTime:    time.Now().UTC().Add(opts.Expiry),
+		ContainerName: b.name,
+		BlobName:      key,
+		Permissions:   azblob.BlobSASPermissions{Read: true}.String(),
+	}.NewSASQueryParameters(b.credential)
+	if err != nil {
+		return "", err
+	}
+	u := b.containerURL.NewBlobURL(key).URL()
+	u.RawQuery = sas.Encode()
+	return u.String(), nil
+}
+
+// LifecycleRules implements driver.LifecycleRules.
+func (b *bucket) LifecycleRules(ctx context.Context) ([]*driver.LifecycleRule, error) {
+	// Azure lifecycle management is configured per storage account, not per
+	// container.
+	return nil, errNotImplemented
+}
+
+// SetLifecycleRules implements driver.SetLifecycleRules.
+func (b *bucket) SetLifecycleRules(ctx context.Context, rules []*driver.LifecycleRule) error {
+	return errNotImplemented
+}
+
+// CreateBucket implements driver.CreateBucket.
+func (b *bucket) CreateBucket(ctx context.Context, opts *driver.CreateBucketOptions) error {
+	return errNotImplemented
+}
+
+// DeleteBucket implements driver.DeleteBucket.
+func (b *bucket) DeleteBucket(ctx context.Context) error {
+	return errNotImplemented
+}
+
+// BucketExists implements driver.BucketExists.
+func (b *bucket) BucketExists(ctx context.Context) (bool, error) {
+	return false, errNotImplemented
+}
+
+func azblobString(s string) *string { return &s }
+
+func int64Value(p *int64) int64 {
+	if p == nil {
+		return 0
+	}
+	return *p
+}
+
+// escapeMetadata escapes metadata keys so that they're valid C# identifiers,
+// and path-escapes metadata values.
+func escapeMetadata(md map[string]string) azblob.Metadata {
+	if len(md) == 0 {
+		return nil
+	}
+	escaped := make(azblob.Metadata, len(md))
+	for k, v := range md {
+		escaped[escapeKey(k)] = url.PathEscape(v)
+	}
+	return escaped
+}
+
+// unescapeMetadata reverses escapeMetadata. Values that can't be unescaped
+// are returned as is.
+func unescapeMetadata(md azblob.Metadata) map[string]string {
+	if len(md) == 0 {
+		return nil
+	}
+	unescaped := make(map[string]string, len(md))
+	for k, v := range md {
+		if u, err := url.PathUnescape(v); err == nil {
+			v = u
+		}
+		unescaped[unescapeKey(k)] = v
+	}
+	return unescaped
+}
+
+// escapeKey escapes every byte of k that isn't allowed in a C# identifier as
+// "__0x<hex>__".
+func escapeKey(k string) string {
+	var sb strings.Builder
+	for i := 0; i < len(k); i++ {
+		c := k[i]
+		valid := c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || (i > 0 && '0' <= c && c <= '9')
+		// A literal "__0x" would be ambiguous, so escape the underscore that
+		// starts it.
+		if c == '_' && strings.HasPrefix(k[i:], "__0x") {
+			valid = false
+		}
+		if valid {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "__0x%02x__", c)
+		}
+	}
+	return sb.String()
+}
+
+// unescapeKey reverses escapeKey.
+func unescapeKey(k string) string {
+	var sb strings.Builder
+	for i := 0; i < len(k); i++ {
+		if strings.HasPrefix(k[i:], "__0x") && len(k[i:]) >= 8 && k[i+6:i+8] == "__" {
+			if c, err := hex.DecodeString(k[i+4 : i+6]); err == nil {
+				sb.Write(c)
+				i += 7
+				continue
+			}
+		}
+		sb.WriteByte(k[i])
+	}
+	return sb.String()
+}
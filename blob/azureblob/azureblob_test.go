@@ -0,0 +1,564 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/google/go-cloud/blob"
+	"github.com/google/go-cloud/blob/driver"
+	"github.com/google/go-cloud/blob/drivertest"
+	"github.com/google/go-cmp/cmp"
+)
+
+// The conformance tests run against fakeAzure, an in-process fake of the
+// parts of the Blob service REST API that the driver uses.
+const (
+	accountName   = "gocloudblobtests"
+	containerName = "go-cloud-bucket"
+)
+
+type harness struct {
+	srv        *httptest.Server
+	client     *http.Client
+	pipeline   pipeline.Pipeline
+	credential *azblob.SharedKeyCredential
+}
+
+func newHarness(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+	srv := httptest.NewServer(newFakeAzure())
+	// Send the requests for the account's endpoint to srv.
+	client := &http.Client{Transport: &redirectTransport{host: strings.TrimPrefix(srv.URL, "http://")}}
+	credential, err := azblob.NewSharedKeyCredential(accountName, base64.StdEncoding.EncodeToString([]byte("FAKE_KEY")))
+	if err != nil {
+		srv.Close()
+		return nil, err
+	}
+	p := azblob.NewPipeline(credential, azblob.PipelineOptions{
+		Retry: azblob.RetryOptions{MaxTries: 1},
+		HTTPSender: pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+			return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+				resp, err := client.Do(request.WithContext(ctx))
+				if err != nil {
+					err = pipeline.NewError(err, "HTTP request failed")
+				}
+				return pipeline.NewHTTPResponse(resp), err
+			}
+		}),
+	})
+	return &harness{srv: srv, client: client, pipeline: p, credential: credential}, nil
+}
+
+func (h *harness) HTTPClient() *http.Client {
+	return h.client
+}
+
+func (h *harness) MakeDriver(ctx context.Context) (driver.Bucket, error) {
+	return openBucket(ctx, h.pipeline, accountName, containerName, &Options{Credential: h.credential})
+}
+
+func (h *harness) Close() {
+	h.srv.Close()
+}
+
+func TestConformance(t *testing.T) {
+	drivertest.RunConformanceTests(t, newHarness, []drivertest.AsTest{verifyContentLanguage{}})
+}
+
+func TestLifecycleConformance(t *testing.T) {
+	drivertest.RunLifecycleTests(t, newHarness)
+}
+
+const language = "nl"
+
+// verifyContentLanguage uses As to access the underlying Azure types and
+// read/write the ContentLanguage field.
+type verifyContentLanguage struct{}
+
+func (verifyContentLanguage) Name() string {
+	return "verify ContentLanguage can be written and read through As"
+}
+
+func (verifyContentLanguage) BucketCheck(b *blob.Bucket) error {
+	var u *azblob.ContainerURL
+	if !b.As(&u) {
+		return errors.New("Bucket.As failed")
+	}
+	return nil
+}
+
+func (verifyContentLanguage) ErrorCheck(err error) error {
+	var e azblob.StorageError
+	if !blob.ErrorAs(err, &e) {
+		return errors.New("blob.ErrorAs failed")
+	}
+	return nil
+}
+
+func (verifyContentLanguage) BeforeWrite(as func(interface{}) bool) error {
+	var h *azblob.BlobHTTPHeaders
+	if !as(&h) {
+		return errors.New("Writer.As failed")
+	}
+	h.ContentLanguage = language
+	return nil
+}
+
+func (verifyContentLanguage) BeforeList(as func(interface{}) bool) error {
+	var o *azblob.ListBlobsSegmentOptions
+	if !as(&o) {
+		return errors.New("List.As failed")
+	}
+	// Nothing to do.
+	return nil
+}
+
+func (verifyContentLanguage) AttributesCheck(attrs *blob.Attributes) error {
+	var resp azblob.BlobGetPropertiesResponse
+	if !attrs.As(&resp) {
+		return errors.New("Attributes.As returned false")
+	}
+	if got := resp.ContentLanguage(); got != language {
+		return fmt.Errorf("got %q want %q", got, language)
+	}
+	return nil
+}
+
+func (verifyContentLanguage) ReaderCheck(r *blob.Reader) error {
+	var resp azblob.DownloadResponse
+	if !r.As(&resp) {
+		return errors.New("Reader.As returned false")
+	}
+	if got := resp.ContentLanguage(); got != language {
+		return fmt.Errorf("got %q want %q", got, language)
+	}
+	return nil
+}
+
+func (verifyContentLanguage) ListObjectCheck(o *blob.ListObject) error {
+	var item azblob.BlobItem
+	if !o.As(&item) {
+		return errors.New("ListObject.As returned false")
+	}
+	// Nothing to check.
+	return nil
+}
+
+func TestMetadataEscaping(t *testing.T) {
+	md := map[string]string{
+		"key-a":       "value-a",
+		"1st":         "first",
+		"under_score": "ok",
+		"__0xfoo":     "looks escaped",
+		"utf8":        "☺ 100%",
+	}
+	escaped := escapeMetadata(md)
+	for k := range escaped {
+		for i, c := range k {
+			if !(c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || (i > 0 && '0' <= c && c <= '9')) {
+				t.Errorf("escaped key %q is not a valid C# identifier", k)
+				break
+			}
+		}
+	}
+	if got := unescapeMetadata(escaped); !cmp.Equal(got, md) {
+		t.Errorf("got %v want %v", got, md)
+	}
+}
+
+func TestOpenBucket(t *testing.T) {
+	ctx := context.Background()
+	p := azblob.NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{})
+	tests := []struct {
+		description            string
+		pipeline               pipeline.Pipeline
+		accountName, container string
+		wantErr                bool
+	}{
+		{description: "nil pipeline", accountName: "account", container: "c", wantErr: true},
+		{description: "empty account name", pipeline: p, container: "c", wantErr: true},
+		{description: "empty container name", pipeline: p, accountName: "account", wantErr: true},
+		{description: "success", pipeline: p, accountName: "account", container: "c"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			b, err := OpenBucket(ctx, tc.pipeline, tc.accountName, tc.container, nil)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got err %v want error %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			// SignedURL requires a shared key credential.
+			if _, err := b.SignedURL(ctx, "key", nil); !blob.IsNotImplemented(err) {
+				t.Errorf("SignedURL: got %v want IsNotImplemented error", err)
+			}
+		})
+	}
+}
+
+func TestSignedURL(t *testing.T) {
+	ctx := context.Background()
+	cred, err := azblob.NewSharedKeyCredential("account", "RkFLRV9LRVk=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := azblob.NewPipeline(cred, azblob.PipelineOptions{})
+	b, err := OpenBucket(ctx, p, "account", "c", &Options{Credential: cred})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.SignedURL(ctx, "dir/my key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := azblob.NewBlobURLParts(*mustParse(t, got))
+	if parts.Host != "account.blob.core.windows.net" || parts.ContainerName != "c" || parts.BlobName != "dir/my key" {
+		t.Errorf("got URL %q with unexpected parts %+v", got, parts)
+	}
+	if parts.SAS.Signature() == "" || parts.SAS.Permissions() != "r" {
+		t.Errorf("got URL %q without a read-only SAS", got)
+	}
+}
+
+func TestOpenURL(t *testing.T) {
+	ctx := context.Background()
+	for _, env := range []string{"AZURE_STORAGE_ACCOUNT", "AZURE_STORAGE_KEY", "AZURE_STORAGE_SAS_TOKEN"} {
+		defer os.Setenv(env, os.Getenv(env))
+		os.Unsetenv(env)
+	}
+	if _, err := blob.Open(ctx, "azblob://mycontainer"); err == nil {
+		t.Error("got nil error with no account, want error")
+	}
+	os.Setenv("AZURE_STORAGE_ACCOUNT", "account")
+	if _, err := blob.Open(ctx, "azblob://mycontainer"); err == nil {
+		t.Error("got nil error with no credentials, want error")
+	}
+	os.Setenv("AZURE_STORAGE_SAS_TOKEN", "sv=2018-03-28&sig=fake")
+	if _, err := blob.Open(ctx, "azblob://mycontainer"); err != nil {
+		t.Errorf("got error %v with SAS token, want nil", err)
+	}
+	os.Setenv("AZURE_STORAGE_KEY", "RkFLRV9LRVk=")
+	if _, err := blob.Open(ctx, "azblob://mycontainer"); err != nil {
+		t.Errorf("got error %v with shared key, want nil", err)
+	}
+}
+
+func mustParse(t *testing.T, s string) *url.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// redirectTransport sends all requests to host over plain HTTP.
+type redirectTransport struct {
+	host string
+}
+
+func (t *redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r2 := new(http.Request)
+	*r2 = *r
+	u := *r.URL
+	u.Scheme = "http"
+	u.Host = t.host
+	r2.URL = &u
+	return http.DefaultTransport.RoundTrip(r2)
+}
+
+// fakeAzure is a fake of the Blob service for a single container. It
+// implements block blob uploads, reads, deletes and listings; it doesn't check
+// credentials or SAS signatures.
+type fakeAzure struct {
+	mu    sync.Mutex
+	blobs map[string]*fakeBlob
+	// blocks maps the key and ID of uncommitted blocks to their data.
+	blocks map[string][]byte
+	// n counts requests, for request IDs and ETags.
+	n int
+}
+
+type fakeBlob struct {
+	data []byte
+	// headers holds the content headers, such as Content-Type.
+	headers http.Header
+	meta    map[string]string
+	modTime time.Time
+	etag    string
+}
+
+func newFakeAzure() *fakeAzure {
+	return &fakeAzure{blobs: map[string]*fakeBlob{}, blocks: map[string][]byte{}}
+}
+
+// fakeAzureError writes an error response with code.
+func fakeAzureError(w http.ResponseWriter, r *http.Request, status int, code string) {
+	w.Header().Set("x-ms-error-code", code)
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
+
+func (s *fakeAzure) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.n++
+	w.Header().Set("x-ms-request-id", fmt.Sprintf("00000000-0000-0000-0000-%012d", s.n))
+	w.Header().Set("x-ms-version", r.Header.Get("x-ms-version"))
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if parts[0] != containerName {
+		fakeAzureError(w, r, http.StatusNotFound, "ContainerNotFound")
+		return
+	}
+	q := r.URL.Query()
+	if len(parts) == 1 || parts[1] == "" {
+		if r.Method == http.MethodGet && q.Get("restype") == "container" && q.Get("comp") == "list" {
+			s.list(w, r)
+			return
+		}
+		fakeAzureError(w, r, http.StatusBadRequest, "UnsupportedOperation")
+		return
+	}
+	key := parts[1]
+	switch {
+	case r.Method == http.MethodPut && q.Get("comp") == "block":
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			fakeAzureError(w, r, http.StatusBadRequest, "InvalidInput")
+			return
+		}
+		s.blocks[key+"\x00"+q.Get("blockid")] = data
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && q.Get("comp") == "blocklist":
+		var list struct {
+			Latest []string `xml:"Latest"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&list); err != nil {
+			fakeAzureError(w, r, http.StatusBadRequest, "InvalidXmlDocument")
+			return
+		}
+		var data []byte
+		for _, id := range list.Latest {
+			b, ok := s.blocks[key+"\x00"+id]
+			if !ok {
+				fakeAzureError(w, r, http.StatusBadRequest, "InvalidBlockList")
+				return
+			}
+			data = append(data, b...)
+		}
+		if !s.put(w, r, key, data) {
+			return
+		}
+		for id := range s.blocks {
+			if strings.HasPrefix(id, key+"\x00") {
+				delete(s.blocks, id)
+			}
+		}
+	case r.Method == http.MethodPut:
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			fakeAzureError(w, r, http.StatusBadRequest, "InvalidInput")
+			return
+		}
+		s.put(w, r, key, data)
+	case r.Method == http.MethodDelete:
+		if _, ok := s.blobs[key]; !ok {
+			fakeAzureError(w, r, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		delete(s.blobs, key)
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		s.get(w, r, key)
+	default:
+		fakeAzureError(w, r, http.StatusBadRequest, "UnsupportedOperation")
+	}
+}
+
+// put stores a blob with data and the content headers and metadata of r. It
+// reports whether it succeeded.
+func (s *fakeAzure) put(w http.ResponseWriter, r *http.Request, key string, data []byte) bool {
+	if _, ok := s.blobs[key]; ok && r.Header.Get("If-None-Match") == "*" {
+		fakeAzureError(w, r, http.StatusConflict, "BlobAlreadyExists")
+		return false
+	}
+	b := &fakeBlob{
+		data:    data,
+		headers: http.Header{},
+		meta:    map[string]string{},
+		modTime: time.Now().UTC().Truncate(time.Second),
+		etag:    fmt.Sprintf(`"0x8D6%013X"`, s.n),
+	}
+	ct := r.Header.Get("x-ms-blob-content-type")
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	b.headers.Set("Content-Type", ct)
+	for _, h := range []string{"Cache-Control", "Content-Disposition", "Content-Encoding", "Content-Language", "Content-MD5"} {
+		if v := r.Header.Get("x-ms-blob-" + strings.ToLower(h)); v != "" {
+			b.headers.Set(h, v)
+		}
+	}
+	if b.headers.Get("Content-MD5") == "" {
+		sum := md5.Sum(data)
+		b.headers.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	for k, v := range r.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-ms-meta-") {
+			b.meta[strings.TrimPrefix(lk, "x-ms-meta-")] = v[0]
+		}
+	}
+	s.blobs[key] = b
+	w.Header().Set("ETag", b.etag)
+	w.Header().Set("Last-Modified", b.modTime.Format(http.TimeFormat))
+	w.Header().Set("Content-MD5", b.headers.Get("Content-MD5"))
+	w.WriteHeader(http.StatusCreated)
+	return true
+}
+
+// get serves the properties of the blob with key, and for GET requests, its
+// content or the range of it in x-ms-range.
+func (s *fakeAzure) get(w http.ResponseWriter, r *http.Request, key string) {
+	b, ok := s.blobs[key]
+	if !ok {
+		fakeAzureError(w, r, http.StatusNotFound, "BlobNotFound")
+		return
+	}
+	h := w.Header()
+	for k, v := range b.headers {
+		h[k] = v
+	}
+	for k, v := range b.meta {
+		h.Set("x-ms-meta-"+k, v)
+	}
+	h.Set("Last-Modified", b.modTime.Format(http.TimeFormat))
+	h.Set("ETag", b.etag)
+	h.Set("x-ms-blob-type", "BlockBlob")
+	data := b.data
+	status := http.StatusOK
+	if rng := r.Header.Get("x-ms-range"); rng != "" && r.Method == http.MethodGet {
+		var start, end int64
+		end = int64(len(data)) - 1
+		bounds := strings.SplitN(strings.TrimPrefix(rng, "bytes="), "-", 2)
+		start, _ = strconv.ParseInt(bounds[0], 10, 64)
+		if len(bounds) == 2 && bounds[1] != "" {
+			if e, _ := strconv.ParseInt(bounds[1], 10, 64); e < end {
+				end = e
+			}
+		}
+		if start >= int64(len(data)) {
+			fakeAzureError(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+			return
+		}
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data = data[start : end+1]
+		status = http.StatusPartialContent
+	}
+	h.Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+// list serves a page of a listing of the container.
+func (s *fakeAzure) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix, delim, marker := q.Get("prefix"), q.Get("delimiter"), q.Get("marker")
+	max := 5000
+	if m, err := strconv.Atoi(q.Get("maxresults")); err == nil {
+		max = m
+	}
+	// names holds the blob names and prefixes to list; prefixes are the
+	// names that contain delim after prefix.
+	var names []string
+	seen := map[string]bool{}
+	for k := range s.blobs {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		name := k
+		if i := strings.Index(k[len(prefix):], delim); delim != "" && i >= 0 {
+			name = k[:len(prefix)+i+len(delim)]
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	names = names[sort.SearchStrings(names, marker):]
+	next := ""
+	if len(names) > max {
+		next = names[max]
+		names = names[:max]
+	}
+
+	var buf bytes.Buffer
+	esc := func(s string) string {
+		var b bytes.Buffer
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	fmt.Fprintf(&buf, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults ServiceEndpoint="https://%s/" ContainerName="%s">`, r.Host, containerName)
+	fmt.Fprintf(&buf, "<Prefix>%s</Prefix><Marker>%s</Marker><Delimiter>%s</Delimiter><Blobs>", esc(prefix), esc(marker), esc(delim))
+	for _, name := range names {
+		if delim != "" && strings.Contains(name[len(prefix):], delim) {
+			fmt.Fprintf(&buf, "<BlobPrefix><Name>%s</Name></BlobPrefix>", esc(name))
+			continue
+		}
+		b := s.blobs[name]
+		fmt.Fprintf(&buf, "<Blob><Name>%s</Name><Properties>", esc(name))
+		fmt.Fprintf(&buf, "<Last-Modified>%s</Last-Modified><Etag>%s</Etag>", b.modTime.Format(http.TimeFormat), esc(b.etag))
+		fmt.Fprintf(&buf, "<Content-Length>%d</Content-Length><Content-Type>%s</Content-Type>", len(b.data), esc(b.headers.Get("Content-Type")))
+		fmt.Fprintf(&buf, "<Content-Language>%s</Content-Language><Content-MD5>%s</Content-MD5>", esc(b.headers.Get("Content-Language")), esc(b.headers.Get("Content-MD5")))
+		buf.WriteString("<BlobType>BlockBlob</BlobType></Properties>")
+		if strings.Contains(q.Get("include"), "metadata") {
+			buf.WriteString("<Metadata>")
+			for k, v := range b.meta {
+				fmt.Fprintf(&buf, "<%s>%s</%s>", k, esc(v), k)
+			}
+			buf.WriteString("</Metadata>")
+		}
+		buf.WriteString("</Blob>")
+	}
+	fmt.Fprintf(&buf, "</Blobs><NextMarker>%s</NextMarker></EnumerationResults>", esc(next))
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(buf.Bytes())
+}
@@ -25,7 +25,6 @@ import (
 	"io/ioutil"
 	"log"
 	"mime"
-	"net/http"
 	"net/url"
 	"strings"
 	"sync"
@@ -37,34 +36,58 @@ import (
 // Reader implements io.ReadCloser to read a blob. It must be closed after
 // reads are finished.
 type Reader struct {
-	b driver.Bucket
-	r driver.Reader
+	b      driver.Bucket
+	r      driver.Reader
+	attrs  driver.ReaderAttributes
+	cancel func()
+	// rs is nil unless the Bucket has a RetryPolicy.
+	rs *resumer
 }
 
 // Read implements io.ReadCloser to read from this reader.
 func (r *Reader) Read(p []byte) (int, error) {
-	n, err := r.r.Read(p)
-	return n, wrapError(r.b, err)
+	for {
+		n, err := r.r.Read(p)
+		if r.rs == nil || err == nil || err == io.EOF || !r.b.IsRetryable(err) {
+			if r.rs != nil {
+				r.rs.read += int64(n)
+			}
+			return n, wrapError(r.b, err)
+		}
+		// Resume reading where the failed read stopped.
+		r.rs.read += int64(n)
+		if n > 0 {
+			r.rs.failures = 0
+		}
+		if rerr := r.rs.reopen(r); rerr != nil {
+			return n, wrapError(r.b, rerr)
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
 }
 
 // Close implements io.ReadCloser to close this reader.
 func (r *Reader) Close() error {
-	return wrapError(r.b, r.r.Close())
+	err := r.r.Close()
+	r.cancel()
+	return wrapError(r.b, err)
 }
 
 // ContentType returns the MIME type of the blob object.
 func (r *Reader) ContentType() string {
-	return r.r.Attributes().ContentType
+	return r.attrs.ContentType
 }
 
 // ModTime is the time the blob object was last modified.
 func (r *Reader) ModTime() time.Time {
-	return r.r.Attributes().ModTime
+	return r.attrs.ModTime
 }
 
 // Size returns the content size of the blob object.
 func (r *Reader) Size() int64 {
-	return r.r.Attributes().Size
+	return r.attrs.Size
 }
 
 // As converts i to provider-specific types.
@@ -88,10 +111,45 @@ type Attributes struct {
 	ModTime time.Time
 	// Size is the size of the object in bytes.
 	Size int64
+	// ETag is an opaque string that changes whenever the object is
+	// written, or empty if the provider doesn't have one. It can be used to
+	// detect changes more reliably than ModTime and Size.
+	ETag string
+	// StorageClass is the storage class of the object. It is empty if the
+	// provider doesn't have storage classes or doesn't report the object's
+	// class, or the class doesn't correspond to one of the StorageClass
+	// values; use As to get the provider's class.
+	StorageClass StorageClass
 
 	asFunc func(interface{}) bool
 }
 
+// StorageClass is a portable storage tier, trading the cost of storing
+// objects against the cost and latency of reading them. See the provider
+// documentation for how each maps to the provider's classes.
+type StorageClass string
+
+const (
+	// StorageClassStandard is for frequently read objects.
+	StorageClassStandard StorageClass = "standard"
+	// StorageClassInfrequent is for objects read less than about once a
+	// month.
+	StorageClassInfrequent StorageClass = "infrequent"
+	// StorageClassArchive is for objects that are rarely read, such as
+	// backups.
+	StorageClassArchive StorageClass = "archive"
+)
+
+// checkStorageClass returns an error if sc is neither empty nor one of the
+// StorageClass values.
+func checkStorageClass(sc StorageClass) error {
+	switch sc {
+	case "", StorageClassStandard, StorageClassInfrequent, StorageClassArchive:
+		return nil
+	}
+	return fmt.Errorf("StorageClass %q is not one of %q, %q or %q", sc, StorageClassStandard, StorageClassInfrequent, StorageClassArchive)
+}
+
 // As converts i to provider-specific types.
 // See Bucket.As for more details.
 func (a *Attributes) As(i interface{}) bool {
@@ -135,8 +193,9 @@ func (w *Writer) Write(p []byte) (n int, err error) {
 		return n, wrapError(w.b, err)
 	}
 
-	// If w is not yet created due to no content-type being passed in, try to sniff
-	// the MIME type based on at most 512 bytes of the blob content of p.
+	// If w is not yet created due to no content-type being passed in, try to detect
+	// the MIME type based on the key's extension or at most 512 bytes of the blob
+	// content of p.
 
 	// Detect the content-type directly if the first chunk is at least 512 bytes.
 	if w.buf.Len() == 0 && len(p) >= sniffLen {
@@ -165,10 +224,10 @@ func (w *Writer) Close() error {
 	return wrapError(w.b, w.w.Close())
 }
 
-// open tries to detect the MIME type of p and write it to the blob.
-// The error it returns is wrapped.
+// open tries to detect the MIME type of the blob from its key or p, and
+// writes p to it. The error it returns is wrapped.
 func (w *Writer) open(p []byte) (int, error) {
-	ct := http.DetectContentType(p)
+	ct := DetectContentType(w.key, p)
 	var err error
 	if w.w, err = w.b.NewTypedWriter(w.ctx, w.key, ct, w.opts); err != nil {
 		return 0, wrapError(w.b, err)
@@ -209,6 +268,7 @@ type ListOptions struct {
 // ListIterator is used to iterate over List results.
 type ListIterator struct {
 	b       driver.Bucket
+	retry   *RetryPolicy
 	opts    *driver.ListOptions
 	page    *driver.ListPage
 	nextIdx int
@@ -239,7 +299,7 @@ func (i *ListIterator) Next(ctx context.Context) (*ListObject, error) {
 		i.opts.PageToken = i.page.NextPageToken
 	}
 	// Loading a new page.
-	p, err := i.b.ListPaged(ctx, i.opts)
+	p, err := i.loadPage(ctx)
 	if err != nil {
 		return nil, wrapError(i.b, err)
 	}
@@ -248,6 +308,20 @@ func (i *ListIterator) Next(ctx context.Context) (*ListObject, error) {
 	return i.Next(ctx)
 }
 
+// loadPage fetches the page for i.opts. The retry policy's Timeout applies
+// to each page.
+func (i *ListIterator) loadPage(ctx context.Context) (*driver.ListPage, error) {
+	ctx, cancel := i.retry.withTimeout(ctx)
+	defer cancel()
+	var p *driver.ListPage
+	err := i.retry.call(ctx, i.b, func() error {
+		var err error
+		p, err = i.b.ListPaged(ctx, i.opts)
+		return err
+	})
+	return p, err
+}
+
 // ListObject represents a single blob object returned from List.
 type ListObject struct {
 	// Key is the key for this blob.
@@ -277,7 +351,8 @@ func (o *ListObject) As(i interface{}) bool {
 // Bucket manages the underlying blob service and provides read, write and delete
 // operations on objects within it.
 type Bucket struct {
-	b driver.Bucket
+	b     driver.Bucket
+	retry *RetryPolicy
 }
 
 // NewBucket creates a new Bucket for a group of objects for a blob service.
@@ -334,12 +409,19 @@ func (b *Bucket) List(opts *ListOptions) *ListIterator {
 		Delimiter:  opts.Delimiter,
 		BeforeList: opts.BeforeList,
 	}
-	return &ListIterator{b: b.b, opts: dopts}
+	return &ListIterator{b: b.b, retry: b.retry, opts: dopts}
 }
 
 // Attributes reads attributes for the given key.
 func (b *Bucket) Attributes(ctx context.Context, key string) (Attributes, error) {
-	a, err := b.b.Attributes(ctx, key)
+	ctx, cancel := b.retry.withTimeout(ctx)
+	defer cancel()
+	var a driver.Attributes
+	err := b.retry.call(ctx, b.b, func() error {
+		var err error
+		a, err = b.b.Attributes(ctx, key)
+		return err
+	})
 	if err != nil {
 		return Attributes{}, wrapError(b.b, err)
 	}
@@ -354,11 +436,13 @@ func (b *Bucket) Attributes(ctx context.Context, key string) (Attributes, error)
 		}
 	}
 	return Attributes{
-		ContentType: a.ContentType,
-		Metadata:    md,
-		ModTime:     a.ModTime,
-		Size:        a.Size,
-		asFunc:      a.AsFunc,
+		ContentType:  a.ContentType,
+		Metadata:     md,
+		ModTime:      a.ModTime,
+		Size:         a.Size,
+		ETag:         a.ETag,
+		StorageClass: StorageClass(a.StorageClass),
+		asFunc:       a.AsFunc,
 	}, nil
 }
 
@@ -387,11 +471,15 @@ func (b *Bucket) NewRangeReader(ctx context.Context, key string, offset, length
 	if length == 0 {
 		return nil, errors.New("blob.NewRangeReader: length cannot be 0")
 	}
-	r, err := b.b.NewRangeReader(ctx, key, offset, length)
+	r, cancel, err := b.openReader(ctx, key, offset, length)
 	if err != nil {
 		return nil, wrapError(b.b, err)
 	}
-	return &Reader{b: b.b, r: r}, nil
+	br := &Reader{b: b.b, r: r, attrs: r.Attributes(), cancel: cancel}
+	if b.retry != nil {
+		br.rs = &resumer{ctx: ctx, bucket: b, key: key, offset: offset, length: length}
+	}
+	return br, nil
 }
 
 // WriteAll is a shortcut for creating a Writer via NewWriter and writing p.
@@ -425,28 +513,21 @@ func (b *Bucket) NewWriter(ctx context.Context, key string, opts *WriterOptions)
 	if opts == nil {
 		opts = &WriterOptions{}
 	}
+	if err := checkStorageClass(opts.StorageClass); err != nil {
+		return nil, fmt.Errorf("blob.NewWriter: WriterOptions.%v", err)
+	}
 	dopts = &driver.WriterOptions{
-		ContentMD5:  opts.ContentMD5,
-		BufferSize:  opts.BufferSize,
-		BeforeWrite: opts.BeforeWrite,
+		ContentMD5:   opts.ContentMD5,
+		BufferSize:   opts.BufferSize,
+		IfNotExist:   opts.IfNotExist,
+		StorageClass: driver.StorageClass(opts.StorageClass),
+		BeforeWrite:  opts.BeforeWrite,
 	}
-	if len(opts.Metadata) > 0 {
-		// Providers are inconsistent, but at least some treat keys
-		// as case-insensitive. To make the behavior consistent, we
-		// force-lowercase them when writing and reading.
-		md := make(map[string]string, len(opts.Metadata))
-		for k, v := range opts.Metadata {
-			if k == "" {
-				return nil, errors.New("blob.NewWriter: WriterOptions.Metadata keys may not be empty strings")
-			}
-			lowerK := strings.ToLower(k)
-			if _, found := md[lowerK]; found {
-				return nil, fmt.Errorf("blob.NewWriter: duplicate case-insensitive metadata key %q", lowerK)
-			}
-			md[lowerK] = v
-		}
-		dopts.Metadata = md
+	md, err := lowercaseMetadata(opts.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("blob.NewWriter: WriterOptions.%v", err)
 	}
+	dopts.Metadata = md
 	if opts.ContentType != "" {
 		t, p, err := mime.ParseMediaType(opts.ContentType)
 		if err != nil {
@@ -468,10 +549,150 @@ func (b *Bucket) NewWriter(ctx context.Context, key string, opts *WriterOptions)
 	}, nil
 }
 
+// lowercaseMetadata returns a copy of md with lowercased keys, or nil if md is
+// empty. Providers are inconsistent, but at least some treat keys as
+// case-insensitive. To make the behavior consistent, we force-lowercase them
+// when writing and reading.
+func lowercaseMetadata(md map[string]string) (map[string]string, error) {
+	if len(md) == 0 {
+		return nil, nil
+	}
+	lower := make(map[string]string, len(md))
+	for k, v := range md {
+		if k == "" {
+			return nil, errors.New("Metadata keys may not be empty strings")
+		}
+		lowerK := strings.ToLower(k)
+		if _, found := lower[lowerK]; found {
+			return nil, fmt.Errorf("Metadata has duplicate case-insensitive key %q", lowerK)
+		}
+		lower[lowerK] = v
+	}
+	return lower, nil
+}
+
+// Compose creates or replaces the object dst with the concatenation of the
+// objects srcs, in order. dst may be one of srcs.
+//
+// Providers that support it compose the object server-side, without
+// downloading the sources; otherwise, or if the sources don't meet the
+// provider's requirements (for example, S3 requires every source except the
+// last to be at least 5 MiB), Compose streams the sources through this
+// process into a new Writer for dst.
+//
+// Compose returns an error if any of srcs does not exist, which can be
+// checked by calling IsNotExist.
+func (b *Bucket) Compose(ctx context.Context, dst string, srcs []string, opts *ComposeOptions) error {
+	if len(srcs) == 0 {
+		return errors.New("blob.Compose: srcs must not be empty")
+	}
+	if opts == nil {
+		opts = &ComposeOptions{}
+	}
+	md, err := lowercaseMetadata(opts.Metadata)
+	if err != nil {
+		return fmt.Errorf("blob.Compose: ComposeOptions.%v", err)
+	}
+	dopts := &driver.ComposeOptions{Metadata: md}
+	if opts.ContentType != "" {
+		t, p, err := mime.ParseMediaType(opts.ContentType)
+		if err != nil {
+			return err
+		}
+		dopts.ContentType = mime.FormatMediaType(t, p)
+	} else {
+		a, err := b.b.Attributes(ctx, srcs[0])
+		if err != nil {
+			return wrapError(b.b, err)
+		}
+		dopts.ContentType = a.ContentType
+	}
+	if dopts.ContentType == "" {
+		// The first source has no content type (e.g., httpblob without a
+		// Content-Type header); detect it as NewWriter would.
+		head, err := b.readHead(ctx, srcs[0])
+		if err != nil {
+			return wrapError(b.b, err)
+		}
+		dopts.ContentType = DetectContentType(dst, head)
+	}
+	err = b.b.Compose(ctx, dst, srcs, dopts)
+	if err == nil || !b.b.IsNotImplemented(err) {
+		return wrapError(b.b, err)
+	}
+	return b.composeByStreaming(ctx, dst, srcs, dopts)
+}
+
+// readHead returns up to the first 512 bytes of key, enough for
+// DetectContentType.
+func (b *Bucket) readHead(ctx context.Context, key string) ([]byte, error) {
+	r, err := b.b.NewRangeReader(ctx, key, 0, 512)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// composeByStreaming implements Compose by reading each of srcs in turn and
+// writing them to a single Writer for dst.
+func (b *Bucket) composeByStreaming(ctx context.Context, dst string, srcs []string, opts *driver.ComposeOptions) error {
+	// Canceling ctx aborts the write if any source fails.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := b.b.NewTypedWriter(ctx, dst, opts.ContentType, &driver.WriterOptions{Metadata: opts.Metadata})
+	if err != nil {
+		return wrapError(b.b, err)
+	}
+	copySrc := func(src string) error {
+		r, err := b.b.NewRangeReader(ctx, src, 0, -1)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		_, err = io.Copy(w, r)
+		return err
+	}
+	for _, src := range srcs {
+		if err := copySrc(src); err != nil {
+			cancel()
+			_ = w.Close()
+			return wrapError(b.b, err)
+		}
+	}
+	return wrapError(b.b, w.Close())
+}
+
+// ComposeOptions controls the object created by Compose.
+type ComposeOptions struct {
+	// ContentType specifies the MIME type of the composed object.
+	// If empty, the content type of the first source is used; if that is
+	// also empty, it is detected from dst and the first source's content
+	// using DetectContentType.
+	ContentType string
+	// Metadata holds key/value strings to be associated with the composed
+	// object. Metadata of the sources is not copied.
+	// Keys may not be empty, and are lowercased before being written.
+	// Duplicate case-insensitive keys (e.g., "foo" and "FOO") are an error.
+	Metadata map[string]string
+}
+
 // Delete deletes the object associated with key. It returns an error if that
 // object does not exist, which can be checked by calling IsNotExist.
 func (b *Bucket) Delete(ctx context.Context, key string) error {
-	return wrapError(b.b, b.b.Delete(ctx, key))
+	ctx, cancel := b.retry.withTimeout(ctx)
+	defer cancel()
+	attempts := 0
+	err := b.retry.call(ctx, b.b, func() error {
+		attempts++
+		err := b.b.Delete(ctx, key)
+		if attempts > 1 && b.b.IsNotExist(err) {
+			// An earlier attempt may have deleted the object before failing.
+			return nil
+		}
+		return err
+	})
+	return wrapError(b.b, err)
 }
 
 // SignedURL returns a URL that can be used to GET the blob for the duration
@@ -495,6 +716,109 @@ func (b *Bucket) SignedURL(ctx context.Context, key string, opts *SignedURLOptio
 	return url, wrapError(b.b, err)
 }
 
+// LifecycleRules returns the lifecycle rules configured for the bucket.
+// Rules configured outside of this package that can't be represented as a
+// LifecycleRule are omitted.
+// If IsNotImplemented returns true for the returned error, the provider does
+// not support lifecycle rules.
+func (b *Bucket) LifecycleRules(ctx context.Context) ([]*LifecycleRule, error) {
+	drules, err := b.b.LifecycleRules(ctx)
+	if err != nil {
+		return nil, wrapError(b.b, err)
+	}
+	var rules []*LifecycleRule
+	for _, r := range drules {
+		rules = append(rules, &LifecycleRule{Prefix: r.Prefix, DeleteAfterDays: r.DeleteAfterDays})
+	}
+	return rules, nil
+}
+
+// SetLifecycleRules replaces all of the lifecycle rules configured for the
+// bucket with rules. Pass no rules to remove all lifecycle rules.
+//
+// Providers enforce the rules asynchronously, so objects may remain readable
+// for some time after they have expired.
+// If IsNotImplemented returns true for the returned error, the provider does
+// not support lifecycle rules, or doesn't support a non-empty
+// LifecycleRule.Prefix; the existing rules are left unchanged.
+func (b *Bucket) SetLifecycleRules(ctx context.Context, rules []*LifecycleRule) error {
+	var drules []*driver.LifecycleRule
+	for _, r := range rules {
+		if r.DeleteAfterDays <= 0 {
+			return fmt.Errorf("blob.SetLifecycleRules: LifecycleRule.DeleteAfterDays must be > 0, got %d", r.DeleteAfterDays)
+		}
+		drules = append(drules, &driver.LifecycleRule{Prefix: r.Prefix, DeleteAfterDays: r.DeleteAfterDays})
+	}
+	return wrapError(b.b, b.b.SetLifecycleRules(ctx, drules))
+}
+
+// LifecycleRule describes an action that the provider takes automatically on
+// objects in a bucket.
+type LifecycleRule struct {
+	// Prefix restricts the rule to objects whose keys start with Prefix.
+	// An empty Prefix applies the rule to all objects.
+	// Not all providers support it; see SetLifecycleRules.
+	Prefix string
+	// DeleteAfterDays is the number of days after an object is written that it
+	// is deleted. It must be > 0.
+	DeleteAfterDays int
+}
+
+// CreateBucket creates the bucket that b refers to, for example in test setup
+// code. It returns an error if the bucket already exists.
+//
+// Most providers don't check that a bucket exists when it is opened; fileblob
+// needs Options.AllowMissingDir.
+// If IsNotImplemented returns true for the returned error, the provider does
+// not support creating buckets.
+func (b *Bucket) CreateBucket(ctx context.Context, opts *CreateBucketOptions) error {
+	if opts == nil {
+		opts = &CreateBucketOptions{}
+	}
+	if err := checkStorageClass(opts.StorageClass); err != nil {
+		return fmt.Errorf("blob.CreateBucket: CreateBucketOptions.%v", err)
+	}
+	dopts := &driver.CreateBucketOptions{
+		Location:     opts.Location,
+		StorageClass: driver.StorageClass(opts.StorageClass),
+	}
+	return wrapError(b.b, b.b.CreateBucket(ctx, dopts))
+}
+
+// DeleteBucket deletes the bucket that b refers to. The bucket must be empty.
+// If the bucket does not exist, DeleteBucket returns an error for which
+// IsNotExist returns true.
+// If IsNotImplemented returns true for the returned error, the provider does
+// not support deleting buckets.
+func (b *Bucket) DeleteBucket(ctx context.Context) error {
+	return wrapError(b.b, b.b.DeleteBucket(ctx))
+}
+
+// BucketExists returns true if the bucket that b refers to exists.
+// If IsNotImplemented returns true for the returned error, the provider does
+// not support checking whether buckets exist.
+func (b *Bucket) BucketExists(ctx context.Context) (bool, error) {
+	exists, err := b.b.BucketExists(ctx)
+	if err != nil {
+		return false, wrapError(b.b, err)
+	}
+	return exists, nil
+}
+
+// CreateBucketOptions controls the bucket created by CreateBucket. The fields
+// are hints; providers ignore those that they don't support.
+type CreateBucketOptions struct {
+	// Location is a provider-specific region or location for the bucket,
+	// such as "us-west-2" for S3 or "US" for GCS.
+	// If empty, the provider's default is used.
+	Location string
+	// StorageClass is the default storage class for objects in the bucket.
+	// Providers use their closest class, and ignore it if they don't have
+	// per-bucket classes.
+	// If empty, the provider's default is used.
+	StorageClass StorageClass
+}
+
 // DefaultSignedURLExpiry is the default duration for SignedURLOptions.Expiry.
 const DefaultSignedURLExpiry = 1 * time.Hour
 
@@ -521,8 +845,8 @@ type WriterOptions struct {
 	BufferSize int
 
 	// ContentType specifies the MIME type of the object being written. If not set,
-	// then it will be inferred from the content using the algorithm described at
-	// http://mimesniff.spec.whatwg.org/
+	// then it will be inferred from the extension of the key, or else from the
+	// content; see DetectContentType.
 	ContentType string
 
 	// ContentMD5 may be used as a message integrity check (MIC).
@@ -534,6 +858,19 @@ type WriterOptions struct {
 	// Duplicate case-insensitive keys (e.g., "foo" and "FOO") are an error.
 	Metadata map[string]string
 
+	// IfNotExist makes the write fail if an object with the key already
+	// exists, leaving that object unchanged. Since Writer may defer creating
+	// the object, the error can be returned from NewWriter, Write or Close.
+	// If IsNotImplemented returns true for the error, the provider does not
+	// support conditional writes.
+	IfNotExist bool
+
+	// StorageClass is the storage class of the object. Providers use their
+	// closest class, and ignore it if they don't have storage classes.
+	// If not set, the bucket's default class is used. Other values than the
+	// StorageClass constants are an error.
+	StorageClass StorageClass
+
 	// BeforeWrite is a callback that will be called exactly once, before
 	// any data is written (unless NewWriter returns an error, in which case
 	// it will not be called at all). Note that this is not necessarily during
@@ -625,7 +962,8 @@ func (w *wrappedError) Error() string {
 	return "blob: " + w.err.Error()
 }
 
-// IsNotExist returns true iff err indicates that the referenced blob does not exist.
+// IsNotExist returns true iff err indicates that the referenced blob, or the
+// bucket itself, does not exist.
 func IsNotExist(err error) bool {
 	if e, ok := err.(*wrappedError); ok {
 		return e.b.IsNotExist(e.err)
@@ -19,6 +19,7 @@ import (
 	"errors"
 	"io"
 	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cloud/blob/driver"
@@ -98,6 +99,10 @@ func (r *fakeErrorReader) Close() error {
 	return errFake
 }
 
+func (r *fakeErrorReader) Attributes() driver.ReaderAttributes {
+	return driver.ReaderAttributes{}
+}
+
 type fakeErrorWriter struct {
 	driver.Writer
 }
@@ -136,10 +141,38 @@ func (b *fakeErrorer) Delete(ctx context.Context, key string) error {
 	return errFake
 }
 
+func (b *fakeErrorer) IsNotImplemented(err error) bool {
+	return false
+}
+
+func (b *fakeErrorer) Compose(ctx context.Context, dst string, srcs []string, opts *driver.ComposeOptions) error {
+	return errFake
+}
+
 func (b *fakeErrorer) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
 	return "", errFake
 }
 
+func (b *fakeErrorer) LifecycleRules(ctx context.Context) ([]*driver.LifecycleRule, error) {
+	return nil, errFake
+}
+
+func (b *fakeErrorer) SetLifecycleRules(ctx context.Context, rules []*driver.LifecycleRule) error {
+	return errFake
+}
+
+func (b *fakeErrorer) CreateBucket(ctx context.Context, opts *driver.CreateBucketOptions) error {
+	return errFake
+}
+
+func (b *fakeErrorer) DeleteBucket(ctx context.Context) error {
+	return errFake
+}
+
+func (b *fakeErrorer) BucketExists(ctx context.Context) (bool, error) {
+	return false, errFake
+}
+
 // TestErrorsAreWrapped tests that all errors returned from the driver are
 // wrapped exactly once by the concrete type.
 func TestErrorsAreWrapped(t *testing.T) {
@@ -186,8 +219,160 @@ func TestErrorsAreWrapped(t *testing.T) {
 	err = b.Delete(ctx, "")
 	verifyWrap("Delete", err)
 
+	err = b.Compose(ctx, "", []string{""}, &ComposeOptions{ContentType: "foo"})
+	verifyWrap("Compose", err)
+
 	_, err = b.SignedURL(ctx, "", nil)
 	verifyWrap("SignedURL", err)
+
+	_, err = b.LifecycleRules(ctx)
+	verifyWrap("LifecycleRules", err)
+
+	err = b.SetLifecycleRules(ctx, nil)
+	verifyWrap("SetLifecycleRules", err)
+
+	err = b.CreateBucket(ctx, nil)
+	verifyWrap("CreateBucket", err)
+
+	err = b.DeleteBucket(ctx)
+	verifyWrap("DeleteBucket", err)
+
+	_, err = b.BucketExists(ctx)
+	verifyWrap("BucketExists", err)
+}
+
+// fakeComposer records the arguments passed to driver.Bucket.Compose. Every
+// source has content type contentType and content content.
+type fakeComposer struct {
+	driver.Bucket
+	contentType string
+	content     string
+	dst         string
+	srcs        []string
+	opts        *driver.ComposeOptions
+}
+
+func (b *fakeComposer) Attributes(ctx context.Context, key string) (driver.Attributes, error) {
+	return driver.Attributes{ContentType: b.contentType, Size: int64(len(b.content))}, nil
+}
+
+func (b *fakeComposer) NewRangeReader(ctx context.Context, key string, offset, length int64) (driver.Reader, error) {
+	content := b.content[offset:]
+	if length >= 0 && length < int64(len(content)) {
+		content = content[:length]
+	}
+	return &fakeComposerReader{Reader: strings.NewReader(content)}, nil
+}
+
+type fakeComposerReader struct {
+	io.Reader
+}
+
+func (r *fakeComposerReader) Close() error                        { return nil }
+func (r *fakeComposerReader) Attributes() driver.ReaderAttributes { return driver.ReaderAttributes{} }
+func (r *fakeComposerReader) As(i interface{}) bool               { return false }
+
+func (b *fakeComposer) Compose(ctx context.Context, dst string, srcs []string, opts *driver.ComposeOptions) error {
+	b.dst, b.srcs, b.opts = dst, srcs, opts
+	return nil
+}
+
+// TestComposeOptions verifies the arguments Bucket.Compose passes to the
+// driver.
+func TestComposeOptions(t *testing.T) {
+	ctx := context.Background()
+	fc := &fakeComposer{contentType: "text/plain"}
+	b := NewBucket(fc)
+
+	if err := b.Compose(ctx, "dst", nil, nil); err == nil {
+		t.Error("got nil error for empty srcs, want error")
+	}
+	if err := b.Compose(ctx, "dst", []string{"a"}, &ComposeOptions{Metadata: map[string]string{"": "x"}}); err == nil {
+		t.Error("got nil error for empty metadata key, want error")
+	}
+
+	srcs := []string{"b", "a", "c"}
+	if err := b.Compose(ctx, "dst", srcs, &ComposeOptions{Metadata: map[string]string{"Foo": "bar"}}); err != nil {
+		t.Fatal(err)
+	}
+	if fc.dst != "dst" {
+		t.Errorf("got dst %q want %q", fc.dst, "dst")
+	}
+	if diff := cmp.Diff(fc.srcs, srcs); diff != "" {
+		t.Errorf("got srcs diff %s", diff)
+	}
+	want := &driver.ComposeOptions{ContentType: "text/plain", Metadata: map[string]string{"foo": "bar"}}
+	if diff := cmp.Diff(fc.opts, want); diff != "" {
+		t.Errorf("got opts diff %s", diff)
+	}
+
+	// Without a content type for the first source, it's detected from dst
+	// and the content.
+	fc = &fakeComposer{content: "<html><body>hello</body></html>"}
+	b = NewBucket(fc)
+	for _, test := range []struct {
+		dst, want string
+	}{
+		{"dst.json", "application/json"},
+		{"dst", "text/html; charset=utf-8"},
+	} {
+		if err := b.Compose(ctx, test.dst, srcs, nil); err != nil {
+			t.Fatal(err)
+		}
+		if fc.opts.ContentType != test.want {
+			t.Errorf("%s: got ContentType %q want %q", test.dst, fc.opts.ContentType, test.want)
+		}
+	}
+}
+
+// fakeOptionsRecorder records the options passed to NewTypedWriter and
+// CreateBucket.
+type fakeOptionsRecorder struct {
+	driver.Bucket
+	writerOpts *driver.WriterOptions
+	bucketOpts *driver.CreateBucketOptions
+}
+
+func (b *fakeOptionsRecorder) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	b.writerOpts = opts
+	return &fakeErrorWriter{}, nil
+}
+
+func (b *fakeOptionsRecorder) CreateBucket(ctx context.Context, opts *driver.CreateBucketOptions) error {
+	b.bucketOpts = opts
+	return nil
+}
+
+// TestStorageClass verifies that only the StorageClass values are passed to
+// the driver.
+func TestStorageClass(t *testing.T) {
+	ctx := context.Background()
+	fr := &fakeOptionsRecorder{}
+	b := NewBucket(fr)
+	for _, sc := range []StorageClass{"", StorageClassStandard, StorageClassInfrequent, StorageClassArchive} {
+		if _, err := b.NewWriter(ctx, "key", &WriterOptions{ContentType: "text/plain", StorageClass: sc}); err != nil {
+			t.Errorf("NewWriter with %q: %v", sc, err)
+		} else if got := fr.writerOpts.StorageClass; got != driver.StorageClass(sc) {
+			t.Errorf("NewWriter with %q: driver got %q", sc, got)
+		}
+		if err := b.CreateBucket(ctx, &CreateBucketOptions{StorageClass: sc}); err != nil {
+			t.Errorf("CreateBucket with %q: %v", sc, err)
+		} else if got := fr.bucketOpts.StorageClass; got != driver.StorageClass(sc) {
+			t.Errorf("CreateBucket with %q: driver got %q", sc, got)
+		}
+	}
+	for _, sc := range []StorageClass{"Infrequent", "GLACIER"} {
+		fr.writerOpts, fr.bucketOpts = nil, nil
+		if _, err := b.NewWriter(ctx, "key", &WriterOptions{ContentType: "text/plain", StorageClass: sc}); err == nil {
+			t.Errorf("NewWriter with %q: got nil error, want error", sc)
+		}
+		if err := b.CreateBucket(ctx, &CreateBucketOptions{StorageClass: sc}); err == nil {
+			t.Errorf("CreateBucket with %q: got nil error, want error", sc)
+		}
+		if fr.writerOpts != nil || fr.bucketOpts != nil {
+			t.Errorf("%q was passed to the driver", sc)
+		}
+	}
 }
 
 // TestOpen tests blob.Open.
@@ -0,0 +1,331 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package blobcopy copies objects between blob.Buckets, which may belong to
+// different providers; for example, to migrate data from S3 to GCS:
+//   src, err := blob.Open(ctx, "s3://my-old-bucket")
+//   ...
+//   dst, err := blob.Open(ctx, "gs://my-new-bucket")
+//   ...
+//   res, err := blobcopy.Copy(ctx, dst, src, &blobcopy.Options{
+//       SrcPrefix: "logs/",
+//       DstPrefix: "logs/",
+//       Manifest:  "copy-logs.manifest",
+//   })
+//
+// The content type and metadata of each object are preserved, as far as the
+// destination supports them; so is the storage class, if
+// Options.CopyStorageClass is set. Other attributes, such as the
+// modification time, are set by the destination.
+//
+// The blobcopy command in the cmd/blobcopy subdirectory runs Copy from the
+// command line.
+package blobcopy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/go-cloud/blob"
+)
+
+// DefaultConcurrency is the default for Options.Concurrency.
+const DefaultConcurrency = 8
+
+// Verify is a way of checking that an object was copied correctly.
+type Verify int
+
+const (
+	// VerifyNone doesn't check copied objects.
+	VerifyNone Verify = iota
+	// VerifySize checks that the size of each copied object matches the
+	// source.
+	VerifySize
+	// VerifyMD5 checks the size of each copied object, and reads it back to
+	// check that its MD5 hash matches the content read from the source.
+	VerifyMD5
+)
+
+// Options controls Copy.
+type Options struct {
+	// SrcPrefix selects the objects in the source bucket whose keys start
+	// with it.
+	SrcPrefix string
+	// DstPrefix replaces SrcPrefix in the keys of the copied objects.
+	DstPrefix string
+	// Concurrency is the maximum number of objects copied at once. If zero,
+	// DefaultConcurrency is used.
+	Concurrency int
+	// Manifest, if not empty, is the path of a file that records the keys
+	// of the source objects that have been copied. Copy creates it if
+	// needed, skips the objects it lists, and appends to it as objects are
+	// copied, so that a failed or interrupted copy can be resumed by calling
+	// Copy again with the same Manifest.
+	Manifest string
+	// Verify sets how copied objects are checked. The default is VerifyNone.
+	Verify Verify
+	// CopyStorageClass makes each copy have the storage class of its source
+	// object. By default, copies get the destination bucket's default
+	// class.
+	CopyStorageClass bool
+}
+
+// Result reports what Copy did.
+type Result struct {
+	// Copied is the number of objects copied.
+	Copied int
+	// Skipped is the number of objects skipped because they were listed in
+	// the manifest.
+	Skipped int
+	// Bytes is the total size of the copied objects.
+	Bytes int64
+}
+
+// Copy copies the objects in src whose keys start with opts.SrcPrefix to
+// dst, replacing opts.SrcPrefix with opts.DstPrefix in their keys.
+//
+// Copy stops at the first error, which includes the key of the object that
+// failed; the returned Result counts the objects copied before then.
+func Copy(ctx context.Context, dst, src *blob.Bucket, opts *Options) (*Result, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	n := opts.Concurrency
+	if n <= 0 {
+		n = DefaultConcurrency
+	}
+	var m *manifest
+	if opts.Manifest != "" {
+		var err error
+		if m, err = openManifest(opts.Manifest); err != nil {
+			return &Result{}, err
+		}
+		defer m.close()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu       sync.Mutex
+		res      Result
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	keys := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				dstKey := opts.DstPrefix + strings.TrimPrefix(key, opts.SrcPrefix)
+				size, err := copyObject(ctx, dst, dstKey, src, key, opts)
+				if err == nil && m != nil {
+					err = m.add(key)
+				}
+				if err != nil {
+					fail(fmt.Errorf("blobcopy: %q: %v", key, err))
+					continue
+				}
+				mu.Lock()
+				res.Copied++
+				res.Bytes += size
+				mu.Unlock()
+			}
+		}()
+	}
+
+	iter := src.List(&blob.ListOptions{Prefix: opts.SrcPrefix})
+list:
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fail(fmt.Errorf("blobcopy: listing: %v", err))
+			break
+		}
+		if m != nil && m.has(obj.Key) {
+			mu.Lock()
+			res.Skipped++
+			mu.Unlock()
+			continue
+		}
+		select {
+		case keys <- obj.Key:
+		case <-ctx.Done():
+			break list
+		}
+	}
+	close(keys)
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
+	return &res, firstErr
+}
+
+// copyObject copies the object srcKey in src to dstKey in dst, and returns
+// its size.
+func copyObject(ctx context.Context, dst *blob.Bucket, dstKey string, src *blob.Bucket, srcKey string, opts *Options) (int64, error) {
+	attrs, err := src.Attributes(ctx, srcKey)
+	if err != nil {
+		return 0, err
+	}
+	r, err := src.NewReader(ctx, srcKey)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	// Canceling wctx aborts the write if the source can't be read, so that a
+	// truncated copy isn't committed.
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	wopts := &blob.WriterOptions{
+		ContentType: attrs.ContentType,
+		Metadata:    attrs.Metadata,
+	}
+	if opts.CopyStorageClass {
+		wopts.StorageClass = attrs.StorageClass
+	}
+	w, err := dst.NewWriter(wctx, dstKey, wopts)
+	if err != nil {
+		return 0, err
+	}
+	h := md5.New()
+	size, err := io.Copy(io.MultiWriter(w, h), r)
+	if err != nil {
+		cancel()
+		w.Close()
+		return 0, err
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+	if opts.Verify == VerifyNone {
+		return size, nil
+	}
+
+	dattrs, err := dst.Attributes(ctx, dstKey)
+	if err != nil {
+		return 0, err
+	}
+	if dattrs.Size != size {
+		return 0, fmt.Errorf("copied %d bytes, but the copy has size %d", size, dattrs.Size)
+	}
+	if opts.Verify == VerifyMD5 {
+		dr, err := dst.NewReader(ctx, dstKey)
+		if err != nil {
+			return 0, err
+		}
+		defer dr.Close()
+		dh := md5.New()
+		if _, err := io.Copy(dh, dr); err != nil {
+			return 0, err
+		}
+		if !bytes.Equal(dh.Sum(nil), h.Sum(nil)) {
+			return 0, errors.New("MD5 of the copy does not match the source")
+		}
+	}
+	return size, nil
+}
+
+// manifest is a file listing the keys of copied objects, one quoted key per
+// line.
+type manifest struct {
+	mu   sync.Mutex
+	f    *os.File
+	done map[string]bool
+}
+
+func openManifest(path string) (*manifest, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{f: f, done: map[string]bool{}}
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		key, err := strconv.Unquote(s.Text())
+		if err != nil {
+			// A line cut short by an interrupted write; its object will be
+			// copied again.
+			continue
+		}
+		m.done[key] = true
+	}
+	if err := s.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("blobcopy: reading manifest: %v", err)
+	}
+	// Start a new line after one cut short, so that it doesn't run into the
+	// next key.
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if size := info.Size(); size > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, size-1); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if last[0] != '\n' {
+			if _, err := f.WriteString("\n"); err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+	}
+	return m, nil
+}
+
+func (m *manifest) has(key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.done[key]
+}
+
+func (m *manifest) add(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.done[key] = true
+	_, err := m.f.WriteString(strconv.Quote(key) + "\n")
+	return err
+}
+
+func (m *manifest) close() error {
+	return m.f.Close()
+}
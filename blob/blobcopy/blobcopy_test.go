@@ -0,0 +1,296 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blobcopy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cloud/blob"
+	"github.com/google/go-cloud/blob/driver"
+	"github.com/google/go-cloud/blob/fileblob"
+	"github.com/google/go-cmp/cmp"
+)
+
+func newBucket(t *testing.T, dir string) *blob.Bucket {
+	b, err := fileblob.OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestCopy(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "blobcopy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, d := range []string{"src", "dst"} {
+		if err := os.Mkdir(filepath.Join(dir, d), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src, dst := newBucket(t, filepath.Join(dir, "src")), newBucket(t, filepath.Join(dir, "dst"))
+
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("in/%02d", i)
+		err := src.WriteAll(ctx, key, []byte(key), &blob.WriterOptions{
+			ContentType: "text/x-test",
+			Metadata:    map[string]string{"n": fmt.Sprint(i)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := src.WriteAll(ctx, "other", []byte("other"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A manifest left by an earlier copy, ending with a line cut short.
+	manifest := filepath.Join(dir, "manifest")
+	if err := ioutil.WriteFile(manifest, []byte("\"in/00\"\n\"in/01\"\n\"in/0"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	opts := &Options{
+		SrcPrefix:   "in/",
+		DstPrefix:   "out/",
+		Concurrency: 3,
+		Manifest:    manifest,
+		Verify:      VerifyMD5,
+	}
+	res, err := Copy(ctx, dst, src, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Result{Copied: 18, Skipped: 2, Bytes: 18 * 5}); *res != want {
+		t.Errorf("got %+v want %+v", *res, want)
+	}
+	for i := 2; i < 20; i++ {
+		key := fmt.Sprintf("out/%02d", i)
+		got, err := dst.ReadAll(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("in/%02d", i); string(got) != want {
+			t.Errorf("%s: got %q want %q", key, got, want)
+		}
+		attrs, err := dst.Attributes(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.ContentType != "text/x-test" {
+			t.Errorf("%s: got ContentType %q want %q", key, attrs.ContentType, "text/x-test")
+		}
+		if diff := cmp.Diff(attrs.Metadata, map[string]string{"n": fmt.Sprint(i)}); diff != "" {
+			t.Errorf("%s: Metadata: %s", key, diff)
+		}
+	}
+	for _, key := range []string{"out/00", "out/01", "other", "out/other"} {
+		if _, err := dst.Attributes(ctx, key); !blob.IsNotExist(err) {
+			t.Errorf("%s: got %v, want IsNotExist", key, err)
+		}
+	}
+
+	// Resuming with the same manifest copies nothing.
+	res, err = Copy(ctx, dst, src, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Result{Skipped: 20}); *res != want {
+		t.Errorf("resumed: got %+v want %+v", *res, want)
+	}
+}
+
+func TestCopyError(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "blobcopy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "bucket"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	b := newBucket(t, filepath.Join(dir, "bucket"))
+	if err := b.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	manifest := filepath.Join(dir, "manifest")
+	// The object "key" is a file, so it can't be a directory holding the
+	// copy "key/key".
+	res, err := Copy(ctx, b, b, &Options{DstPrefix: "key/", Manifest: manifest})
+	if err == nil {
+		t.Fatal("got nil error, want error")
+	}
+	if res.Copied != 0 {
+		t.Errorf("got %d copied, want 0", res.Copied)
+	}
+	data, err := ioutil.ReadFile(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 0 {
+		t.Errorf("got manifest %q after a failed copy, want empty", data)
+	}
+}
+
+var errBrokenRead = errors.New("read failed")
+
+// brokenBucket is a driver.Bucket holding a single object, content, under
+// key. Reads of the object fail after half of it has been read.
+type brokenBucket struct {
+	driver.Bucket
+	key     string
+	content []byte
+}
+
+func (b *brokenBucket) IsNotExist(err error) bool             { return false }
+func (b *brokenBucket) IsNotImplemented(err error) bool       { return false }
+func (b *brokenBucket) IsRetryable(err error) bool            { return false }
+func (b *brokenBucket) ErrorAs(err error, i interface{}) bool { return false }
+
+func (b *brokenBucket) Attributes(ctx context.Context, key string) (driver.Attributes, error) {
+	return driver.Attributes{ContentType: "application/octet-stream", Size: int64(len(b.content))}, nil
+}
+
+func (b *brokenBucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	return &driver.ListPage{Objects: []*driver.ListObject{{Key: b.key, Size: int64(len(b.content))}}}, nil
+}
+
+func (b *brokenBucket) NewRangeReader(ctx context.Context, key string, offset, length int64) (driver.Reader, error) {
+	half := bytes.NewReader(b.content[:len(b.content)/2])
+	return &brokenReader{Reader: half, size: int64(len(b.content))}, nil
+}
+
+// brokenReader returns errBrokenRead instead of io.EOF.
+type brokenReader struct {
+	io.Reader
+	size int64
+}
+
+func (r *brokenReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		err = errBrokenRead
+	}
+	return n, err
+}
+
+func (r *brokenReader) Close() error { return nil }
+func (r *brokenReader) Attributes() driver.ReaderAttributes {
+	return driver.ReaderAttributes{ContentType: "application/octet-stream", Size: r.size}
+}
+func (r *brokenReader) As(i interface{}) bool { return false }
+
+// classBucket is a driver.Bucket holding objects with storage classes.
+type classBucket struct {
+	driver.Bucket
+	mu      sync.Mutex
+	classes map[string]driver.StorageClass
+}
+
+func (b *classBucket) IsNotExist(err error) bool             { return false }
+func (b *classBucket) IsNotImplemented(err error) bool       { return false }
+func (b *classBucket) IsRetryable(err error) bool            { return false }
+func (b *classBucket) ErrorAs(err error, i interface{}) bool { return false }
+
+func (b *classBucket) Attributes(ctx context.Context, key string) (driver.Attributes, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return driver.Attributes{ContentType: "text/plain", StorageClass: b.classes[key]}, nil
+}
+
+func (b *classBucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var page driver.ListPage
+	for key := range b.classes {
+		page.Objects = append(page.Objects, &driver.ListObject{Key: key})
+	}
+	return &page, nil
+}
+
+func (b *classBucket) NewRangeReader(ctx context.Context, key string, offset, length int64) (driver.Reader, error) {
+	return &emptyReader{}, nil
+}
+
+type emptyReader struct{}
+
+func (r *emptyReader) Read(p []byte) (int, error)          { return 0, io.EOF }
+func (r *emptyReader) Close() error                        { return nil }
+func (r *emptyReader) Attributes() driver.ReaderAttributes { return driver.ReaderAttributes{} }
+func (r *emptyReader) As(i interface{}) bool               { return false }
+
+func (b *classBucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.classes[key] = opts.StorageClass
+	return &nopWriter{}, nil
+}
+
+type nopWriter struct{}
+
+func (w *nopWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w *nopWriter) Close() error                { return nil }
+
+func TestCopyStorageClass(t *testing.T) {
+	ctx := context.Background()
+	src := blob.NewBucket(&classBucket{classes: map[string]driver.StorageClass{
+		"archived": driver.StorageClassArchive,
+		"standard": driver.StorageClassStandard,
+		"unknown":  driver.StorageClassDefault,
+	}})
+	for _, copyClass := range []bool{false, true} {
+		dst := &classBucket{classes: map[string]driver.StorageClass{}}
+		if _, err := Copy(ctx, blob.NewBucket(dst), src, &Options{CopyStorageClass: copyClass}); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]driver.StorageClass{"archived": "", "standard": "", "unknown": ""}
+		if copyClass {
+			want = map[string]driver.StorageClass{"archived": "archive", "standard": "standard", "unknown": ""}
+		}
+		if diff := cmp.Diff(dst.classes, want); diff != "" {
+			t.Errorf("CopyStorageClass %v: got storage classes diff %s", copyClass, diff)
+		}
+	}
+}
+
+func TestCopyReadError(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "blobcopy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := blob.NewBucket(&brokenBucket{key: "key", content: bytes.Repeat([]byte("x"), 10000)})
+	dst := newBucket(t, dir)
+	if _, err := Copy(ctx, dst, src, nil); err == nil {
+		t.Fatal("got nil error, want error")
+	}
+	// The partial copy must not be committed, or a later run could skip it.
+	if _, err := dst.Attributes(ctx, "key"); !blob.IsNotExist(err) {
+		t.Errorf("got %v for the failed copy, want IsNotExist", err)
+	}
+}
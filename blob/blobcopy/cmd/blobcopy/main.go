@@ -0,0 +1,100 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command blobcopy copies objects from one bucket to another, which may
+// belong to different providers. Buckets are given as blob.Open URLs:
+//   blobcopy -prefix=logs/ -manifest=logs.manifest -verify=md5 s3://old-bucket gs://new-bucket
+//
+// If the copy fails or is interrupted, running the same command again with
+// the same -manifest copies only the objects that weren't copied.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/google/go-cloud/blob"
+	"github.com/google/go-cloud/blob/blobcopy"
+
+	// Register the providers for blob.Open.
+	_ "github.com/google/go-cloud/blob/azureblob"
+	_ "github.com/google/go-cloud/blob/fileblob"
+	_ "github.com/google/go-cloud/blob/gcsblob"
+	_ "github.com/google/go-cloud/blob/httpblob"
+	_ "github.com/google/go-cloud/blob/s3blob"
+	_ "github.com/google/go-cloud/blob/sftpblob"
+)
+
+func main() {
+	prefix := flag.String("prefix", "", "Copy only objects whose keys start with this prefix")
+	dstPrefix := flag.String("dst_prefix", "", "Replace -prefix with this in the copied keys (default: the value of -prefix)")
+	concurrency := flag.Int("concurrency", blobcopy.DefaultConcurrency, "Number of objects to copy at once")
+	manifest := flag.String("manifest", "", "File recording the copied keys, for resuming a copy")
+	verify := flag.String("verify", "none", "How to check copied objects: none, size or md5")
+	copyStorageClass := flag.Bool("copy_storage_class", false, "Give copies the storage class of their source objects, instead of the destination bucket's default")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: blobcopy [flags] SRC_URL DST_URL\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	dstPrefixSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "dst_prefix" {
+			dstPrefixSet = true
+		}
+	})
+	if !dstPrefixSet {
+		*dstPrefix = *prefix
+	}
+	var v blobcopy.Verify
+	switch *verify {
+	case "none":
+		v = blobcopy.VerifyNone
+	case "size":
+		v = blobcopy.VerifySize
+	case "md5":
+		v = blobcopy.VerifyMD5
+	default:
+		log.Fatalf("Unknown -verify %q; want none, size or md5", *verify)
+	}
+
+	ctx := context.Background()
+	src, err := blob.Open(ctx, flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to open source bucket: %v", err)
+	}
+	dst, err := blob.Open(ctx, flag.Arg(1))
+	if err != nil {
+		log.Fatalf("Failed to open destination bucket: %v", err)
+	}
+	res, err := blobcopy.Copy(ctx, dst, src, &blobcopy.Options{
+		SrcPrefix:        *prefix,
+		DstPrefix:        *dstPrefix,
+		Concurrency:      *concurrency,
+		Manifest:         *manifest,
+		Verify:           v,
+		CopyStorageClass: *copyStorageClass,
+	})
+	log.Printf("Copied %d objects (%d bytes), skipped %d", res.Copied, res.Bytes, res.Skipped)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
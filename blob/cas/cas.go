@@ -0,0 +1,208 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cas provides content-addressed storage on top of a blob.Bucket.
+//
+// Content is stored under a key derived from its SHA-256 digest,
+// "sha256/<lowercase hex digest>", so storing the same content twice stores
+// it once. Concurrent writers of the same content are safe: writes use
+// blob.WriterOptions.IfNotExist when the provider supports it, and otherwise
+// at worst replace an object with identical content.
+//
+// Example:
+//   s := cas.NewStore(bucket, nil)
+//   d, err := s.Put(ctx, r)
+//   ...
+//   r, err := s.Get(ctx, d)
+package cas
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/google/go-cloud/blob"
+)
+
+// Digest is the SHA-256 digest of some content.
+type Digest [sha256.Size]byte
+
+// String returns d as lowercase hex.
+func (d Digest) String() string {
+	return hex.EncodeToString(d[:])
+}
+
+// ParseDigest parses a digest in the format returned by Digest.String.
+func ParseDigest(s string) (Digest, error) {
+	var d Digest
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return d, fmt.Errorf("cas: invalid digest %q: %v", s, err)
+	}
+	if len(b) != len(d) {
+		return d, fmt.Errorf("cas: invalid digest %q: got %d bytes, want %d", s, len(b), len(d))
+	}
+	copy(d[:], b)
+	return d, nil
+}
+
+// ErrDigestMismatch is returned when reading content whose digest doesn't
+// match the one it was stored under.
+var ErrDigestMismatch = errors.New("cas: content does not match digest")
+
+// Options sets options for a Store.
+type Options struct {
+	// Prefix is prepended to the keys of all objects in the Store, for
+	// sharing a bucket with other data. For example, with Prefix "cas/" the
+	// key of an object is "cas/sha256/<digest>".
+	Prefix string
+}
+
+// Store stores content in a bucket, keyed by its digest.
+type Store struct {
+	b      *blob.Bucket
+	prefix string
+}
+
+// NewStore returns a Store that keeps content in b.
+func NewStore(b *blob.Bucket, opts *Options) *Store {
+	if opts == nil {
+		opts = &Options{}
+	}
+	return &Store{b: b, prefix: opts.Prefix}
+}
+
+// Key returns the key of the object that holds the content with digest d.
+func (s *Store) Key(d Digest) string {
+	return s.prefix + "sha256/" + d.String()
+}
+
+// Has returns true if the store holds content with digest d.
+func (s *Store) Has(ctx context.Context, d Digest) (bool, error) {
+	_, err := s.b.Attributes(ctx, s.Key(d))
+	if blob.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Put stores the content read from r until EOF, and returns its digest.
+// If the store already holds the content, it isn't written again.
+//
+// The digest has to be known before the content is written, so Put first
+// copies the content to a temporary file.
+func (s *Store) Put(ctx context.Context, r io.Reader) (Digest, error) {
+	var d Digest
+	f, err := ioutil.TempFile("", "cas")
+	if err != nil {
+		return d, err
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	sha, md := sha256.New(), md5.New()
+	if _, err := io.Copy(io.MultiWriter(f, sha, md), r); err != nil {
+		return d, err
+	}
+	copy(d[:], sha.Sum(nil))
+	if ok, err := s.Has(ctx, d); err != nil || ok {
+		return d, err
+	}
+
+	err = s.write(ctx, d, f, md.Sum(nil), true)
+	if blob.IsNotImplemented(err) {
+		// The content is the same whoever writes it, so an unconditional
+		// write is safe, just wasteful.
+		err = s.write(ctx, d, f, md.Sum(nil), false)
+	}
+	if err != nil {
+		// A conditional write fails if another writer stored the same
+		// content first.
+		if ok, herr := s.Has(ctx, d); herr == nil && ok {
+			return d, nil
+		}
+		return d, err
+	}
+	return d, nil
+}
+
+// write copies the content in f to the object for d.
+func (s *Store) write(ctx context.Context, d Digest, f *os.File, md5sum []byte, ifNotExist bool) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	w, err := s.b.NewWriter(ctx, s.Key(d), &blob.WriterOptions{
+		ContentType: "application/octet-stream",
+		ContentMD5:  md5sum,
+		IfNotExist:  ifNotExist,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Get returns a reader for the content with digest d. Reads verify the
+// content: when all of it has been read, Read returns ErrDigestMismatch
+// instead of io.EOF if the content doesn't match d.
+//
+// If the store doesn't hold the content, Get returns an error for which
+// blob.IsNotExist returns true.
+func (s *Store) Get(ctx context.Context, d Digest) (io.ReadCloser, error) {
+	r, err := s.b.NewReader(ctx, s.Key(d))
+	if err != nil {
+		return nil, err
+	}
+	return &verifyingReader{r: r, h: sha256.New(), want: d}, nil
+}
+
+// verifyingReader hashes the content read from r, and checks the digest at
+// EOF.
+type verifyingReader struct {
+	r    *blob.Reader
+	h    hash.Hash
+	want Digest
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.h.Write(p[:n])
+	if err == io.EOF {
+		var got Digest
+		copy(got[:], v.h.Sum(nil))
+		if got != v.want {
+			return n, ErrDigestMismatch
+		}
+	}
+	return n, err
+}
+
+func (v *verifyingReader) Close() error {
+	return v.r.Close()
+}
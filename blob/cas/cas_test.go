@@ -0,0 +1,152 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cas
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cloud/blob"
+	"github.com/google/go-cloud/blob/fileblob"
+)
+
+func newStore(t *testing.T) (*blob.Bucket, *Store, func()) {
+	dir, err := ioutil.TempDir("", "cas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := fileblob.OpenBucket(dir, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return b, NewStore(b, &Options{Prefix: "cas/"}), func() { os.RemoveAll(dir) }
+}
+
+func TestPutGet(t *testing.T) {
+	ctx := context.Background()
+	b, s, done := newStore(t)
+	defer done()
+
+	content := []byte("hello world")
+	want := Digest(sha256.Sum256(content))
+	if ok, err := s.Has(ctx, want); err != nil || ok {
+		t.Fatalf("got Has %v, %v before Put, want false, nil", ok, err)
+	}
+	if _, err := s.Get(ctx, want); !blob.IsNotExist(err) {
+		t.Errorf("got Get error %v before Put, want IsNotExist", err)
+	}
+	// Putting the same content twice stores it once.
+	for i := 0; i < 2; i++ {
+		d, err := s.Put(ctx, bytes.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d != want {
+			t.Errorf("got digest %v want %v", d, want)
+		}
+	}
+	if ok, err := s.Has(ctx, want); err != nil || !ok {
+		t.Errorf("got Has %v, %v after Put, want true, nil", ok, err)
+	}
+	got, err := b.ReadAll(ctx, "cas/sha256/"+want.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("got stored content %q want %q", got, content)
+	}
+
+	r, err := s.Get(ctx, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err = ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("got %q want %q", got, content)
+	}
+}
+
+func TestConcurrentPut(t *testing.T) {
+	ctx := context.Background()
+	_, s, done := newStore(t)
+	defer done()
+
+	content := strings.Repeat("concurrent", 1000)
+	want := Digest(sha256.Sum256([]byte(content)))
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d, err := s.Put(ctx, strings.NewReader(content))
+			if err != nil {
+				t.Error(err)
+			} else if d != want {
+				t.Errorf("got digest %v want %v", d, want)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestGetVerifies(t *testing.T) {
+	ctx := context.Background()
+	b, s, done := newStore(t)
+	defer done()
+
+	d, err := s.Put(ctx, strings.NewReader("original"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteAll(ctx, s.Key(d), []byte("corrupted"), nil); err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.Get(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := io.Copy(ioutil.Discard, r); err != ErrDigestMismatch {
+		t.Errorf("got error %v reading corrupted content, want ErrDigestMismatch", err)
+	}
+}
+
+func TestParseDigest(t *testing.T) {
+	want := Digest(sha256.Sum256([]byte("x")))
+	got, err := ParseDigest(want.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	for _, s := range []string{"", "xyz", "abcd", want.String() + "00"} {
+		if _, err := ParseDigest(s); err == nil {
+			t.Errorf("ParseDigest(%q): got nil error, want error", s)
+		}
+	}
+}
@@ -51,6 +51,16 @@ type WriterOptions struct {
 	// Metadata holds key/value strings to be associated with the blob.
 	// Keys are guaranteed to be non-empty and lowercased.
 	Metadata map[string]string
+	// IfNotExist requires that no object with the key exist. If one does,
+	// the write must fail, leaving the existing object unchanged; the error
+	// may be returned from NewTypedWriter, Write or Close.
+	// If not supported, NewTypedWriter must return an error for which
+	// IsNotImplemented returns true.
+	IfNotExist bool
+	// StorageClass is the storage class to write the object with. Providers
+	// should map it to their closest class, and ignore it if they don't
+	// have storage classes. StorageClassDefault means the bucket's default.
+	StorageClass StorageClass
 	// BeforeWrite is a callback that must be called exactly once before
 	// any data is written, unless NewTypedWriter returns an error, in
 	// which case it should not be called.
@@ -84,6 +94,14 @@ type Attributes struct {
 	ModTime time.Time
 	// Size is the size of the object in bytes.
 	Size int64
+	// ETag is an opaque string that changes whenever the object is
+	// written, or empty if the provider doesn't have one.
+	ETag string
+	// StorageClass is the storage class of the object, or
+	// StorageClassDefault if the provider doesn't have storage classes or
+	// the object's class doesn't correspond to one of the StorageClass
+	// values.
+	StorageClass StorageClass
 	// AsFunc allows providers to expose provider-specific types;
 	// see Bucket.As for more details.
 	// If not set, no provider-specific types are supported.
@@ -163,7 +181,7 @@ type ListPage struct {
 type Bucket interface {
 	// IsNotExist should return true if err, an error returned from one
 	// of the other methods in this interface, represents a "key does not exist"
-	// error.
+	// or "bucket does not exist" error.
 	IsNotExist(err error) bool
 
 	// IsNotImplemented should return true if err, an error returned from one
@@ -171,6 +189,11 @@ type Bucket interface {
 	// implemented for this provider.
 	IsNotImplemented(err error) bool
 
+	// IsRetryable should return true if err, an error returned from one of
+	// the other methods in this interface, is transient, so that retrying
+	// the operation may succeed; for example, an HTTP 503 response.
+	IsRetryable(err error) bool
+
 	// As allows providers to expose provider-specific types.
 	//
 	// i will be a pointer to the type the user wants filled in.
@@ -241,11 +264,92 @@ type Bucket interface {
 	// true.
 	Delete(ctx context.Context, key string) error
 
+	// Compose creates or replaces the object dst with the concatenation of
+	// the objects srcs, in order, without transferring their contents through
+	// the caller. srcs is guaranteed to be non-empty, and may include dst.
+	// opts is guaranteed to be non-nil, with a non-empty ContentType.
+	// If the sources don't exist, Compose must return an error for which
+	// IsNotExist returns true.
+	// If not supported, or not supported for these particular sources,
+	// return an error for which IsNotImplemented returns true; the concrete
+	// type will then compose the object by streaming the sources instead.
+	Compose(ctx context.Context, dst string, srcs []string, opts *ComposeOptions) error
+
 	// SignedURL returns a URL that can be used to GET the blob for the duration
 	// specified in opts.Expiry. opts is guaranteed to be non-nil.
 	// If not supported, return an error for which IsNotImplemented returns
 	// true.
 	SignedURL(ctx context.Context, key string, opts *SignedURLOptions) (string, error)
+
+	// LifecycleRules returns the lifecycle rules that are configured for the
+	// bucket. Rules that can't be represented as a LifecycleRule should be
+	// omitted.
+	// If not supported, return an error for which IsNotImplemented returns
+	// true.
+	LifecycleRules(ctx context.Context) ([]*LifecycleRule, error)
+
+	// SetLifecycleRules replaces all of the lifecycle rules configured for the
+	// bucket with rules, which may be empty. Each rule is guaranteed to have
+	// DeleteAfterDays > 0.
+	// If not supported, return an error for which IsNotImplemented returns
+	// true.
+	SetLifecycleRules(ctx context.Context, rules []*LifecycleRule) error
+
+	// CreateBucket creates the bucket. If the bucket already exists,
+	// CreateBucket must return an error. opts is guaranteed to be non-nil.
+	// If not supported, return an error for which IsNotImplemented returns
+	// true.
+	CreateBucket(ctx context.Context, opts *CreateBucketOptions) error
+
+	// DeleteBucket deletes the bucket. It must return an error if the bucket
+	// contains any objects. If the bucket does not exist, DeleteBucket must
+	// return an error for which IsNotExist returns true.
+	// If not supported, return an error for which IsNotImplemented returns
+	// true.
+	DeleteBucket(ctx context.Context) error
+
+	// BucketExists returns true if the bucket exists.
+	// If not supported, return an error for which IsNotImplemented returns
+	// true.
+	BucketExists(ctx context.Context) (bool, error)
+}
+
+// StorageClass is a portable storage tier, trading the cost of storing
+// objects against the cost and latency of reading them.
+type StorageClass string
+
+const (
+	// StorageClassDefault means no particular class.
+	StorageClassDefault StorageClass = ""
+	// StorageClassStandard is for frequently read objects.
+	StorageClassStandard StorageClass = "standard"
+	// StorageClassInfrequent is for objects read less than about once a
+	// month.
+	StorageClassInfrequent StorageClass = "infrequent"
+	// StorageClassArchive is for objects that are rarely read, such as
+	// backups.
+	StorageClassArchive StorageClass = "archive"
+)
+
+// CreateBucketOptions controls the bucket created by CreateBucket.
+type CreateBucketOptions struct {
+	// Location is a provider-specific region or location for the bucket.
+	// An empty Location means the provider's default.
+	Location string
+	// StorageClass is the default storage class for objects in the bucket.
+	// Providers should map it to their closest class, and ignore it if they
+	// don't have per-bucket classes. StorageClassDefault means the
+	// provider's default.
+	StorageClass StorageClass
+}
+
+// ComposeOptions controls the object created by Compose.
+type ComposeOptions struct {
+	// ContentType is the MIME type of the composed object. It is never empty.
+	ContentType string
+	// Metadata holds key/value strings to be associated with the composed
+	// object. Keys are guaranteed to be non-empty and lowercased.
+	Metadata map[string]string
 }
 
 // SignedURLOptions sets options for SignedURL.
@@ -253,3 +357,17 @@ type SignedURLOptions struct {
 	// Expiry sets how long the returned URL is valid for. It is guaranteed to be > 0.
 	Expiry time.Duration
 }
+
+// LifecycleRule describes an action that the provider takes automatically on
+// objects in the bucket.
+type LifecycleRule struct {
+	// Prefix restricts the rule to objects whose keys start with Prefix.
+	// An empty Prefix applies the rule to all objects.
+	// Providers that can't match prefixes should return an error for which
+	// IsNotImplemented returns true from SetLifecycleRules if any rule has a
+	// non-empty Prefix.
+	Prefix string
+	// DeleteAfterDays is the number of days after an object is written that it
+	// is deleted.
+	DeleteAfterDays int
+}
@@ -192,6 +192,66 @@ func RunConformanceTests(t *testing.T, newHarness HarnessMaker, asTests []AsTest
 	})
 }
 
+// RunLifecycleTests runs tests of LifecycleRules and SetLifecycleRules.
+// They replace the bucket's lifecycle configuration, so they are separate from
+// RunConformanceTests and are usually run against a fake of the provider.
+// Providers that don't support lifecycle rules skip the tests, and providers
+// that can't match key prefixes may return an error for which
+// blob.IsNotImplemented is true for rules with a Prefix.
+func RunLifecycleTests(t *testing.T, newHarness HarnessMaker) {
+	ctx := context.Background()
+	h, err := newHarness(ctx, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	drv, err := h.MakeDriver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.NewBucket(drv)
+
+	if _, err := b.LifecycleRules(ctx); blob.IsNotImplemented(err) {
+		t.Skip("lifecycle rules are not supported")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	checkRules := func(want []*blob.LifecycleRule) {
+		t.Helper()
+		got, err := b.LifecycleRules(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) == 0 && len(want) == 0 {
+			return
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("got rules %v, want %v, diff %s", got, want, diff)
+		}
+	}
+
+	want := []*blob.LifecycleRule{{DeleteAfterDays: 30}}
+	if err := b.SetLifecycleRules(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+	checkRules(want)
+
+	prefixed := []*blob.LifecycleRule{{DeleteAfterDays: 30}, {Prefix: "tmp/", DeleteAfterDays: 1}}
+	if err := b.SetLifecycleRules(ctx, prefixed); blob.IsNotImplemented(err) {
+		// The provider can't match prefixes; the rules must be left as they were.
+		checkRules(want)
+	} else if err != nil {
+		t.Fatal(err)
+	} else {
+		checkRules(prefixed)
+	}
+
+	if err := b.SetLifecycleRules(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkRules(nil)
+}
+
 // testList tests the functionality of List.
 func testList(t *testing.T, newHarness HarnessMaker) {
 	const keyPrefix = "blob-for-list"
@@ -17,7 +17,10 @@ package fileblob
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+
+	"github.com/google/go-cloud/blob"
 )
 
 const attrsExt = ".attrs"
@@ -39,6 +42,11 @@ func setAttrs(path string, xa xattrs) error {
 	if err != nil {
 		return err
 	}
+	return writeAttrs(f, xa)
+}
+
+// writeAttrs encodes xa into f, and closes f.
+func writeAttrs(f *os.File, xa xattrs) error {
 	if err := json.NewEncoder(f).Encode(xa); err != nil {
 		f.Close()
 		return err
@@ -48,15 +56,17 @@ func setAttrs(path string, xa xattrs) error {
 
 // getAttrs looks at the "path.attrs" file to retrieve the attributes and
 // decodes them into a xattrs struct. It doesn't return error when there is no
-// such .attrs file.
+// such .attrs file; the content type is then detected from the file.
 func getAttrs(path string) (xattrs, error) {
 	f, err := os.Open(path + attrsExt)
 	if err != nil {
 		if os.IsNotExist(err) {
 			// Handle gracefully for non-existent .attr files.
-			return xattrs{
-				ContentType: "application/octet-stream",
-			}, nil
+			ct, err := detectContentType(path)
+			if err != nil {
+				return xattrs{}, err
+			}
+			return xattrs{ContentType: ct}, nil
 		}
 		return xattrs{}, err
 	}
@@ -67,3 +77,22 @@ func getAttrs(path string) (xattrs, error) {
 	}
 	return *xa, f.Close()
 }
+
+// detectContentType returns the MIME type of the file at path, which has no
+// .attrs file, using blob.DetectContentType.
+func detectContentType(path string) (string, error) {
+	if ct := blob.TypeByExtension(path); ct != "" {
+		return ct, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return blob.DetectContentType(path, buf[:n]), nil
+}
@@ -34,10 +34,21 @@
 // For blob.Open URLs, fileblob registers for the "file" scheme.
 // The URL's Path is used as the root directory; the URL's Host is ignored.
 // If os.PathSeparator != "/", any leading "/" from the Path is dropped.
-// No query options are supported. Examples:
+// The following query options are supported:
+// - allow_missing_dir: A boolean; sets Options.AllowMissingDir.
+// Examples:
 // -- file:///a/directory passes "/a/directory" to OpenBucket.
 // -- file://localhost/a/directory also passes "/a/directory".
 // -- file:///c:/foo/bar passes "c:/foo/bar".
+// -- file:///tmp/new?allow_missing_dir=true opens a bucket for a directory
+//    that may not exist yet.
+//
+// blob.Bucket.CreateBucket and DeleteBucket create and remove the directory.
+// Location and StorageClass hints are ignored. WriterOptions.StorageClass is
+// also ignored, and Attributes.StorageClass is always empty.
+//
+// Lifecycle rules set via blob.Bucket.SetLifecycleRules are stored alongside
+// the blobs, but are only enforced when Sweep is called.
 //
 // fileblob does not support any types for As.
 package fileblob
@@ -55,10 +66,12 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/google/go-cloud/blob"
 	"github.com/google/go-cloud/blob/driver"
+	"github.com/google/go-cloud/internal/escape"
 )
 
 const defaultPageSize = 1000
@@ -69,23 +82,38 @@ func init() {
 		if os.PathSeparator != '/' && strings.HasPrefix(path, "/") {
 			path = path[1:]
 		}
-		return openBucket(path, nil)
+		opts := &Options{}
+		if v := u.Query().Get("allow_missing_dir"); v != "" {
+			allow, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q for query parameter allow_missing_dir: %v", v, err)
+			}
+			opts.AllowMissingDir = allow
+		}
+		return openBucket(path, opts)
 	})
 }
 
 // Options sets options for constructing a *blob.Bucket backed by fileblob.
-type Options struct{}
+type Options struct {
+	// AllowMissingDir allows opening a bucket for a directory that doesn't
+	// exist yet, so that it can be created with blob.Bucket.CreateBucket.
+	AllowMissingDir bool
+}
 
 type bucket struct {
 	dir string
 }
 
 // openBucket creates a driver.Bucket that reads and writes to dir.
-// dir must exist.
-func openBucket(dir string, _ *Options) (driver.Bucket, error) {
+// dir must exist, unless opts.AllowMissingDir is set.
+func openBucket(dir string, opts *Options) (driver.Bucket, error) {
 	dir = filepath.Clean(dir)
 	info, err := os.Stat(dir)
 	if err != nil {
+		if os.IsNotExist(err) && opts != nil && opts.AllowMissingDir {
+			return &bucket{dir}, nil
+		}
 		return nil, err
 	}
 	if !info.IsDir() {
@@ -95,7 +123,7 @@ func openBucket(dir string, _ *Options) (driver.Bucket, error) {
 }
 
 // OpenBucket creates a *blob.Bucket that reads and writes to dir.
-// dir must exist.
+// dir must exist, unless opts.AllowMissingDir is set.
 func OpenBucket(dir string, opts *Options) (*blob.Bucket, error) {
 	drv, err := openBucket(dir, opts)
 	if err != nil {
@@ -104,142 +132,6 @@ func OpenBucket(dir string, opts *Options) (*blob.Bucket, error) {
 	return blob.NewBucket(drv), nil
 }
 
-// shouldEscape returns true if c should be escaped.
-func shouldEscape(c byte) bool {
-	switch {
-	case 'A' <= c && c <= 'Z':
-		return false
-	case 'a' <= c && c <= 'z':
-		return false
-	case '0' <= c && c <= '9':
-		return false
-	case c == ' ' || c == '-' || c == '_' || c == '.':
-		return false
-	case c == '/':
-		return false
-	}
-	return true
-}
-
-// escape returns s escaped per the rules described in the package docstring.
-// The code is modified from https://golang.org/src/net/url/url.go.
-func escape(s string) string {
-	hexCount := 0
-	replaceSlash := false
-	for i := 0; i < len(s); i++ {
-		c := s[i]
-		if shouldEscape(c) {
-			hexCount++
-		} else if c == '/' && os.PathSeparator != '/' {
-			replaceSlash = true
-		}
-	}
-	if hexCount == 0 && !replaceSlash {
-		return s
-	}
-	t := make([]byte, len(s)+2*hexCount)
-	j := 0
-	for i := 0; i < len(s); i++ {
-		switch c := s[i]; {
-		case c == '/':
-			t[j] = os.PathSeparator
-			j++
-		case shouldEscape(c):
-			t[j] = '%'
-			t[j+1] = "0123456789ABCDEF"[c>>4]
-			t[j+2] = "0123456789ABCDEF"[c&15]
-			j += 3
-		default:
-			t[j] = s[i]
-			j++
-		}
-	}
-	return string(t)
-}
-
-// ishex returns true if c is a valid part of a hexadecimal number.
-func ishex(c byte) bool {
-	switch {
-	case '0' <= c && c <= '9':
-		return true
-	case 'a' <= c && c <= 'f':
-		return true
-	case 'A' <= c && c <= 'F':
-		return true
-	}
-	return false
-}
-
-// unhex returns the hexadecimal value of the hexadecimal character c.
-// For example, unhex('A') returns 10.
-func unhex(c byte) byte {
-	switch {
-	case '0' <= c && c <= '9':
-		return c - '0'
-	case 'a' <= c && c <= 'f':
-		return c - 'a' + 10
-	case 'A' <= c && c <= 'F':
-		return c - 'A' + 10
-	}
-	return 0
-}
-
-// unescape unescapes s per the rules described in the package docstring.
-// It returns an error if s has invalid escape sequences, or if
-// escape(unescape(s)) != s.
-// The code is modified from https://golang.org/src/net/url/url.go.
-func unescape(s string) (string, error) {
-	// Count %, check that they're well-formed.
-	n := 0
-	replacePathSeparator := false
-	for i := 0; i < len(s); {
-		switch s[i] {
-		case '%':
-			n++
-			if i+2 >= len(s) || !ishex(s[i+1]) || !ishex(s[i+2]) {
-				bad := s[i:]
-				if len(bad) > 3 {
-					bad = bad[:3]
-				}
-				return "", fmt.Errorf("couldn't unescape %q near %q", s, bad)
-			}
-			i += 3
-		case os.PathSeparator:
-			replacePathSeparator = os.PathSeparator != '/'
-			i++
-		default:
-			i++
-		}
-	}
-	unescaped := s
-	if n > 0 || replacePathSeparator {
-		t := make([]byte, len(s)-2*n)
-		j := 0
-		for i := 0; i < len(s); {
-			switch s[i] {
-			case '%':
-				t[j] = unhex(s[i+1])<<4 | unhex(s[i+2])
-				j++
-				i += 3
-			case os.PathSeparator:
-				t[j] = '/'
-				j++
-				i++
-			default:
-				t[j] = s[i]
-				j++
-				i++
-			}
-		}
-		unescaped = string(t)
-	}
-	escaped := escape(unescaped)
-	if escaped != s {
-		return "", fmt.Errorf("%q unescaped to %q but escaped back to %q instead of itself", s, unescaped, escaped)
-	}
-	return unescaped, nil
-}
-
 // IsNotExist implements driver.IsNotExist.
 func (b *bucket) IsNotExist(err error) bool {
 	return os.IsNotExist(err)
@@ -252,9 +144,14 @@ func (b *bucket) IsNotImplemented(err error) bool {
 	return err == errNotImplemented
 }
 
+// IsRetryable implements driver.IsRetryable.
+func (b *bucket) IsRetryable(err error) bool {
+	return false
+}
+
 // forKey returns the full path, os.FileInfo, and attributes for key.
 func (b *bucket) forKey(key string) (string, os.FileInfo, *xattrs, error) {
-	relpath := escape(key)
+	relpath := escape.FileName(key, os.PathSeparator)
 	path := filepath.Join(b.dir, relpath)
 	if strings.HasSuffix(path, attrsExt) {
 		return "", nil, nil, errAttrsExt
@@ -304,7 +201,7 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 		// Strip the <b.dir> prefix from path; +1 is to include the separator.
 		path = path[len(b.dir)+1:]
 		// Unescape the path to get the key; if this fails, skip.
-		key, err := unescape(path)
+		key, err := escape.Key(path, os.PathSeparator)
 		if err != nil {
 			return nil
 		}
@@ -454,7 +351,7 @@ func (r reader) As(i interface{}) bool { return false }
 
 // NewTypedWriter implements driver.NewTypedWriter.
 func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
-	path := filepath.Join(b.dir, escape(key))
+	path := filepath.Join(b.dir, escape.FileName(key, os.PathSeparator))
 	if strings.HasSuffix(path, attrsExt) {
 		return nil, errAttrsExt
 	}
@@ -479,10 +376,11 @@ func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType str
 		Metadata:    metadata,
 	}
 	w := &writer{
-		ctx:   ctx,
-		f:     f,
-		path:  path,
-		attrs: attrs,
+		ctx:        ctx,
+		f:          f,
+		path:       path,
+		attrs:      attrs,
+		ifNotExist: opts.IfNotExist,
 	}
 	if len(opts.ContentMD5) > 0 {
 		w.contentMD5 = opts.ContentMD5
@@ -496,6 +394,7 @@ type writer struct {
 	f          *os.File
 	path       string
 	attrs      xattrs
+	ifNotExist bool
 	contentMD5 []byte
 	md5hash    hash.Hash
 }
@@ -536,6 +435,9 @@ func (w writer) Close() error {
 			)
 		}
 	}
+	if w.ifNotExist {
+		return w.link()
+	}
 	// Write the attributes file.
 	if err := setAttrs(w.path, w.attrs); err != nil {
 		return err
@@ -548,9 +450,38 @@ func (w writer) Close() error {
 	return nil
 }
 
+// link creates the object at w.path from the temp file, failing if it
+// already exists. Link fails if its destination exists, so only one writer
+// can create the object. The attributes file is linked first, so that the
+// object is never visible without its attributes.
+func (w writer) link() error {
+	// The attributes are written to a temp file next to the object, so that
+	// it can be linked into place. Its extension hides it from List.
+	af, err := ioutil.TempFile(filepath.Dir(w.path), "fileblob*"+attrsExt)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(af.Name())
+	}()
+	if err := writeAttrs(af, w.attrs); err != nil {
+		return err
+	}
+	if err := os.Link(af.Name(), w.path+attrsExt); err != nil {
+		return err
+	}
+	if err := os.Link(w.f.Name(), w.path); err != nil {
+		// The object exists without an attributes file; remove the one
+		// just linked.
+		_ = os.Remove(w.path + attrsExt)
+		return err
+	}
+	return nil
+}
+
 // Delete implements driver.Delete.
 func (b *bucket) Delete(ctx context.Context, key string) error {
-	path := filepath.Join(b.dir, escape(key))
+	path := filepath.Join(b.dir, escape.FileName(key, os.PathSeparator))
 	if strings.HasSuffix(path, attrsExt) {
 		return errAttrsExt
 	}
@@ -564,6 +495,46 @@ func (b *bucket) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
+// CreateBucket implements driver.CreateBucket.
+func (b *bucket) CreateBucket(ctx context.Context, opts *driver.CreateBucketOptions) error {
+	return os.Mkdir(b.dir, 0777)
+}
+
+// DeleteBucket implements driver.DeleteBucket.
+// The directory may only contain files that aren't blobs, such as attributes
+// and lifecycle files, which are removed along with it.
+func (b *bucket) DeleteBucket(ctx context.Context) error {
+	if _, err := os.Stat(b.dir); err != nil {
+		return err
+	}
+	page, err := b.ListPaged(ctx, &driver.ListOptions{PageSize: 1})
+	if err != nil {
+		return err
+	}
+	if len(page.Objects) > 0 {
+		return fmt.Errorf("bucket %s is not empty", b.dir)
+	}
+	return os.RemoveAll(b.dir)
+}
+
+// BucketExists implements driver.BucketExists.
+func (b *bucket) BucketExists(ctx context.Context) (bool, error) {
+	info, err := os.Stat(b.dir)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return info.IsDir(), nil
+}
+
+// Compose implements driver.Compose.
+func (b *bucket) Compose(ctx context.Context, dst string, srcs []string, opts *driver.ComposeOptions) error {
+	// There is no server; let the concrete type stream the sources.
+	return errNotImplemented
+}
+
 func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
 	// TODO(Issue #546): Implemented SignedURL for fileblob.
 	return "", errNotImplemented
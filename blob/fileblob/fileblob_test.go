@@ -16,15 +16,20 @@ package fileblob
 
 import (
 	"context"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/google/go-cloud/blob"
 	"github.com/google/go-cloud/blob/driver"
 	"github.com/google/go-cloud/blob/drivertest"
+	"github.com/google/go-cloud/internal/escape"
+	"github.com/google/go-cmp/cmp"
 )
 
 type harness struct {
@@ -59,6 +64,10 @@ func TestConformance(t *testing.T) {
 	drivertest.RunConformanceTests(t, newHarness, nil)
 }
 
+func TestLifecycleConformance(t *testing.T) {
+	drivertest.RunLifecycleTests(t, newHarness)
+}
+
 // File-specific unit tests.
 func TestNewBucket(t *testing.T) {
 	t.Run("BucketDirMissing", func(t *testing.T) {
@@ -85,74 +94,353 @@ func TestNewBucket(t *testing.T) {
 	})
 }
 
-func TestEscape(t *testing.T) {
-	for _, tc := range []struct {
-		key, want string
-	}{
-		{
-			key:  "abc09ABC -_.",
-			want: "abc09ABC -_.",
-		},
-		{
-			key:  "~!@#$%^&*()+`=[]{}\\|;:'\",<>,",
-			want: "%7E%21%40%23%24%25%5E%26%2A%28%29%2B%60%3D%5B%5D%7B%7D%5C%7C%3B%3A%27%22%2C%3C%3E%2C",
-		},
-		{
-			key:  "/",
-			want: string(os.PathSeparator),
-		},
-		{
-			key:  "☺☺",
-			want: "%E2%98%BA%E2%98%BA",
-		},
-	} {
-		got := escape(tc.key)
-		if got != tc.want {
-			t.Errorf("%s: got escaped %q want %q", tc.key, got, tc.want)
+func TestLifecycle(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "fileblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No rules to start with.
+	rules, err := b.LifecycleRules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 0 {
+		t.Errorf("got %v rules, want none", rules)
+	}
+	want := []*blob.LifecycleRule{{Prefix: "tmp/", DeleteAfterDays: 1}}
+	if err := b.SetLifecycleRules(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+	rules, err = b.LifecycleRules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(rules, want); diff != "" {
+		t.Errorf("got\n%v\nwant\n%v\ndiff\n%s", rules, want, diff)
+	}
+
+	// Write a few blobs, and make some of them old.
+	for _, key := range []string{"tmp/old", "tmp/new", "keep/old"} {
+		if err := b.WriteAll(ctx, key, []byte("hello"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	for _, key := range []string{"tmp/old", "keep/old"} {
+		if err := os.Chtimes(filepath.Join(dir, escape.FileName(key, os.PathSeparator)), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Sweep(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	for key, wantExist := range map[string]bool{"tmp/old": false, "tmp/new": true, "keep/old": true} {
+		_, err := b.Attributes(ctx, key)
+		if exist := err == nil; exist != wantExist {
+			t.Errorf("%s: got exists %v (err %v) want %v", key, exist, err, wantExist)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, escape.FileName("tmp/old", os.PathSeparator)+attrsExt)); !os.IsNotExist(err) {
+		t.Errorf("got err %v for attributes of deleted blob, want IsNotExist", err)
+	}
+
+	// The lifecycle file isn't visible as a blob.
+	iter := b.List(nil)
+	var keys []string
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
 		}
-		var err error
-		got, err = unescape(got)
 		if err != nil {
-			t.Error(err)
+			t.Fatal(err)
 		}
-		if got != tc.key {
-			t.Errorf("%s: got unescaped %q want %q", tc.key, got, tc.key)
+		keys = append(keys, obj.Key)
+	}
+	if diff := cmp.Diff(keys, []string{"keep/old", "tmp/new"}); diff != "" {
+		t.Errorf("got keys %v diff %s", keys, diff)
+	}
+
+	// Removing the rules.
+	if err := b.SetLifecycleRules(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	rules, err = b.LifecycleRules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 0 {
+		t.Errorf("got %v rules, want none", rules)
+	}
+}
+
+func TestCompose(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "fileblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, content := range map[string]string{"a": "aaa", "b": "bb", "c": "c"} {
+		if err := b.WriteAll(ctx, key, []byte(content), &blob.WriterOptions{ContentType: "text/x-" + key}); err != nil {
+			t.Fatal(err)
 		}
 	}
+
+	// Sources are concatenated in order, and the content type defaults to
+	// that of the first source.
+	opts := &blob.ComposeOptions{Metadata: map[string]string{"Foo": "bar"}}
+	if err := b.Compose(ctx, "dst", []string{"c", "a", "b", "a"}, opts); err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.ReadAll(ctx, "dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "caaabbaaa"; string(got) != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+	attrs, err := b.Attributes(ctx, "dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "text/x-c"; attrs.ContentType != want {
+		t.Errorf("got content type %q want %q", attrs.ContentType, want)
+	}
+	if diff := cmp.Diff(attrs.Metadata, map[string]string{"foo": "bar"}); diff != "" {
+		t.Errorf("got metadata diff %s", diff)
+	}
+
+	// dst may be one of the sources.
+	if err := b.Compose(ctx, "a", []string{"a", "b"}, &blob.ComposeOptions{ContentType: "text/plain"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err = b.ReadAll(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "aaabb"; string(got) != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+
+	// A missing source is reported, and dst is left alone.
+	err = b.Compose(ctx, "dst", []string{"a", "missing"}, nil)
+	if !blob.IsNotExist(err) {
+		t.Errorf("got error %v for missing source, want IsNotExist", err)
+	}
+	got, err = b.ReadAll(ctx, "dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "caaabbaaa"; string(got) != want {
+		t.Errorf("after failed compose, got %q want %q", got, want)
+	}
 }
 
-func TestUnescape(t *testing.T) {
-	for _, tc := range []struct {
-		filename, want string
-		wantErr        bool
-	}{
-		{
-			filename: "%7E",
-			want:     "~",
-		},
-		{
-			filename: "abc%7Eabc",
-			want:     "abc~abc",
-		},
-		{
-			filename: "%7e",
-			wantErr:  true, // wrong case in hex
-		},
-		{
-			filename: "aa~bb",
-			wantErr:  true, // ~ should be escaped
-		},
-		{
-			filename: "abc%gabc",
-			wantErr:  true, // invalid hex after %
-		},
+func TestBucketAdmin(t *testing.T) {
+	ctx := context.Background()
+	parent, err := ioutil.TempDir("", "fileblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(parent)
+	dir := filepath.Join(parent, "bucket")
+
+	b, err := blob.Open(ctx, "file://"+filepath.ToSlash(dir)+"?allow_missing_dir=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err := b.BucketExists(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("got BucketExists true before CreateBucket, want false")
+	}
+	if err := b.DeleteBucket(ctx); !blob.IsNotExist(err) {
+		t.Errorf("got DeleteBucket error %v for missing bucket, want IsNotExist", err)
+	}
+	if err := b.CreateBucket(ctx, &blob.CreateBucketOptions{Location: "ignored"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.CreateBucket(ctx, nil); err == nil {
+		t.Error("got nil error creating an existing bucket, want error")
+	}
+	exists, err = b.BucketExists(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Error("got BucketExists false after CreateBucket, want true")
+	}
+
+	// A bucket with blobs can't be deleted.
+	if err := b.WriteAll(ctx, "dir/key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.DeleteBucket(ctx); err == nil {
+		t.Error("got nil error deleting a non-empty bucket, want error")
+	}
+	if err := b.Delete(ctx, "dir/key"); err != nil {
+		t.Fatal(err)
+	}
+	// Lifecycle rules aren't blobs, so don't prevent deletion.
+	if err := b.SetLifecycleRules(ctx, []*blob.LifecycleRule{{DeleteAfterDays: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.DeleteBucket(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("got err %v for deleted bucket directory, want IsNotExist", err)
+	}
+
+	if _, err := blob.Open(ctx, "file://"+filepath.ToSlash(dir)+"?allow_missing_dir=maybe"); err == nil {
+		t.Error("got nil error for invalid allow_missing_dir, want error")
+	}
+}
+
+func TestIfNotExist(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "fileblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &blob.WriterOptions{ContentType: "text/plain", IfNotExist: true}
+	if err := b.WriteAll(ctx, "key", []byte("first"), opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteAll(ctx, "key", []byte("second"), opts); err == nil {
+		t.Error("got nil error overwriting with IfNotExist, want error")
+	}
+	got, err := b.ReadAll(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "first" {
+		t.Errorf("got %q want %q", got, "first")
+	}
+	attrs, err := b.Attributes(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.ContentType != "text/plain" {
+		t.Errorf("got ContentType %q want %q", attrs.ContentType, "text/plain")
+	}
+}
+
+// TestIfNotExistWithoutAttrs verifies that a failed conditional write leaves
+// an existing object without an attributes file unchanged.
+func TestIfNotExistWithoutAttrs(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "fileblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "key"), []byte("first"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	b, err := OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &blob.WriterOptions{ContentType: "application/json", IfNotExist: true}
+	if err := b.WriteAll(ctx, "key", []byte("second"), opts); err == nil {
+		t.Error("got nil error overwriting with IfNotExist, want error")
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		var names []string
+		for _, fi := range files {
+			names = append(names, fi.Name())
+		}
+		t.Errorf("got files %v after a failed write, want only the object", names)
+	}
+	attrs, err := b.Attributes(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.ContentType != "text/plain; charset=utf-8" {
+		t.Errorf("got ContentType %q want the detected %q", attrs.ContentType, "text/plain; charset=utf-8")
+	}
+}
+
+func TestContentTypeWithoutAttrs(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "fileblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"style.css": "body {}",
+		"page":      "<html><body></body></html>",
+		"data":      "\x00\x01\x02",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		"style.css": "text/css; charset=utf-8",
+		"page":      "text/html; charset=utf-8",
+		"data":      "application/octet-stream",
 	} {
-		got, err := unescape(tc.filename)
-		if tc.wantErr != (err != nil) {
-			t.Errorf("%s: got err %v want %v", tc.filename, err, tc.wantErr)
+		attrs, err := b.Attributes(ctx, key)
+		if err != nil {
+			t.Fatal(err)
 		}
-		if got != tc.want {
-			t.Errorf("%s: got unescaped %q want %q", tc.filename, got, tc.want)
+		if attrs.ContentType != want {
+			t.Errorf("%s: got ContentType %q want %q", key, attrs.ContentType, want)
 		}
 	}
 }
+
+func TestStorageClassIgnored(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "fileblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &blob.WriterOptions{ContentType: "text/plain", StorageClass: blob.StorageClassArchive}
+	if err := b.WriteAll(ctx, "key", []byte("hello"), opts); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := b.Attributes(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.StorageClass != "" {
+		t.Errorf("got StorageClass %q want empty", attrs.StorageClass)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fileblob
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/go-cloud/blob/driver"
+	"github.com/google/go-cloud/internal/escape"
+)
+
+// lifecycleFile is the name of the file in the bucket directory that stores
+// the bucket's lifecycle rules. It contains an invalid escape sequence, so it
+// never collides with the file for a key and is not visible using fileblob.
+const lifecycleFile = "%lifecycle.json"
+
+// LifecycleRules implements driver.LifecycleRules.
+func (b *bucket) LifecycleRules(ctx context.Context) ([]*driver.LifecycleRule, error) {
+	return readLifecycleRules(b.dir)
+}
+
+// SetLifecycleRules implements driver.SetLifecycleRules.
+func (b *bucket) SetLifecycleRules(ctx context.Context, rules []*driver.LifecycleRule) error {
+	path := filepath.Join(b.dir, lifecycleFile)
+	if len(rules) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(rules); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readLifecycleRules reads the lifecycle rules stored in dir. It doesn't
+// return an error when there are no rules.
+func readLifecycleRules(dir string) ([]*driver.LifecycleRule, error) {
+	f, err := os.Open(filepath.Join(dir, lifecycleFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var rules []*driver.LifecycleRule
+	if err := json.NewDecoder(f).Decode(&rules); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return rules, f.Close()
+}
+
+// Sweep enforces the lifecycle rules of the bucket in dir, deleting the
+// objects that have expired. Unlike cloud providers, fileblob never enforces
+// lifecycle rules in the background, so Sweep must be called explicitly; for
+// example, from tests or a periodic job during local development.
+//
+// An object's age is measured from the modification time of its file.
+func Sweep(ctx context.Context, dir string) error {
+	dir = filepath.Clean(dir)
+	rules, err := readLifecycleRules(dir)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+	now := time.Now()
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Couldn't read this file/directory for some reason (e.g., it's an
+			// attributes file we just removed); just skip it.
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(path, attrsExt) {
+			return nil
+		}
+		key, err := escape.Key(path[len(dir)+1:], os.PathSeparator)
+		if err != nil {
+			// Not a blob, e.g. the lifecycle file itself.
+			return nil
+		}
+		for _, r := range rules {
+			if !strings.HasPrefix(key, r.Prefix) {
+				continue
+			}
+			if now.Sub(info.ModTime()) < time.Duration(r.DeleteAfterDays)*24*time.Hour {
+				continue
+			}
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err := os.Remove(path + attrsExt); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			break
+		}
+		return nil
+	})
+}
@@ -23,6 +23,8 @@
 // - access_id: Sets Options.GoogleAccessID.
 // - private_key_path: Sets path to a private key, which is read and used
 //       to set Options.PrivateKey.
+// - project_id: Sets Options.ProjectID. If unset, the project ID of the
+//       credentials is used.
 // Example URL: blob.Open("gs://mybucket")
 //
 // It exposes the following types for As:
@@ -33,6 +35,21 @@
 // Reader: storage.Reader
 // Attributes: storage.ObjectAttrs
 // WriterOptions.BeforeWrite: *storage.Writer
+//
+// WriterOptions.StorageClass and CreateBucketOptions.StorageClass map to the
+// GCS storage classes STANDARD, NEARLINE and COLDLINE. In Attributes, MULTI_REGIONAL, REGIONAL and
+// DURABLE_REDUCED_AVAILABILITY are reported as blob.StorageClassStandard.
+//
+// Attributes.ETag is the object's generation number.
+//
+// GCS lifecycle conditions can't match key prefixes, so SetLifecycleRules
+// returns an error for which blob.IsNotImplemented is true if any rule has a
+// Prefix.
+//
+// blob.IsNotExist returns true for errors caused by the bucket not existing,
+// as well as the object. GCS reports a missing bucket for listings and bucket
+// operations; reads of an object in a missing bucket report that the object
+// doesn't exist.
 package gcsblob
 
 import (
@@ -40,8 +57,10 @@ import (
 	"errors"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -54,6 +73,7 @@ import (
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
+	raw "google.golang.org/api/storage/v1"
 )
 
 const defaultPageSize = 1000
@@ -75,6 +95,10 @@ func init() {
 			opts.PrivateKey = pk
 		}
 
+		if projectID := q["project_id"]; len(projectID) > 0 {
+			opts.ProjectID = projectID[0]
+		}
+
 		var creds *google.Credentials
 		if credPath := q["cred_path"]; len(credPath) == 0 {
 			var err error
@@ -93,6 +117,10 @@ func init() {
 			}
 		}
 
+		if opts.ProjectID == "" {
+			opts.ProjectID = creds.ProjectID
+		}
+
 		client, err := gcp.NewHTTPClient(gcp.DefaultTransport(), gcp.CredentialsTokenSource(creds))
 		if err != nil {
 			return nil, err
@@ -117,6 +145,10 @@ type Options struct {
 	// Exactly one of PrivateKey or SignBytes must be non-nil to use SignedURL.
 	// See https://godoc.org/cloud.google.com/go/storage#SignedURLOptions.
 	SignBytes func([]byte) ([]byte, error)
+
+	// ProjectID is the ID of the project that the bucket is created in.
+	// Required to use CreateBucket.
+	ProjectID string
 }
 
 // openBucket returns a GCS Bucket that communicates using the given HTTP client.
@@ -128,10 +160,14 @@ func openBucket(ctx context.Context, bucketName string, client *gcp.HTTPClient,
 	if err != nil {
 		return nil, err
 	}
+	rc, err := raw.New(&client.Client)
+	if err != nil {
+		return nil, err
+	}
 	if opts == nil {
 		opts = &Options{}
 	}
-	return &bucket{name: bucketName, client: c, opts: opts}, nil
+	return &bucket{name: bucketName, client: c, raw: rc, opts: opts}, nil
 }
 
 // OpenBucket returns a GCS Bucket that communicates using the given HTTP client.
@@ -148,7 +184,9 @@ func OpenBucket(ctx context.Context, bucketName string, client *gcp.HTTPClient,
 type bucket struct {
 	name   string
 	client *storage.Client
-	opts   *Options
+	// raw is used for the few requests that client can't make.
+	raw  *raw.Service
+	opts *Options
 }
 
 var emptyBody = ioutil.NopCloser(strings.NewReader(""))
@@ -184,12 +222,22 @@ func (r *reader) As(i interface{}) bool {
 
 // IsNotExist implements driver.IsNotExist.
 func (b *bucket) IsNotExist(err error) bool {
-	return err == storage.ErrObjectNotExist
+	return err == storage.ErrObjectNotExist || err == storage.ErrBucketNotExist
 }
 
+var errNotImplemented = errors.New("not implemented")
+
 // IsNotImplemented implements driver.IsNotImplemented.
 func (b *bucket) IsNotImplemented(err error) bool {
-	return false
+	return err == errNotImplemented
+}
+
+// IsRetryable implements driver.IsRetryable.
+func (b *bucket) IsRetryable(err error) bool {
+	if e, ok := err.(*googleapi.Error); ok {
+		return e.Code == http.StatusTooManyRequests || e.Code >= 500
+	}
+	return err == io.ErrUnexpectedEOF
 }
 
 // ListPaged implements driver.ListPaged.
@@ -291,10 +339,12 @@ func (b *bucket) Attributes(ctx context.Context, key string) (driver.Attributes,
 		return driver.Attributes{}, err
 	}
 	return driver.Attributes{
-		ContentType: attrs.ContentType,
-		Metadata:    attrs.Metadata,
-		ModTime:     attrs.Updated,
-		Size:        attrs.Size,
+		ContentType:  attrs.ContentType,
+		Metadata:     attrs.Metadata,
+		ModTime:      attrs.Updated,
+		Size:         attrs.Size,
+		ETag:         strconv.FormatInt(attrs.Generation, 10),
+		StorageClass: fromGCSStorageClass(attrs.StorageClass),
 		AsFunc: func(i interface{}) bool {
 			p, ok := i.(*storage.ObjectAttrs)
 			if !ok {
@@ -326,15 +376,46 @@ func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length
 	}, nil
 }
 
+// toGCSStorageClass returns the GCS storage class for sc, or "" for the
+// bucket's default.
+func toGCSStorageClass(sc driver.StorageClass) string {
+	switch sc {
+	case driver.StorageClassStandard:
+		return "STANDARD"
+	case driver.StorageClassInfrequent:
+		return "NEARLINE"
+	case driver.StorageClassArchive:
+		return "COLDLINE"
+	}
+	return ""
+}
+
+// fromGCSStorageClass returns the storage class for a GCS storage class.
+func fromGCSStorageClass(sc string) driver.StorageClass {
+	switch sc {
+	case "STANDARD", "MULTI_REGIONAL", "REGIONAL", "DURABLE_REDUCED_AVAILABILITY":
+		return driver.StorageClassStandard
+	case "NEARLINE":
+		return driver.StorageClassInfrequent
+	case "COLDLINE", "ARCHIVE":
+		return driver.StorageClassArchive
+	}
+	return driver.StorageClassDefault
+}
+
 // NewTypedWriter implements driver.NewTypedWriter.
 func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
 	bkt := b.client.Bucket(b.name)
 	obj := bkt.Object(key)
+	if opts.IfNotExist {
+		obj = obj.If(storage.Conditions{DoesNotExist: true})
+	}
 	w := obj.NewWriter(ctx)
 	w.ContentType = contentType
 	w.ChunkSize = bufferSize(opts.BufferSize)
 	w.Metadata = opts.Metadata
 	w.MD5 = opts.ContentMD5
+	w.StorageClass = toGCSStorageClass(opts.StorageClass)
 	if opts.BeforeWrite != nil {
 		asFunc := func(i interface{}) bool {
 			p, ok := i.(**storage.Writer)
@@ -358,6 +439,66 @@ func (b *bucket) Delete(ctx context.Context, key string) error {
 	return obj.Delete(ctx)
 }
 
+// maxComposeSources is the maximum number of source objects GCS accepts in a
+// single compose request.
+const maxComposeSources = 32
+
+// Compose implements driver.Compose.
+func (b *bucket) Compose(ctx context.Context, dst string, srcs []string, opts *driver.ComposeOptions) error {
+	if len(srcs) > maxComposeSources {
+		return errNotImplemented
+	}
+	bkt := b.client.Bucket(b.name)
+	objs := make([]*storage.ObjectHandle, len(srcs))
+	for i, src := range srcs {
+		objs[i] = bkt.Object(src)
+	}
+	c := bkt.Object(dst).ComposerFrom(objs...)
+	c.ContentType = opts.ContentType
+	c.Metadata = opts.Metadata
+	if _, err := c.Run(ctx); err != nil {
+		// Unlike reads, compose reports missing sources as a plain 404.
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+			return storage.ErrObjectNotExist
+		}
+		return err
+	}
+	return nil
+}
+
+// CreateBucket implements driver.CreateBucket.
+func (b *bucket) CreateBucket(ctx context.Context, opts *driver.CreateBucketOptions) error {
+	if b.opts.ProjectID == "" {
+		return errors.New("to use CreateBucket, you must call OpenBucket with a non-empty Options.ProjectID")
+	}
+	attrs := &storage.BucketAttrs{
+		Location:     opts.Location,
+		StorageClass: toGCSStorageClass(opts.StorageClass),
+	}
+	return b.client.Bucket(b.name).Create(ctx, b.opts.ProjectID, attrs)
+}
+
+// DeleteBucket implements driver.DeleteBucket.
+func (b *bucket) DeleteBucket(ctx context.Context) error {
+	err := b.client.Bucket(b.name).Delete(ctx)
+	if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+		return storage.ErrBucketNotExist
+	}
+	return err
+}
+
+// BucketExists implements driver.BucketExists.
+func (b *bucket) BucketExists(ctx context.Context) (bool, error) {
+	_, err := b.client.Bucket(b.name).Attrs(ctx)
+	if err == storage.ErrBucketNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (b *bucket) SignedURL(ctx context.Context, key string, dopts *driver.SignedURLOptions) (string, error) {
 	if b.opts.GoogleAccessID == "" || (b.opts.PrivateKey == nil && b.opts.SignBytes == nil) {
 		return "", errors.New("to use SignedURL, you must call OpenBucket with a valid Options.GoogleAccessID and exactly one of Options.PrivateKey or Options.SignBytes")
@@ -372,6 +513,49 @@ func (b *bucket) SignedURL(ctx context.Context, key string, dopts *driver.Signed
 	return storage.SignedURL(b.name, key, opts)
 }
 
+// LifecycleRules implements driver.LifecycleRules.
+func (b *bucket) LifecycleRules(ctx context.Context) ([]*driver.LifecycleRule, error) {
+	attrs, err := b.client.Bucket(b.name).Attrs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var rules []*driver.LifecycleRule
+	for _, r := range attrs.Lifecycle.Rules {
+		// Only rules that delete objects based on their age alone can be
+		// represented.
+		c := r.Condition
+		if r.Action.Type != storage.DeleteAction || c.AgeInDays <= 0 || !c.CreatedBefore.IsZero() || c.Liveness != storage.LiveAndArchived || len(c.MatchesStorageClasses) > 0 || c.NumNewerVersions != 0 {
+			continue
+		}
+		rules = append(rules, &driver.LifecycleRule{DeleteAfterDays: int(c.AgeInDays)})
+	}
+	return rules, nil
+}
+
+// SetLifecycleRules implements driver.SetLifecycleRules.
+// GCS lifecycle conditions can't match object name prefixes, so rules with a
+// non-empty Prefix are not implemented.
+func (b *bucket) SetLifecycleRules(ctx context.Context, rules []*driver.LifecycleRule) error {
+	if len(rules) == 0 {
+		// client leaves an empty Lifecycle out of the update, so it can't
+		// remove the rules.
+		_, err := b.raw.Buckets.Patch(b.name, &raw.Bucket{NullFields: []string{"Lifecycle"}}).Context(ctx).Do()
+		return err
+	}
+	lc := &storage.Lifecycle{}
+	for _, r := range rules {
+		if r.Prefix != "" {
+			return errNotImplemented
+		}
+		lc.Rules = append(lc.Rules, storage.LifecycleRule{
+			Action:    storage.LifecycleAction{Type: storage.DeleteAction},
+			Condition: storage.LifecycleCondition{AgeInDays: int64(r.DeleteAfterDays)},
+		})
+	}
+	_, err := b.client.Bucket(b.name).Update(ctx, storage.BucketAttrsToUpdate{Lifecycle: lc})
+	return err
+}
+
 func bufferSize(size int) int {
 	if size == 0 {
 		return googleapi.DefaultUploadChunkSize
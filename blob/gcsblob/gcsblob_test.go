@@ -16,11 +16,18 @@ package gcsblob
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 
 	"cloud.google.com/go/storage"
@@ -29,8 +36,10 @@ import (
 	"github.com/google/go-cloud/blob/drivertest"
 	"github.com/google/go-cloud/gcp"
 	"github.com/google/go-cloud/internal/testing/setup"
+	"github.com/google/go-cmp/cmp"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
+	raw "google.golang.org/api/storage/v1"
 )
 
 const (
@@ -89,7 +98,11 @@ func (h *harness) MakeDriver(ctx context.Context) (driver.Bucket, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &bucket{name: bucketName, client: c, opts: h.opts}, nil
+	rc, err := raw.New(&h.client.Client)
+	if err != nil {
+		return nil, err
+	}
+	return &bucket{name: bucketName, client: c, raw: rc, opts: h.opts}, nil
 }
 
 func (h *harness) Close() {
@@ -200,3 +213,365 @@ func TestBufferSize(t *testing.T) {
 		}
 	}
 }
+
+func TestStorageClass(t *testing.T) {
+	for _, sc := range []driver.StorageClass{driver.StorageClassStandard, driver.StorageClassInfrequent, driver.StorageClassArchive} {
+		if got := fromGCSStorageClass(toGCSStorageClass(sc)); got != sc {
+			t.Errorf("%q: got %q after a round trip", sc, got)
+		}
+	}
+	tests := []struct {
+		class string
+		want  driver.StorageClass
+	}{
+		{"", driver.StorageClassDefault},
+		{"MULTI_REGIONAL", driver.StorageClassStandard},
+		{"NEARLINE", driver.StorageClassInfrequent},
+		{"COLDLINE", driver.StorageClassArchive},
+	}
+	for _, test := range tests {
+		if got := fromGCSStorageClass(test.class); got != test.want {
+			t.Errorf("%q: got %q want %q", test.class, got, test.want)
+		}
+	}
+}
+
+// fakeGCS is a minimal in-process server for the GCS JSON API. It supports
+// creating, deleting, getting and updating buckets, listing an empty page of
+// objects, and composing objects.
+type fakeGCS struct {
+	mu      sync.Mutex
+	buckets map[string]*raw.Bucket
+	// objects maps "bucket/name" to objects. Content is stored in the
+	// object's Metadata under "content", for brevity.
+	objects map[string]*raw.Object
+}
+
+func (s *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeError := func(code int) {
+		w.WriteHeader(code)
+		fmt.Fprintf(w, `{"error": {"code": %d, "message": %q}}`, code, http.StatusText(code))
+	}
+	// Object names are escaped in the path, so split it before unescaping.
+	parts := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/storage/v1/"), "/")
+	for i := range parts {
+		parts[i], _ = url.PathUnescape(parts[i])
+	}
+	if parts[0] != "b" {
+		writeError(http.StatusNotImplemented)
+		return
+	}
+	if len(parts) == 1 && r.Method == "POST" {
+		var b raw.Bucket
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil || r.URL.Query().Get("project") == "" {
+			writeError(http.StatusBadRequest)
+			return
+		}
+		if _, ok := s.buckets[b.Name]; ok {
+			writeError(http.StatusConflict)
+			return
+		}
+		s.buckets[b.Name] = &b
+		json.NewEncoder(w).Encode(b)
+		return
+	}
+	if len(parts) < 2 {
+		writeError(http.StatusNotImplemented)
+		return
+	}
+	b, ok := s.buckets[parts[1]]
+	if !ok {
+		writeError(http.StatusNotFound)
+		return
+	}
+	if len(parts) == 5 && parts[2] == "o" && parts[4] == "compose" && r.Method == "POST" {
+		s.compose(w, r, parts[1], parts[3], writeError)
+		return
+	}
+	if len(parts) == 3 && parts[2] == "o" && r.Method == "GET" {
+		json.NewEncoder(w).Encode(raw.Objects{})
+		return
+	}
+	if len(parts) != 2 {
+		writeError(http.StatusNotImplemented)
+		return
+	}
+	switch r.Method {
+	case "DELETE":
+		for key := range s.objects {
+			if strings.HasPrefix(key, b.Name+"/") {
+				writeError(http.StatusConflict)
+				return
+			}
+		}
+		delete(s.buckets, b.Name)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case "GET":
+	case "PATCH":
+		// Decode the fields separately to tell a null field from a missing
+		// one.
+		var update map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			writeError(http.StatusBadRequest)
+			return
+		}
+		if lc, ok := update["lifecycle"]; ok {
+			b.Lifecycle = nil
+			if err := json.Unmarshal(lc, &b.Lifecycle); err != nil {
+				writeError(http.StatusBadRequest)
+				return
+			}
+		}
+	default:
+		writeError(http.StatusMethodNotAllowed)
+		return
+	}
+	json.NewEncoder(w).Encode(b)
+}
+
+// compose handles a compose request for bucket/dst.
+func (s *fakeGCS) compose(w http.ResponseWriter, r *http.Request, bucket, dst string, writeError func(int)) {
+	var req raw.ComposeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.SourceObjects) > 32 {
+		writeError(http.StatusBadRequest)
+		return
+	}
+	var content string
+	for _, src := range req.SourceObjects {
+		obj, ok := s.objects[bucket+"/"+src.Name]
+		if !ok {
+			writeError(http.StatusNotFound)
+			return
+		}
+		content += obj.Metadata["content"]
+	}
+	obj := req.Destination
+	if obj == nil {
+		obj = &raw.Object{}
+	}
+	obj.Bucket, obj.Name = bucket, dst
+	if obj.Metadata == nil {
+		obj.Metadata = map[string]string{}
+	}
+	obj.Metadata["content"] = content
+	s.objects[bucket+"/"+dst] = obj
+	json.NewEncoder(w).Encode(obj)
+}
+
+// newFakeGCSBucket returns a driver for bucket name on srv.
+func newFakeGCSBucket(ctx context.Context, t *testing.T, srv *httptest.Server, name string) *bucket {
+	endpoint := srv.URL + "/storage/v1/"
+	c, err := storage.NewClient(ctx, option.WithEndpoint(endpoint), option.WithHTTPClient(srv.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := raw.New(srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.BasePath = endpoint
+	return &bucket{name: name, client: c, raw: rc, opts: &Options{}}
+}
+
+// fakeHarness runs drivertest tests against a fakeGCS.
+type fakeHarness struct {
+	t   *testing.T
+	srv *httptest.Server
+}
+
+func newFakeHarness(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+	fake := &fakeGCS{buckets: map[string]*raw.Bucket{"bucket": {Name: "bucket"}}}
+	return &fakeHarness{t: t, srv: httptest.NewServer(fake)}, nil
+}
+
+func (h *fakeHarness) HTTPClient() *http.Client {
+	return nil
+}
+
+func (h *fakeHarness) MakeDriver(ctx context.Context) (driver.Bucket, error) {
+	return newFakeGCSBucket(ctx, h.t, h.srv, "bucket"), nil
+}
+
+func (h *fakeHarness) Close() {
+	h.srv.Close()
+}
+
+func TestLifecycleConformance(t *testing.T) {
+	drivertest.RunLifecycleTests(t, newFakeHarness)
+}
+
+func TestLifecycle(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeGCS{buckets: map[string]*raw.Bucket{"bucket": {Name: "bucket"}}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	b := blob.NewBucket(newFakeGCSBucket(ctx, t, srv, "bucket"))
+
+	rules, err := b.LifecycleRules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 0 {
+		t.Errorf("got %d rules for a new bucket, want 0", len(rules))
+	}
+	want := []*blob.LifecycleRule{{DeleteAfterDays: 30}, {DeleteAfterDays: 365}}
+	if err := b.SetLifecycleRules(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+	got := fake.buckets["bucket"].Lifecycle.Rule
+	if len(got) != 2 || got[0].Action.Type != "Delete" || got[0].Condition.Age != 30 || got[1].Condition.Age != 365 {
+		t.Errorf("got GCS rules %+v, want Delete rules with age 30 and 365", got)
+	}
+	rules, err = b.LifecycleRules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(rules, want); diff != "" {
+		t.Errorf("got rules diff %s", diff)
+	}
+
+	// GCS can't match prefixes.
+	if err := b.SetLifecycleRules(ctx, []*blob.LifecycleRule{{Prefix: "tmp/", DeleteAfterDays: 1}}); !blob.IsNotImplemented(err) {
+		t.Errorf("got err %v for a rule with a prefix, want IsNotImplemented", err)
+	}
+
+	// Rules that do more than delete objects by age are left out.
+	isLive := true
+	fake.buckets["bucket"].Lifecycle = &raw.BucketLifecycle{Rule: []*raw.BucketLifecycleRule{
+		{Action: &raw.BucketLifecycleRuleAction{Type: "SetStorageClass", StorageClass: "NEARLINE"}, Condition: &raw.BucketLifecycleRuleCondition{Age: 10}},
+		{Action: &raw.BucketLifecycleRuleAction{Type: "Delete"}, Condition: &raw.BucketLifecycleRuleCondition{Age: 10, IsLive: &isLive}},
+		{Action: &raw.BucketLifecycleRuleAction{Type: "Delete"}, Condition: &raw.BucketLifecycleRuleCondition{NumNewerVersions: 3}},
+		{Action: &raw.BucketLifecycleRuleAction{Type: "Delete"}, Condition: &raw.BucketLifecycleRuleCondition{Age: 7}},
+	}}
+	rules, err = b.LifecycleRules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(rules, []*blob.LifecycleRule{{DeleteAfterDays: 7}}); diff != "" {
+		t.Errorf("got rules diff %s", diff)
+	}
+
+	if err := b.SetLifecycleRules(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	rules, err = b.LifecycleRules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 0 {
+		t.Errorf("got %d rules after clearing them, want 0", len(rules))
+	}
+}
+
+func TestCompose(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeGCS{
+		buckets: map[string]*raw.Bucket{"bucket": {Name: "bucket"}},
+		objects: map[string]*raw.Object{},
+	}
+	var srcs []string
+	for i := 0; i <= maxComposeSources; i++ {
+		name := fmt.Sprintf("dir/src%d", i)
+		fake.objects["bucket/"+name] = &raw.Object{Name: name, Metadata: map[string]string{"content": strconv.Itoa(i % 10)}}
+		srcs = append(srcs, name)
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	b := newFakeGCSBucket(ctx, t, srv, "bucket")
+
+	// Sources are concatenated in order.
+	opts := &driver.ComposeOptions{ContentType: "text/plain", Metadata: map[string]string{"foo": "bar"}}
+	if err := b.Compose(ctx, "dir/dst", []string{srcs[2], srcs[0], srcs[1]}, opts); err != nil {
+		t.Fatal(err)
+	}
+	dst := fake.objects["bucket/dir/dst"]
+	if dst == nil {
+		t.Fatal("composed object was not created")
+	}
+	if got := dst.Metadata["content"]; got != "201" {
+		t.Errorf("got content %q want %q", got, "201")
+	}
+	if dst.ContentType != "text/plain" || dst.Metadata["foo"] != "bar" {
+		t.Errorf("got ContentType %q and metadata %v, want %q and foo=bar", dst.ContentType, dst.Metadata, "text/plain")
+	}
+
+	// GCS accepts up to 32 sources; Bucket.Compose streams larger compositions.
+	if err := b.Compose(ctx, "dir/dst", srcs[:maxComposeSources], opts); err != nil {
+		t.Errorf("got err %v composing %d sources, want nil", err, maxComposeSources)
+	}
+	delete(fake.objects, "bucket/dir/dst")
+	if err := b.Compose(ctx, "dir/dst", srcs, opts); !b.IsNotImplemented(err) {
+		t.Errorf("got err %v composing %d sources, want IsNotImplemented", err, len(srcs))
+	}
+	if _, ok := fake.objects["bucket/dir/dst"]; ok {
+		t.Errorf("composing %d sources created the object, want it rejected before any request", len(srcs))
+	}
+
+	if err := b.Compose(ctx, "dir/dst", []string{srcs[0], "missing"}, opts); !b.IsNotExist(err) {
+		t.Errorf("got err %v for a missing source, want IsNotExist", err)
+	}
+}
+
+func TestBucketAdmin(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeGCS{buckets: map[string]*raw.Bucket{}, objects: map[string]*raw.Object{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	drv := newFakeGCSBucket(ctx, t, srv, "newbucket")
+	b := blob.NewBucket(drv)
+
+	if err := b.CreateBucket(ctx, nil); err == nil {
+		t.Error("got nil error from CreateBucket without a project ID, want error")
+	}
+	drv.opts.ProjectID = "project"
+	exists, err := b.BucketExists(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("got BucketExists true before CreateBucket, want false")
+	}
+	// Operations on a missing bucket report IsNotExist.
+	if _, err := b.List(nil).Next(ctx); !blob.IsNotExist(err) {
+		t.Errorf("got err %v listing a missing bucket, want IsNotExist", err)
+	}
+	if _, err := b.LifecycleRules(ctx); !blob.IsNotExist(err) {
+		t.Errorf("got err %v reading lifecycle rules of a missing bucket, want IsNotExist", err)
+	}
+
+	opts := &blob.CreateBucketOptions{Location: "EU", StorageClass: blob.StorageClassInfrequent}
+	if err := b.CreateBucket(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.buckets["newbucket"]; got.Location != "EU" || got.StorageClass != "NEARLINE" {
+		t.Errorf("got location %q and storage class %q, want %q and %q", got.Location, got.StorageClass, "EU", "NEARLINE")
+	}
+	if err := b.CreateBucket(ctx, nil); err == nil || blob.IsNotExist(err) {
+		t.Errorf("got err %v creating an existing bucket, want a conflict", err)
+	}
+	exists, err = b.BucketExists(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Error("got BucketExists false after CreateBucket, want true")
+	}
+	if _, err := b.List(nil).Next(ctx); err != io.EOF {
+		t.Errorf("got err %v listing an empty bucket, want io.EOF", err)
+	}
+
+	fake.objects["newbucket/key"] = &raw.Object{Name: "key"}
+	if err := b.DeleteBucket(ctx); err == nil || blob.IsNotExist(err) {
+		t.Errorf("got err %v deleting a non-empty bucket, want a conflict", err)
+	}
+	delete(fake.objects, "newbucket/key")
+	if err := b.DeleteBucket(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.DeleteBucket(ctx); !blob.IsNotExist(err) {
+		t.Errorf("got err %v deleting a deleted bucket, want IsNotExist", err)
+	}
+}
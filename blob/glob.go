@@ -0,0 +1,180 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// globSeparator is the delimiter used to split keys and glob patterns into
+// segments.
+const globSeparator = "/"
+
+// globAnySegments is a pattern segment that matches zero or more key segments.
+const globAnySegments = "**"
+
+// GlobIterator is used to iterate over ListGlob results.
+type GlobIterator struct {
+	b       *Bucket
+	pattern []string
+	err     error
+	// stack holds the listings in progress, innermost "directory" last.
+	stack []*globLevel
+}
+
+// globLevel is a single listing performed by GlobIterator.
+type globLevel struct {
+	iter *ListIterator
+	// dir is the "directory" being listed; it is empty or ends with
+	// globSeparator.
+	dir string
+	// depth is the index of the pattern segment that the next key segment
+	// after dir must match.
+	depth int
+	// flat is true if the listing was done without a delimiter because
+	// pattern[depth] is globAnySegments.
+	flat bool
+}
+
+// ListGlob returns an object that can be used to iterate over the objects in a
+// bucket whose keys match pattern, in lexicographical order of UTF-8 encoded
+// keys. "Directory" results are never returned.
+//
+// The pattern is split into segments on "/", and each segment is matched
+// against the corresponding segment of the key using the syntax of path.Match.
+// In addition, a segment consisting of "**" matches zero or more key segments.
+// For example, "events/2018-*/*.parquet" matches "events/2018-01/a.parquet" but
+// not "events/2018-01/x/a.parquet", while "events/2018-*/**/*.parquet" matches
+// both.
+//
+// Only the longest literal prefix of pattern is passed to the provider, and
+// "directories" that can't match are not listed. If pattern is malformed, the
+// first call to Next returns an error describing the invalid pattern.
+func (b *Bucket) ListGlob(pattern string) *GlobIterator {
+	it := &GlobIterator{b: b, pattern: strings.Split(pattern, globSeparator)}
+	for _, seg := range it.pattern {
+		if _, err := path.Match(seg, ""); err != nil {
+			it.err = fmt.Errorf("blob.ListGlob: invalid pattern %q: %v", pattern, err)
+			return it
+		}
+	}
+	// Skip over leading segments without any special characters; there is no
+	// need to list them level by level.
+	var dir string
+	depth := 0
+	for depth < len(it.pattern)-1 && isLiteralSegment(it.pattern[depth]) {
+		dir += it.pattern[depth] + globSeparator
+		depth++
+	}
+	it.push(dir, depth)
+	return it
+}
+
+// push starts listing the "directory" dir, whose keys will be matched against
+// it.pattern starting with segment depth.
+func (it *GlobIterator) push(dir string, depth int) {
+	seg := it.pattern[depth]
+	lvl := &globLevel{dir: dir, depth: depth}
+	opts := &ListOptions{Prefix: dir + literalPrefix(seg), Delimiter: globSeparator}
+	if seg == globAnySegments {
+		// Everything below dir can match; list it all at once.
+		lvl.flat = true
+		opts = &ListOptions{Prefix: dir}
+	}
+	lvl.iter = it.b.List(opts)
+	it.stack = append(it.stack, lvl)
+}
+
+// Next returns the next object whose key matches the pattern. It returns
+// (nil, io.EOF) if there are no more objects.
+func (it *GlobIterator) Next(ctx context.Context) (*ListObject, error) {
+	if it.err != nil {
+		return nil, it.err
+	}
+	for len(it.stack) > 0 {
+		lvl := it.stack[len(it.stack)-1]
+		obj, err := lvl.iter.Next(ctx)
+		if err == io.EOF {
+			it.stack = it.stack[:len(it.stack)-1]
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		rel := obj.Key[len(lvl.dir):]
+		if lvl.flat {
+			if matchSegments(it.pattern[lvl.depth:], strings.Split(rel, globSeparator)) {
+				return obj, nil
+			}
+			continue
+		}
+		if ok, _ := path.Match(it.pattern[lvl.depth], strings.TrimSuffix(rel, globSeparator)); !ok {
+			continue
+		}
+		last := lvl.depth == len(it.pattern)-1
+		if obj.IsDir {
+			// Only descend into "directories" if there are pattern segments left
+			// for the keys inside them to match.
+			if !last {
+				it.push(obj.Key, lvl.depth+1)
+			}
+			continue
+		}
+		if last {
+			return obj, nil
+		}
+	}
+	return nil, io.EOF
+}
+
+// isLiteralSegment reports whether seg matches only itself.
+func isLiteralSegment(seg string) bool {
+	return seg != globAnySegments && !strings.ContainsAny(seg, `*?[\`)
+}
+
+// literalPrefix returns the part of seg before its first special character.
+func literalPrefix(seg string) string {
+	if i := strings.IndexAny(seg, `*?[\`); i >= 0 {
+		return seg[:i]
+	}
+	return seg
+}
+
+// matchSegments reports whether the key segments in name match the pattern
+// segments in pattern.
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == globAnySegments {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
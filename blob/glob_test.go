@@ -0,0 +1,166 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob
+
+import (
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cloud/blob/driver"
+	"github.com/google/go-cmp/cmp"
+)
+
+// keyLister implements driver.Bucket. Only ListPaged is implemented, listing
+// keys with support for Prefix and Delimiter. Each ListPaged call is recorded
+// in listed.
+type keyLister struct {
+	driver.Bucket
+	keys   []string
+	listed []string
+}
+
+func (b *keyLister) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	b.listed = append(b.listed, opts.Prefix+"|"+opts.Delimiter)
+	sort.Strings(b.keys)
+	var page driver.ListPage
+	var lastDir string
+	for _, key := range b.keys {
+		if !strings.HasPrefix(key, opts.Prefix) {
+			continue
+		}
+		if opts.Delimiter != "" {
+			if i := strings.Index(key[len(opts.Prefix):], opts.Delimiter); i != -1 {
+				dir := key[:len(opts.Prefix)+i+len(opts.Delimiter)]
+				if dir != lastDir {
+					page.Objects = append(page.Objects, &driver.ListObject{Key: dir, IsDir: true})
+					lastDir = dir
+				}
+				continue
+			}
+		}
+		page.Objects = append(page.Objects, &driver.ListObject{Key: key})
+	}
+	return &page, nil
+}
+
+func TestListGlob(t *testing.T) {
+	keys := []string{
+		"events/2017-12/a.parquet",
+		"events/2018-01/a.parquet",
+		"events/2018-01/b.json",
+		"events/2018-01/x/c.parquet",
+		"events/2018-02/d.parquet",
+		"events/2018-02/y/z/e.parquet",
+		"events/2018.parquet",
+		"other/2018-01/a.parquet",
+		"top.parquet",
+	}
+
+	tests := []struct {
+		pattern    string
+		want       []string
+		wantListed []string
+		wantErr    bool
+	}{
+		{
+			pattern: "events/2018-*/*.parquet",
+			want: []string{
+				"events/2018-01/a.parquet",
+				"events/2018-02/d.parquet",
+			},
+			wantListed: []string{
+				"events/2018-|/",
+				"events/2018-01/|/",
+				"events/2018-02/|/",
+			},
+		},
+		{
+			pattern: "events/2018-*/**/*.parquet",
+			want: []string{
+				"events/2018-01/a.parquet",
+				"events/2018-01/x/c.parquet",
+				"events/2018-02/d.parquet",
+				"events/2018-02/y/z/e.parquet",
+			},
+			wantListed: []string{
+				"events/2018-|/",
+				"events/2018-01/|",
+				"events/2018-02/|",
+			},
+		},
+		{
+			pattern:    "**/a.parquet",
+			want:       []string{"events/2017-12/a.parquet", "events/2018-01/a.parquet", "other/2018-01/a.parquet"},
+			wantListed: []string{"|"},
+		},
+		{
+			pattern:    "*/2018-01/?.parquet",
+			want:       []string{"events/2018-01/a.parquet", "other/2018-01/a.parquet"},
+			wantListed: []string{"|/", "events/2018-01|/", "events/2018-01/|/", "other/2018-01|/", "other/2018-01/|/"},
+		},
+		{
+			pattern:    "events/2018-01/b.json",
+			want:       []string{"events/2018-01/b.json"},
+			wantListed: []string{"events/2018-01/b.json|/"},
+		},
+		{
+			pattern:    "*.parquet",
+			want:       []string{"top.parquet"},
+			wantListed: []string{"|/"},
+		},
+		{
+			pattern:    "events/[0-9]*/x",
+			wantListed: []string{"events/|/", "events/2017-12/x|/", "events/2018-01/x|/", "events/2018-02/x|/"},
+		},
+		{
+			pattern: "events/[",
+			wantErr: true,
+		},
+	}
+
+	ctx := context.Background()
+	for _, tc := range tests {
+		t.Run(tc.pattern, func(t *testing.T) {
+			drv := &keyLister{keys: keys}
+			iter := NewBucket(drv).ListGlob(tc.pattern)
+			var got []string
+			for {
+				obj, err := iter.Next(ctx)
+				if err == io.EOF {
+					break
+				}
+				if (err != nil) != tc.wantErr {
+					t.Fatalf("got err %v want error %v", err, tc.wantErr)
+				}
+				if err != nil {
+					return
+				}
+				got = append(got, obj.Key)
+			}
+			if tc.wantErr {
+				t.Fatal("got nil error, want error")
+			}
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("got\n%v\nwant\n%v\ndiff\n%s", got, tc.want, diff)
+			}
+			if diff := cmp.Diff(drv.listed, tc.wantListed); diff != "" {
+				t.Errorf("got listed\n%v\nwant\n%v\ndiff\n%s", drv.listed, tc.wantListed, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,474 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package httpblob provides a read-only bucket implementation that reads
+// blobs from a plain HTTP(S) server, such as a static file server.
+//
+// A blob's URL is formed by appending its key, with each "/"-separated
+// segment path-escaped, to the bucket's base URL. Attributes uses a HEAD
+// request, and NewRangeReader uses a GET request with a Range header.
+// Writes, deletes, signing and lifecycle rules are not supported; SignedURL
+// returns the unsigned blob URL, since it is already readable.
+//
+// HTTP servers have no standard way to list their contents. If
+// Options.IndexFile is set, ListPaged fetches that file from the base URL and
+// treats each non-empty line as a key; lines starting with "#" are ignored.
+// Without an index file, ListPaged is not implemented.
+//
+// For blob.Open URLs, httpblob registers for the "http" and "https" schemes.
+// The URL without its query string is used as the base URL.
+// The following query options are supported:
+// - index: Sets Options.IndexFile.
+// Example URL: blob.Open("https://example.com/data?index=index.txt")
+//
+// It exposes the following types for As:
+// Bucket: *http.Client
+// Error: *httpblob.StatusError
+// Reader: http.Response
+// Attributes: http.Response
+package httpblob
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-cloud/blob"
+	"github.com/google/go-cloud/blob/driver"
+)
+
+const defaultPageSize = 1000
+
+func init() {
+	opener := func(ctx context.Context, u *url.URL) (driver.Bucket, error) {
+		opts := &Options{IndexFile: u.Query().Get("index")}
+		base := *u
+		base.RawQuery = ""
+		return openBucket(ctx, base.String(), opts)
+	}
+	blob.Register("http", opener)
+	blob.Register("https", opener)
+}
+
+// Options sets options for constructing a *blob.Bucket backed by httpblob.
+type Options struct {
+	// Client is the HTTP client used to make requests.
+	// If nil, http.DefaultClient is used.
+	Client *http.Client
+	// IndexFile is the path of an index file, relative to the base URL,
+	// that lists the keys in the bucket, one per line. If empty, listing is
+	// not supported.
+	IndexFile string
+}
+
+type bucket struct {
+	base      *url.URL
+	client    *http.Client
+	indexFile string
+}
+
+// openBucket creates a driver.Bucket that reads from baseURL.
+func openBucket(ctx context.Context, baseURL string, opts *Options) (driver.Bucket, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("httpblob: unsupported scheme %q in base URL %q", u.Scheme, baseURL)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	u.RawPath = ""
+	u.RawQuery = ""
+	u.Fragment = ""
+	if opts == nil {
+		opts = &Options{}
+	}
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &bucket{base: u, client: client, indexFile: opts.IndexFile}, nil
+}
+
+// OpenBucket creates a *blob.Bucket that reads blobs relative to baseURL.
+func OpenBucket(ctx context.Context, baseURL string, opts *Options) (*blob.Bucket, error) {
+	drv, err := openBucket(ctx, baseURL, opts)
+	if err != nil {
+		return nil, err
+	}
+	return blob.NewBucket(drv), nil
+}
+
+var errNotImplemented = errors.New("not implemented")
+
+// StatusError is returned when the server responds with an unexpected HTTP
+// status code.
+type StatusError struct {
+	// URL is the URL that was requested.
+	URL string
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("httpblob: %s: %s", e.URL, http.StatusText(e.StatusCode))
+}
+
+// IsNotExist implements driver.IsNotExist.
+func (b *bucket) IsNotExist(err error) bool {
+	if e, ok := err.(*StatusError); ok {
+		return e.StatusCode == http.StatusNotFound || e.StatusCode == http.StatusGone
+	}
+	return false
+}
+
+// IsNotImplemented implements driver.IsNotImplemented.
+func (b *bucket) IsNotImplemented(err error) bool {
+	return err == errNotImplemented
+}
+
+// IsRetryable implements driver.IsRetryable.
+func (b *bucket) IsRetryable(err error) bool {
+	if e, ok := err.(*StatusError); ok {
+		return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+	}
+	// Errors from http.Client.Do, and some from reading response bodies.
+	if e, ok := err.(net.Error); ok {
+		return e.Timeout() || e.Temporary()
+	}
+	return err == io.ErrUnexpectedEOF
+}
+
+// As implements driver.As.
+func (b *bucket) As(i interface{}) bool {
+	p, ok := i.(**http.Client)
+	if !ok {
+		return false
+	}
+	*p = b.client
+	return true
+}
+
+// ErrorAs implements driver.ErrorAs.
+func (b *bucket) ErrorAs(err error, i interface{}) bool {
+	e, ok := err.(*StatusError)
+	if !ok {
+		return false
+	}
+	p, ok := i.(**StatusError)
+	if !ok {
+		return false
+	}
+	*p = e
+	return true
+}
+
+// urlForKey returns the URL of the blob for key.
+func (b *bucket) urlForKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return b.base.String() + strings.Join(segments, "/")
+}
+
+// do sends a request to u and returns the response if its status code is
+// one of okCodes.
+func (b *bucket) do(ctx context.Context, method, u string, header http.Header, okCodes ...int) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := b.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range okCodes {
+		if resp.StatusCode == c {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+	return nil, &StatusError{URL: u, StatusCode: resp.StatusCode}
+}
+
+// contentType returns the Content-Type of resp, defaulting to
+// application/octet-stream since driver attributes must have a content type.
+func contentType(resp *http.Response) string {
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// modTime returns the Last-Modified time of resp, or the zero time.
+func modTime(resp *http.Response) time.Time {
+	t, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return t
+}
+
+// Attributes implements driver.Attributes.
+func (b *bucket) Attributes(ctx context.Context, key string) (driver.Attributes, error) {
+	resp, err := b.do(ctx, "HEAD", b.urlForKey(key), nil, http.StatusOK)
+	if err != nil {
+		return driver.Attributes{}, err
+	}
+	resp.Body.Close()
+	return driver.Attributes{
+		ContentType: contentType(resp),
+		ModTime:     modTime(resp),
+		Size:        resp.ContentLength,
+		ETag:        resp.Header.Get("ETag"),
+		AsFunc: func(i interface{}) bool {
+			p, ok := i.(*http.Response)
+			if !ok {
+				return false
+			}
+			*p = *resp
+			return true
+		},
+	}, nil
+}
+
+// NewRangeReader implements driver.NewRangeReader.
+func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length int64) (driver.Reader, error) {
+	header := http.Header{}
+	if offset > 0 || length > 0 {
+		r := fmt.Sprintf("bytes=%d-", offset)
+		if length > 0 {
+			r += strconv.FormatInt(offset+length-1, 10)
+		}
+		header.Set("Range", r)
+	}
+	u := b.urlForKey(key)
+	resp, err := b.do(ctx, "GET", u, header, http.StatusOK, http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable)
+	if err != nil {
+		return nil, err
+	}
+	attrs := driver.ReaderAttributes{
+		ContentType: contentType(resp),
+		ModTime:     modTime(resp),
+	}
+	var body io.Reader = resp.Body
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// The server ignored the Range header and sent the whole object.
+		attrs.Size = resp.ContentLength
+		if offset > 0 {
+			if _, err := io.CopyN(ioutil.Discard, resp.Body, offset); err != nil && err != io.EOF {
+				resp.Body.Close()
+				return nil, err
+			}
+		}
+		if length > 0 {
+			body = io.LimitReader(body, length)
+		}
+	case http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+		// Content-Range is "bytes first-last/size" or "bytes */size".
+		size, err := rangeSize(resp.Header.Get("Content-Range"))
+		if err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("httpblob: %s: %v", u, err)
+		}
+		attrs.Size = size
+		if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			// The offset is at or past the end of the object.
+			body = strings.NewReader("")
+		}
+	}
+	return &reader{r: body, body: resp.Body, resp: resp, attrs: attrs}, nil
+}
+
+// rangeSize returns the complete size from a Content-Range header value.
+func rangeSize(cr string) (int64, error) {
+	i := strings.LastIndex(cr, "/")
+	if !strings.HasPrefix(cr, "bytes ") || i < 0 {
+		return 0, fmt.Errorf("invalid Content-Range %q", cr)
+	}
+	size, err := strconv.ParseInt(cr[i+1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Content-Range %q", cr)
+	}
+	return size, nil
+}
+
+type reader struct {
+	r     io.Reader
+	body  io.Closer
+	resp  *http.Response
+	attrs driver.ReaderAttributes
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}
+
+func (r *reader) Close() error {
+	return r.body.Close()
+}
+
+func (r *reader) Attributes() driver.ReaderAttributes {
+	return r.attrs
+}
+
+func (r *reader) As(i interface{}) bool {
+	p, ok := i.(*http.Response)
+	if !ok {
+		return false
+	}
+	*p = *r.resp
+	return true
+}
+
+// ListPaged implements driver.ListPaged.
+func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	if b.indexFile == "" {
+		return nil, errNotImplemented
+	}
+	if opts.BeforeList != nil {
+		if err := opts.BeforeList(func(interface{}) bool { return false }); err != nil {
+			return nil, err
+		}
+	}
+	keys, err := b.readIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pageSize := opts.PageSize
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+	pageToken := string(opts.PageToken)
+	var result driver.ListPage
+	var lastPrefix string
+	for _, key := range keys {
+		if !strings.HasPrefix(key, opts.Prefix) {
+			continue
+		}
+		obj := &driver.ListObject{Key: key}
+		// If using Delimiter, collapse "directories".
+		if opts.Delimiter != "" {
+			keyWithoutPrefix := key[len(opts.Prefix):]
+			if idx := strings.Index(keyWithoutPrefix, opts.Delimiter); idx != -1 {
+				prefix := opts.Prefix + keyWithoutPrefix[0:idx+len(opts.Delimiter)]
+				if prefix == lastPrefix {
+					continue
+				}
+				obj = &driver.ListObject{Key: prefix, IsDir: true}
+				lastPrefix = prefix
+			}
+		}
+		if pageToken != "" && obj.Key <= pageToken {
+			continue
+		}
+		if len(result.Objects) == pageSize {
+			result.NextPageToken = []byte(result.Objects[pageSize-1].Key)
+			break
+		}
+		result.Objects = append(result.Objects, obj)
+	}
+	return &result, nil
+}
+
+// readIndex fetches the index file and returns its keys, sorted and without
+// duplicates.
+func (b *bucket) readIndex(ctx context.Context) ([]string, error) {
+	resp, err := b.do(ctx, "GET", b.urlForKey(b.indexFile), nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var keys []string
+	s := bufio.NewScanner(resp.Body)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	n := 0
+	for i, k := range keys {
+		if i > 0 && k == keys[i-1] {
+			continue
+		}
+		keys[n] = k
+		n++
+	}
+	return keys[:n], nil
+}
+
+// NewTypedWriter implements driver.NewTypedWriter.
+func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	return nil, errNotImplemented
+}
+
+// Delete implements driver.Delete.
+func (b *bucket) Delete(ctx context.Context, key string) error {
+	return errNotImplemented
+}
+
+// Compose implements driver.Compose.
+func (b *bucket) Compose(ctx context.Context, dst string, srcs []string, opts *driver.ComposeOptions) error {
+	return errNotImplemented
+}
+
+// SignedURL implements driver.SignedURL.
+func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
+	return b.urlForKey(key), nil
+}
+
+// LifecycleRules implements driver.LifecycleRules.
+func (b *bucket) LifecycleRules(ctx context.Context) ([]*driver.LifecycleRule, error) {
+	return nil, errNotImplemented
+}
+
+// SetLifecycleRules implements driver.SetLifecycleRules.
+func (b *bucket) SetLifecycleRules(ctx context.Context, rules []*driver.LifecycleRule) error {
+	return errNotImplemented
+}
+
+// CreateBucket implements driver.CreateBucket.
+func (b *bucket) CreateBucket(ctx context.Context, opts *driver.CreateBucketOptions) error {
+	return errNotImplemented
+}
+
+// DeleteBucket implements driver.DeleteBucket.
+func (b *bucket) DeleteBucket(ctx context.Context) error {
+	return errNotImplemented
+}
+
+// BucketExists implements driver.BucketExists.
+func (b *bucket) BucketExists(ctx context.Context) (bool, error) {
+	return false, errNotImplemented
+}
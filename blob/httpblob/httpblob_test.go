@@ -0,0 +1,251 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpblob
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cloud/blob"
+	"github.com/google/go-cmp/cmp"
+)
+
+var testFiles = map[string]string{
+	"a.txt":            "hello world",
+	"dir/b c.txt":      "0123456789",
+	"dir/sub/d.txt":    "d",
+	"index.txt":        "# keys\na.txt\ndir/sub/d.txt\n\ndir/b c.txt\na.txt\n",
+	"other/not-listed": "x",
+}
+
+// newServer starts a static file server for testFiles rooted at /data/.
+func newServer(t *testing.T) (*httptest.Server, func()) {
+	dir, err := ioutil.TempDir("", "httpblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range testFiles {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ts := httptest.NewServer(http.StripPrefix("/data/", http.FileServer(http.Dir(dir))))
+	return ts, func() {
+		ts.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRead(t *testing.T) {
+	ctx := context.Background()
+	ts, done := newServer(t)
+	defer done()
+
+	b, err := blob.Open(ctx, ts.URL+"/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key            string
+		offset, length int64
+		want           string
+	}{
+		{key: "a.txt", length: -1, want: "hello world"},
+		{key: "a.txt", offset: 6, length: -1, want: "world"},
+		{key: "dir/b c.txt", offset: 2, length: 3, want: "234"},
+		{key: "dir/b c.txt", offset: 8, length: 10, want: "89"},
+		{key: "dir/b c.txt", offset: 10, length: -1, want: ""},
+	}
+	for _, tc := range tests {
+		r, err := b.NewRangeReader(ctx, tc.key, tc.offset, tc.length)
+		if err != nil {
+			t.Errorf("NewRangeReader(%q, %d, %d): %v", tc.key, tc.offset, tc.length, err)
+			continue
+		}
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Errorf("NewRangeReader(%q, %d, %d): read: %v", tc.key, tc.offset, tc.length, err)
+			continue
+		}
+		if string(got) != tc.want {
+			t.Errorf("NewRangeReader(%q, %d, %d): got %q want %q", tc.key, tc.offset, tc.length, got, tc.want)
+		}
+		if want := int64(len(testFiles[tc.key])); r.Size() != want {
+			t.Errorf("NewRangeReader(%q, %d, %d): got size %d want %d", tc.key, tc.offset, tc.length, r.Size(), want)
+		}
+	}
+
+	attrs, err := b.Attributes(ctx, "dir/b c.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.Size != 10 {
+		t.Errorf("got Size %d want 10", attrs.Size)
+	}
+	if attrs.ContentType != "text/plain; charset=utf-8" {
+		t.Errorf("got ContentType %q want text/plain", attrs.ContentType)
+	}
+	if attrs.ModTime.IsZero() {
+		t.Error("got zero ModTime")
+	}
+
+	if _, err := b.NewReader(ctx, "missing"); !blob.IsNotExist(err) {
+		t.Errorf("NewReader: got %v want IsNotExist error", err)
+	}
+	if _, err := b.Attributes(ctx, "missing"); !blob.IsNotExist(err) {
+		t.Errorf("Attributes: got %v want IsNotExist error", err)
+	}
+
+	url, err := b.SignedURL(ctx, "dir/b c.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := ts.URL + "/data/dir/b%20c.txt"; url != want {
+		t.Errorf("SignedURL: got %q want %q", url, want)
+	}
+}
+
+func TestRangeIgnored(t *testing.T) {
+	ctx := context.Background()
+	// This server ignores Range headers and always returns the whole blob.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "0123456789")
+	}))
+	defer ts.Close()
+	b, err := OpenBucket(ctx, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := b.NewRangeReader(ctx, "k", 3, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "3456" {
+		t.Errorf("got %q want %q", got, "3456")
+	}
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	ts, done := newServer(t)
+	defer done()
+
+	list := func(b *blob.Bucket, opts *blob.ListOptions) ([]string, error) {
+		var got []string
+		iter := b.List(opts)
+		for {
+			obj, err := iter.Next(ctx)
+			if err == io.EOF {
+				return got, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			got = append(got, obj.Key)
+		}
+	}
+
+	b, err := blob.Open(ctx, ts.URL+"/data/?index=index.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		opts *blob.ListOptions
+		want []string
+	}{
+		{want: []string{"a.txt", "dir/b c.txt", "dir/sub/d.txt"}},
+		{opts: &blob.ListOptions{Prefix: "dir/"}, want: []string{"dir/b c.txt", "dir/sub/d.txt"}},
+		{opts: &blob.ListOptions{Delimiter: "/"}, want: []string{"a.txt", "dir/"}},
+		{opts: &blob.ListOptions{Prefix: "dir/", Delimiter: "/"}, want: []string{"dir/b c.txt", "dir/sub/"}},
+	}
+	for _, tc := range tests {
+		got, err := list(b, tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, tc.want); diff != "" {
+			t.Errorf("List(%+v): got %v want %v", tc.opts, got, tc.want)
+		}
+	}
+
+	noIndex, err := OpenBucket(ctx, ts.URL+"/data/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := list(noIndex, nil); !blob.IsNotImplemented(err) {
+		t.Errorf("List without index: got %v want IsNotImplemented error", err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	ctx := context.Background()
+	ts, done := newServer(t)
+	defer done()
+	b, err := OpenBucket(ctx, ts.URL+"/data/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteAll(ctx, "a.txt", []byte("hello"), nil); !blob.IsNotImplemented(err) {
+		t.Errorf("WriteAll: got %v want IsNotImplemented error", err)
+	}
+	if err := b.Delete(ctx, "a.txt"); !blob.IsNotImplemented(err) {
+		t.Errorf("Delete: got %v want IsNotImplemented error", err)
+	}
+}
+
+func TestAs(t *testing.T) {
+	ctx := context.Background()
+	ts, done := newServer(t)
+	defer done()
+	b, err := OpenBucket(ctx, ts.URL+"/data/", &Options{Client: ts.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var client *http.Client
+	if !b.As(&client) || client != ts.Client() {
+		t.Error("Bucket.As failed")
+	}
+	_, err = b.Attributes(ctx, "missing")
+	var serr *StatusError
+	if !blob.ErrorAs(err, &serr) || serr.StatusCode != http.StatusNotFound {
+		t.Errorf("ErrorAs: got %v want *StatusError with 404", err)
+	}
+	r, err := b.NewReader(ctx, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var resp http.Response
+	if !r.As(&resp) || resp.StatusCode != http.StatusOK {
+		t.Error("Reader.As failed")
+	}
+}
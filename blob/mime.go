@@ -0,0 +1,88 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+var (
+	// mimeTypes maps lowercase extensions, including the leading dot, to MIME
+	// types. It takes precedence over the mime package's table, which
+	// depends on the system's configuration, so that common web types are
+	// the same everywhere.
+	mimeTypes = map[string]string{
+		".css":  "text/css; charset=utf-8",
+		".htm":  "text/html; charset=utf-8",
+		".html": "text/html; charset=utf-8",
+		".js":   "application/javascript",
+		".json": "application/json",
+		".mjs":  "application/javascript",
+		".svg":  "image/svg+xml",
+		".wasm": "application/wasm",
+	}
+	// mimeTypesMu protects mimeTypes.
+	mimeTypesMu sync.RWMutex
+)
+
+// RegisterMIMEType sets the MIME type for keys with the extension ext, such
+// as ".webmanifest", overriding any existing type for ext. The extension is
+// matched case-insensitively and must begin with a dot.
+func RegisterMIMEType(ext, typ string) error {
+	if !strings.HasPrefix(ext, ".") {
+		return fmt.Errorf("blob.RegisterMIMEType: extension %q must begin with a dot", ext)
+	}
+	t, p, err := mime.ParseMediaType(typ)
+	if err != nil {
+		return fmt.Errorf("blob.RegisterMIMEType: %v", err)
+	}
+	mimeTypesMu.Lock()
+	defer mimeTypesMu.Unlock()
+	mimeTypes[strings.ToLower(ext)] = mime.FormatMediaType(t, p)
+	return nil
+}
+
+// TypeByExtension returns the MIME type for the extension of key, looking
+// first at the types set by RegisterMIMEType and then at the mime package's
+// table. It returns "" if key has no extension or the extension is unknown.
+func TypeByExtension(key string) string {
+	ext := strings.ToLower(path.Ext(key))
+	if ext == "" {
+		return ""
+	}
+	mimeTypesMu.RLock()
+	t, ok := mimeTypes[ext]
+	mimeTypesMu.RUnlock()
+	if ok {
+		return t
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// DetectContentType returns the MIME type of an object with the given key
+// whose content begins with p. It uses TypeByExtension, and if the extension
+// is unknown, infers the type from at most the first 512 bytes of p using the
+// algorithm described at http://mimesniff.spec.whatwg.org/.
+func DetectContentType(key string, p []byte) string {
+	if t := TypeByExtension(key); t != "" {
+		return t
+	}
+	return http.DetectContentType(p)
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cloud/blob/driver"
+)
+
+// typeRecorder implements driver.Bucket. Only NewTypedWriter is implemented,
+// recording the content type of each key written.
+type typeRecorder struct {
+	driver.Bucket
+	types map[string]string
+}
+
+func (b *typeRecorder) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	b.types[key] = contentType
+	return discardWriter{}, nil
+}
+
+type discardWriter struct{}
+
+func (discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (discardWriter) Close() error                { return nil }
+
+func TestDetectContentType(t *testing.T) {
+	if err := RegisterMIMEType(".webmanifest", "application/manifest+json"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterMIMEType("txt", "text/plain"); err == nil {
+		t.Error("got nil error registering an extension without a dot, want error")
+	}
+	if err := RegisterMIMEType(".bad", "not a type;"); err == nil {
+		t.Error("got nil error registering an invalid type, want error")
+	}
+
+	const html = "<html><body>hello</body></html>"
+	tests := []struct {
+		key, content, want string
+	}{
+		{"style.css", "body {}", "text/css; charset=utf-8"},
+		{"dir/app.JS", "var x;", "application/javascript"},
+		{"logo.svg", "<svg></svg>", "image/svg+xml"},
+		{"site.webmanifest", "{}", "application/manifest+json"},
+		{"page", html, "text/html; charset=utf-8"},
+		{"notes.unknownext", "hello", "text/plain; charset=utf-8"},
+		{"dir.css/file", "\x00\x01", "application/octet-stream"},
+	}
+	ctx := context.Background()
+	rec := &typeRecorder{types: map[string]string{}}
+	b := NewBucket(rec)
+	for _, test := range tests {
+		if got := DetectContentType(test.key, []byte(test.content)); got != test.want {
+			t.Errorf("DetectContentType(%q): got %q want %q", test.key, got, test.want)
+		}
+		if err := b.WriteAll(ctx, test.key, []byte(test.content), nil); err != nil {
+			t.Fatal(err)
+		}
+		if got := rec.types[test.key]; got != test.want {
+			t.Errorf("Writer for %q: got content type %q want %q", test.key, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/go-cloud/blob/driver"
+	"github.com/google/go-cloud/internal/retry"
+	gax "github.com/googleapis/gax-go"
+)
+
+// RetryPolicy controls how a Bucket retries operations that fail with
+// errors that the provider reports as transient.
+//
+// Only idempotent operations are retried: Attributes, listing, opening a
+// Reader, and Delete. A Reader whose reads fail part of the way through
+// reopens the object at the current offset, unless the object has been
+// modified. Writes are never retried.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts for each operation,
+	// including the first. If zero, operations are retried until Timeout
+	// expires or their context is done.
+	MaxAttempts int
+	// Timeout, if positive, is the deadline for each operation, including
+	// its retries. For NewReader and NewRangeReader it applies to opening
+	// the Reader, not to reading from it, and for List to fetching each page of
+	// results.
+	Timeout time.Duration
+	// InitialBackoff is the maximum pause before the first retry; pauses are
+	// chosen randomly up to the current maximum. If zero, 1 second is used.
+	InitialBackoff time.Duration
+	// MaxBackoff is the limit on the maximum pause. If zero, 30 seconds is
+	// used.
+	MaxBackoff time.Duration
+	// Multiplier is the factor by which the maximum pause grows after each
+	// retry. If less than 1, 2 is used.
+	Multiplier float64
+}
+
+// WithRetryPolicy returns a Bucket for the same objects as b that retries
+// operations according to p. If p is nil, operations aren't retried, which is
+// the default.
+func (b *Bucket) WithRetryPolicy(p *RetryPolicy) *Bucket {
+	return &Bucket{b: b.b, retry: p}
+}
+
+// call calls f, retrying it according to p if it fails with a retryable
+// error. p may be nil.
+func (p *RetryPolicy) call(ctx context.Context, b driver.Bucket, f func() error) error {
+	if p == nil {
+		return f()
+	}
+	bo := gax.Backoff{
+		Initial:    p.InitialBackoff,
+		Max:        p.MaxBackoff,
+		Multiplier: p.Multiplier,
+	}
+	attempts := 0
+	isRetryable := func(err error) bool {
+		attempts++
+		return (p.MaxAttempts <= 0 || attempts < p.MaxAttempts) && b.IsRetryable(err)
+	}
+	return retry.Call(ctx, bo, isRetryable, f)
+}
+
+// withTimeout returns a context that expires after p.Timeout. p may be nil.
+func (p *RetryPolicy) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p == nil || p.Timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, p.Timeout)
+}
+
+// openReader opens a driver.Reader according to b.retry. The reader uses a
+// context that is canceled if opening it exceeds the policy's Timeout; the
+// caller must call the returned function when done with the reader.
+func (b *Bucket) openReader(ctx context.Context, key string, offset, length int64) (driver.Reader, func(), error) {
+	cancel := func() {}
+	var timer *time.Timer
+	if b.retry != nil && b.retry.Timeout > 0 {
+		// The reader's context must outlive the Timeout, so use a timer
+		// rather than context.WithTimeout.
+		var c context.CancelFunc
+		ctx, c = context.WithCancel(ctx)
+		cancel = c
+		timer = time.AfterFunc(b.retry.Timeout, cancel)
+	}
+	var r driver.Reader
+	err := b.retry.call(ctx, b.b, func() error {
+		var err error
+		r, err = b.b.NewRangeReader(ctx, key, offset, length)
+		return err
+	})
+	if err == nil && timer != nil && !timer.Stop() {
+		// The timer fired, canceling the reader's context.
+		r.Close()
+		err = context.DeadlineExceeded
+	}
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return r, cancel, nil
+}
+
+// resumer reopens a Reader after a retryable read error.
+type resumer struct {
+	ctx            context.Context
+	bucket         *Bucket
+	key            string
+	offset, length int64
+	// read is the number of bytes read so far.
+	read int64
+	// failures is the number of reopens since the last successful read.
+	failures int
+}
+
+// reopen replaces r.r with a reader for the rest of the range.
+func (rs *resumer) reopen(r *Reader) error {
+	rs.failures++
+	if p := rs.bucket.retry; p.MaxAttempts > 0 && rs.failures >= p.MaxAttempts {
+		return errors.New("too many failed attempts to resume reading")
+	}
+	r.r.Close()
+	r.cancel()
+	length := rs.length
+	if length >= 0 {
+		length -= rs.read
+	}
+	dr, cancel, err := rs.bucket.openReader(rs.ctx, rs.key, rs.offset+rs.read, length)
+	if err != nil {
+		// Leave a reader that fails, so that further reads and Close don't
+		// use the closed one.
+		r.r, r.cancel = errReader{err: err, attrs: r.attrs}, func() {}
+		return err
+	}
+	r.r, r.cancel = dr, cancel
+	if mt := dr.Attributes().ModTime; !mt.Equal(r.attrs.ModTime) {
+		err := fmt.Errorf("%q was modified while it was being read", rs.key)
+		dr.Close()
+		cancel()
+		r.r, r.cancel = errReader{err: err, attrs: r.attrs}, func() {}
+		return err
+	}
+	return nil
+}
+
+// errReader is a driver.Reader that always fails with err.
+type errReader struct {
+	err   error
+	attrs driver.ReaderAttributes
+}
+
+func (r errReader) Read([]byte) (int, error)            { return 0, r.err }
+func (r errReader) Close() error                        { return nil }
+func (r errReader) Attributes() driver.ReaderAttributes { return r.attrs }
+func (r errReader) As(interface{}) bool                 { return false }
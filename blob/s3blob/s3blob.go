@@ -19,8 +19,13 @@
 // The AWS session is created as described in
 // https://docs.aws.amazon.com/sdk-for-go/api/aws/session/.
 // The following query options are supported:
-// - region: The AWS region for requests; sets aws.Config.Region.
+// - region: The AWS region for requests; sets Options.Region.
+// - endpoint: The endpoint URL for requests; sets Options.Endpoint.
+// - disableSSL: A boolean; sets Options.DisableSSL.
+// - s3ForcePathStyle: A boolean; sets Options.S3ForcePathStyle.
 // Example URL: blob.Open("s3://mybucket?region=us-east-1")
+// Example URL for an S3-compatible service such as MinIO:
+// blob.Open("s3://mybucket?endpoint=minio.example.com:9000&disableSSL=true&s3ForcePathStyle=true")
 //
 // s3blob exposes the following types for As:
 // Bucket: *s3.S3
@@ -30,6 +35,19 @@
 // Reader: s3.GetObjectOutput
 // Attributes: s3.HeadObjectOutput
 // WriterOptions.BeforeWrite: *s3manager.UploadInput
+//
+// blob.Bucket.CreateBucket creates the bucket in CreateBucketOptions.Location,
+// or in the session's region if it's empty. S3 has no per-bucket default
+// storage class, so CreateBucketOptions.StorageClass is ignored.
+//
+// blob.IsNotExist returns true for errors caused by the bucket not existing,
+// as well as the object.
+//
+// WriterOptions.StorageClass maps to the S3 storage classes STANDARD,
+// STANDARD_IA and GLACIER. In Attributes, REDUCED_REDUNDANCY is reported as
+// blob.StorageClassStandard and ONEZONE_IA as blob.StorageClassInfrequent.
+// S3 doesn't report the class of STANDARD objects, so their
+// Attributes.StorageClass is empty.
 package s3blob
 
 import (
@@ -50,6 +68,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
@@ -59,32 +78,75 @@ const defaultPageSize = 1000
 
 func init() {
 	blob.Register("s3", func(ctx context.Context, u *url.URL) (driver.Bucket, error) {
-		q := u.Query()
-		cfg := &aws.Config{}
-
-		if region := q["region"]; len(region) > 0 {
-			cfg.Region = aws.String(region[0])
+		opts := &Options{}
+		for k, v := range u.Query() {
+			if len(v) == 0 {
+				continue
+			}
+			var err error
+			switch strings.ToLower(k) {
+			case "region":
+				opts.Region = v[0]
+			case "endpoint":
+				opts.Endpoint = v[0]
+			case "disablessl":
+				opts.DisableSSL, err = strconv.ParseBool(v[0])
+			case "s3forcepathstyle":
+				opts.S3ForcePathStyle, err = strconv.ParseBool(v[0])
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q for query option %q: %v", v[0], k, err)
+			}
 		}
-		sess, err := session.NewSession(cfg)
+		sess, err := session.NewSession()
 		if err != nil {
 			return nil, err
 		}
-		return openBucket(ctx, u.Host, sess, nil)
+		return openBucket(ctx, u.Host, sess, opts)
 	})
 }
 
-// Options sets options for constructing a *blob.Bucket backed by fileblob.
-type Options struct{}
+// Options sets options for constructing a *blob.Bucket backed by S3.
+// The zero value of each field uses the configuration of the session passed
+// to OpenBucket.
+type Options struct {
+	// Region is the AWS region to send requests to.
+	Region string
+	// Endpoint is the URL or host of the S3 service. Set it to use an
+	// S3-compatible service, such as MinIO, instead of AWS.
+	Endpoint string
+	// DisableSSL causes requests to be made over HTTP instead of HTTPS.
+	DisableSSL bool
+	// S3ForcePathStyle forces path-style addressing, where the bucket name is
+	// part of the URL path ("endpoint/bucket/key") instead of the host
+	// ("bucket.endpoint/key"). Most S3-compatible services require it.
+	S3ForcePathStyle bool
+}
 
 // openBucket returns an S3 Bucket.
-func openBucket(ctx context.Context, bucketName string, sess client.ConfigProvider, _ *Options) (driver.Bucket, error) {
+func openBucket(ctx context.Context, bucketName string, sess client.ConfigProvider, opts *Options) (driver.Bucket, error) {
 	if sess == nil {
 		return nil, errors.New("sess must be provided to get bucket")
 	}
+	if opts == nil {
+		opts = &Options{}
+	}
+	cfg := &aws.Config{}
+	if opts.Region != "" {
+		cfg.Region = aws.String(opts.Region)
+	}
+	if opts.Endpoint != "" {
+		cfg.Endpoint = aws.String(opts.Endpoint)
+	}
+	if opts.DisableSSL {
+		cfg.DisableSSL = aws.Bool(true)
+	}
+	if opts.S3ForcePathStyle {
+		cfg.S3ForcePathStyle = aws.Bool(true)
+	}
 	return &bucket{
 		name:   bucketName,
-		sess:   sess,
-		client: s3.New(sess),
+		client: s3.New(sess, cfg),
 	}, nil
 }
 
@@ -200,21 +262,27 @@ func (w *writer) touch() {
 // bucket represents an S3 bucket and handles read, write and delete operations.
 type bucket struct {
 	name   string
-	sess   client.ConfigProvider
 	client *s3.S3
 }
 
 // IsNotExist implements driver.IsNotExist.
 func (b *bucket) IsNotExist(err error) bool {
-	if e, ok := err.(awserr.Error); ok && (e.Code() == "NoSuchKey" || e.Code() == "NotFound") {
+	if e, ok := err.(awserr.Error); ok && (e.Code() == "NoSuchKey" || e.Code() == "NotFound" || e.Code() == s3.ErrCodeNoSuchBucket) {
 		return true
 	}
 	return false
 }
 
+var errNotImplemented = errors.New("not implemented")
+
 // IsNotImplemented implements driver.IsNotImplemented.
 func (b *bucket) IsNotImplemented(err error) bool {
-	return false
+	return err == errNotImplemented
+}
+
+// IsRetryable implements driver.IsRetryable.
+func (b *bucket) IsRetryable(err error) bool {
+	return request.IsErrorRetryable(err) || request.IsErrorThrottle(err)
 }
 
 // ListPaged implements driver.ListPaged.
@@ -340,10 +408,12 @@ func (b *bucket) Attributes(ctx context.Context, key string) (driver.Attributes,
 		}
 	}
 	return driver.Attributes{
-		ContentType: aws.StringValue(resp.ContentType),
-		Metadata:    md,
-		ModTime:     aws.TimeValue(resp.LastModified),
-		Size:        aws.Int64Value(resp.ContentLength),
+		ContentType:  aws.StringValue(resp.ContentType),
+		Metadata:     md,
+		ModTime:      aws.TimeValue(resp.LastModified),
+		Size:         aws.Int64Value(resp.ContentLength),
+		ETag:         aws.StringValue(resp.ETag),
+		StorageClass: fromS3StorageClass(resp.StorageClass),
 		AsFunc: func(i interface{}) bool {
 			p, ok := i.(*s3.HeadObjectOutput)
 			if !ok {
@@ -398,9 +468,42 @@ func getSize(resp *s3.GetObjectOutput) int64 {
 	return size
 }
 
+// toS3StorageClass returns the S3 storage class for sc, or "" for the
+// bucket's default.
+func toS3StorageClass(sc driver.StorageClass) string {
+	switch sc {
+	case driver.StorageClassStandard:
+		return s3.StorageClassStandard
+	case driver.StorageClassInfrequent:
+		return s3.StorageClassStandardIa
+	case driver.StorageClassArchive:
+		return s3.ObjectStorageClassGlacier
+	}
+	return ""
+}
+
+// fromS3StorageClass returns the storage class for an S3 storage class.
+// HeadObject omits the storage class of STANDARD objects, so an empty class
+// is reported as StorageClassDefault rather than guessed.
+func fromS3StorageClass(sc *string) driver.StorageClass {
+	switch aws.StringValue(sc) {
+	case s3.StorageClassStandard, s3.StorageClassReducedRedundancy:
+		return driver.StorageClassStandard
+	case s3.StorageClassStandardIa, s3.StorageClassOnezoneIa:
+		return driver.StorageClassInfrequent
+	case s3.ObjectStorageClassGlacier, "DEEP_ARCHIVE":
+		return driver.StorageClassArchive
+	}
+	return driver.StorageClassDefault
+}
+
 // NewTypedWriter implements driver.NewTypedWriter.
 func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
-	uploader := s3manager.NewUploader(b.sess, func(u *s3manager.Uploader) {
+	// S3 has no conditional writes.
+	if opts.IfNotExist {
+		return nil, errNotImplemented
+	}
+	uploader := s3manager.NewUploaderWithClient(b.client, func(u *s3manager.Uploader) {
 		if opts.BufferSize != 0 {
 			u.PartSize = int64(opts.BufferSize)
 		}
@@ -421,6 +524,9 @@ func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType str
 	if len(opts.ContentMD5) > 0 {
 		req.ContentMD5 = aws.String(base64.StdEncoding.EncodeToString(opts.ContentMD5))
 	}
+	if sc := toS3StorageClass(opts.StorageClass); sc != "" {
+		req.StorageClass = aws.String(sc)
+	}
 	if opts.BeforeWrite != nil {
 		asFunc := func(i interface{}) bool {
 			p, ok := i.(**s3manager.UploadInput)
@@ -455,6 +561,142 @@ func (b *bucket) Delete(ctx context.Context, key string) error {
 	return req.Send()
 }
 
+const (
+	// minPartSize is the smallest part S3 accepts in a multipart upload,
+	// other than the last part.
+	minPartSize = 5 * 1024 * 1024
+	// maxCopyPartSize is the largest object UploadPartCopy can copy as a
+	// single part.
+	maxCopyPartSize = 5 * 1024 * 1024 * 1024
+	// maxParts is the maximum number of parts in a multipart upload.
+	maxParts = 10000
+)
+
+// Compose implements driver.Compose.
+// Each source is copied as a part of a multipart upload, so sources that
+// can't be parts (all but the last must be at least 5 MiB) are not
+// implemented.
+func (b *bucket) Compose(ctx context.Context, dst string, srcs []string, opts *driver.ComposeOptions) error {
+	if len(srcs) > maxParts {
+		return errNotImplemented
+	}
+	for i, src := range srcs {
+		attrs, err := b.Attributes(ctx, src)
+		if err != nil {
+			return err
+		}
+		if attrs.Size > maxCopyPartSize || (i < len(srcs)-1 && attrs.Size < minPartSize) {
+			return errNotImplemented
+		}
+	}
+	var metadata map[string]*string
+	if len(opts.Metadata) > 0 {
+		metadata = make(map[string]*string, len(opts.Metadata))
+		for k, v := range opts.Metadata {
+			metadata[k] = aws.String(v)
+		}
+	}
+	createReq, createResp := b.client.CreateMultipartUploadRequest(&s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(b.name),
+		Key:         aws.String(dst),
+		ContentType: aws.String(opts.ContentType),
+		Metadata:    metadata,
+	})
+	createReq.SetContext(ctx)
+	if err := createReq.Send(); err != nil {
+		return err
+	}
+	parts := make([]*s3.CompletedPart, len(srcs))
+	for i, src := range srcs {
+		partReq, partResp := b.client.UploadPartCopyRequest(&s3.UploadPartCopyInput{
+			Bucket:     aws.String(b.name),
+			Key:        aws.String(dst),
+			UploadId:   createResp.UploadId,
+			PartNumber: aws.Int64(int64(i + 1)),
+			CopySource: aws.String(copySource(b.name, src)),
+		})
+		partReq.SetContext(ctx)
+		if err := partReq.Send(); err != nil {
+			b.abortMultipartUpload(dst, createResp.UploadId)
+			return err
+		}
+		parts[i] = &s3.CompletedPart{
+			ETag:       partResp.CopyPartResult.ETag,
+			PartNumber: aws.Int64(int64(i + 1)),
+		}
+	}
+	completeReq, _ := b.client.CompleteMultipartUploadRequest(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(b.name),
+		Key:             aws.String(dst),
+		UploadId:        createResp.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	completeReq.SetContext(ctx)
+	if err := completeReq.Send(); err != nil {
+		b.abortMultipartUpload(dst, createResp.UploadId)
+		return err
+	}
+	return nil
+}
+
+// abortMultipartUpload discards the parts of a failed multipart upload.
+// It uses a fresh context, since the upload often fails because ctx was
+// canceled.
+func (b *bucket) abortMultipartUpload(key string, uploadID *string) {
+	req, _ := b.client.AbortMultipartUploadRequest(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(b.name),
+		Key:      aws.String(key),
+		UploadId: uploadID,
+	})
+	req.Send()
+}
+
+// copySource returns the URL-encoded "bucket/key" form that S3 expects in the
+// x-amz-copy-source header.
+func copySource(bucket, key string) string {
+	segs := strings.Split(bucket+"/"+key, "/")
+	for i, s := range segs {
+		segs[i] = url.PathEscape(s)
+	}
+	return strings.Join(segs, "/")
+}
+
+// CreateBucket implements driver.CreateBucket.
+func (b *bucket) CreateBucket(ctx context.Context, opts *driver.CreateBucketOptions) error {
+	in := &s3.CreateBucketInput{Bucket: aws.String(b.name)}
+	location := opts.Location
+	if location == "" {
+		location = aws.StringValue(b.client.Config.Region)
+	}
+	// Buckets in us-east-1 must be created without a location constraint.
+	if location != "" && location != "us-east-1" {
+		in.CreateBucketConfiguration = &s3.CreateBucketConfiguration{LocationConstraint: aws.String(location)}
+	}
+	req, _ := b.client.CreateBucketRequest(in)
+	req.SetContext(ctx)
+	return req.Send()
+}
+
+// DeleteBucket implements driver.DeleteBucket.
+func (b *bucket) DeleteBucket(ctx context.Context) error {
+	req, _ := b.client.DeleteBucketRequest(&s3.DeleteBucketInput{Bucket: aws.String(b.name)})
+	req.SetContext(ctx)
+	return req.Send()
+}
+
+// BucketExists implements driver.BucketExists.
+func (b *bucket) BucketExists(ctx context.Context) (bool, error) {
+	req, _ := b.client.HeadBucketRequest(&s3.HeadBucketInput{Bucket: aws.String(b.name)})
+	req.SetContext(ctx)
+	if err := req.Send(); err != nil {
+		if b.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
 func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
 	in := &s3.GetObjectInput{
 		Bucket: aws.String(b.name),
@@ -463,3 +705,62 @@ func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedU
 	req, _ := b.client.GetObjectRequest(in)
 	return req.Presign(opts.Expiry)
 }
+
+// LifecycleRules implements driver.LifecycleRules.
+func (b *bucket) LifecycleRules(ctx context.Context) ([]*driver.LifecycleRule, error) {
+	in := &s3.GetBucketLifecycleConfigurationInput{Bucket: aws.String(b.name)}
+	req, resp := b.client.GetBucketLifecycleConfigurationRequest(in)
+	req.SetContext(ctx)
+	if err := req.Send(); err != nil {
+		if e, ok := err.(awserr.Error); ok && e.Code() == "NoSuchLifecycleConfiguration" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var rules []*driver.LifecycleRule
+	for _, r := range resp.Rules {
+		if aws.StringValue(r.Status) != s3.ExpirationStatusEnabled || r.Expiration == nil || aws.Int64Value(r.Expiration.Days) <= 0 {
+			continue
+		}
+		prefix := aws.StringValue(r.Prefix)
+		if r.Filter != nil {
+			if r.Filter.And != nil || r.Filter.Tag != nil {
+				// Rules that filter on tags can't be represented.
+				continue
+			}
+			prefix = aws.StringValue(r.Filter.Prefix)
+		}
+		rules = append(rules, &driver.LifecycleRule{
+			Prefix:          prefix,
+			DeleteAfterDays: int(aws.Int64Value(r.Expiration.Days)),
+		})
+	}
+	return rules, nil
+}
+
+// SetLifecycleRules implements driver.SetLifecycleRules.
+func (b *bucket) SetLifecycleRules(ctx context.Context, rules []*driver.LifecycleRule) error {
+	if len(rules) == 0 {
+		// S3 doesn't accept an empty lifecycle configuration.
+		in := &s3.DeleteBucketLifecycleInput{Bucket: aws.String(b.name)}
+		req, _ := b.client.DeleteBucketLifecycleRequest(in)
+		req.SetContext(ctx)
+		return req.Send()
+	}
+	cfg := &s3.BucketLifecycleConfiguration{}
+	for i, r := range rules {
+		cfg.Rules = append(cfg.Rules, &s3.LifecycleRule{
+			ID:         aws.String(fmt.Sprintf("go-cloud-%d", i)),
+			Status:     aws.String(s3.ExpirationStatusEnabled),
+			Filter:     &s3.LifecycleRuleFilter{Prefix: aws.String(r.Prefix)},
+			Expiration: &s3.LifecycleExpiration{Days: aws.Int64(int64(r.DeleteAfterDays))},
+		})
+	}
+	in := &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(b.name),
+		LifecycleConfiguration: cfg,
+	}
+	req, _ := b.client.PutBucketLifecycleConfigurationRequest(in)
+	req.SetContext(ctx)
+	return req.Send()
+}
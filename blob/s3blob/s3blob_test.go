@@ -15,14 +15,26 @@
 package s3blob
 
 import (
+	"bytes"
 	"context"
+	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
@@ -30,6 +42,7 @@ import (
 	"github.com/google/go-cloud/blob/driver"
 	"github.com/google/go-cloud/blob/drivertest"
 	"github.com/google/go-cloud/internal/testing/setup"
+	"github.com/google/go-cmp/cmp"
 )
 
 // These constants record the region & bucket used for the last --record.
@@ -145,3 +158,620 @@ func (verifyContentLanguage) ListObjectCheck(o *blob.ListObject) error {
 	// Nothing to check.
 	return nil
 }
+
+// fakeS3 is a minimal in-process S3-compatible server. It supports
+// path-style PutObject, GetObject, HeadObject, DeleteObject, multipart
+// uploads (including UploadPartCopy), CreateBucket, HeadBucket and
+// DeleteBucket, and getting, putting and deleting lifecycle configurations,
+// and records the URLs of the requests it receives.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]fakeObject
+	buckets map[string]string // bucket name to location
+	// lifecycles maps bucket names to their lifecycle configuration XML.
+	lifecycles map[string][]byte
+	uploads    map[string]*fakeObject
+	parts      map[string][]byte
+	requests   []string
+}
+
+type fakeObject struct {
+	contentType string
+	metadata    http.Header
+	content     []byte
+}
+
+// metadataHeaders returns the x-amz-meta- and storage class headers of h.
+func metadataHeaders(h http.Header) http.Header {
+	md := http.Header{}
+	for k, v := range h {
+		if strings.HasPrefix(k, "X-Amz-Meta-") || k == "X-Amz-Storage-Class" {
+			md[k] = v
+		}
+	}
+	return md
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	q := r.URL.Query()
+	if bucket := strings.Trim(r.URL.Path, "/"); !strings.Contains(bucket, "/") {
+		s.serveBucket(w, r, bucket)
+		return
+	}
+	// Buckets are only tracked once one is created; after that, requests for
+	// objects in other buckets fail like they do in S3.
+	if _, ok := s.buckets[strings.SplitN(r.URL.Path, "/", 3)[1]]; s.buckets != nil && !ok {
+		w.WriteHeader(http.StatusNotFound)
+		if r.Method != "HEAD" {
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchBucket</Code></Error>`)
+		}
+		return
+	}
+	switch {
+	case r.Method == "POST" && q.Get("uploadId") == "":
+		// CreateMultipartUpload.
+		id := strconv.Itoa(len(s.uploads) + 1)
+		if s.uploads == nil {
+			s.uploads = map[string]*fakeObject{}
+			s.parts = map[string][]byte{}
+		}
+		s.uploads[id] = &fakeObject{contentType: r.Header.Get("Content-Type"), metadata: metadataHeaders(r.Header)}
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, id)
+	case r.Method == "PUT" && q.Get("uploadId") != "" && r.Header.Get("X-Amz-Copy-Source") == "":
+		// UploadPart.
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.parts[q.Get("uploadId")+"/"+q.Get("partNumber")] = b
+		w.Header().Set("ETag", `"`+q.Get("partNumber")+`"`)
+	case r.Method == "PUT" && q.Get("uploadId") != "":
+		// UploadPartCopy.
+		src, err := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		obj, ok := s.objects["/"+src]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code></Error>`)
+			return
+		}
+		s.parts[q.Get("uploadId")+"/"+q.Get("partNumber")] = obj.content
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><CopyPartResult><ETag>"%s"</ETag></CopyPartResult>`, q.Get("partNumber"))
+	case r.Method == "POST":
+		// CompleteMultipartUpload.
+		var req struct {
+			Parts []struct {
+				PartNumber int
+			} `xml:"Part"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id := q.Get("uploadId")
+		obj := s.uploads[id]
+		for _, p := range req.Parts {
+			obj.content = append(obj.content, s.parts[id+"/"+strconv.Itoa(p.PartNumber)]...)
+		}
+		s.objects[r.URL.Path] = *obj
+		delete(s.uploads, id)
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><CompleteMultipartUploadResult></CompleteMultipartUploadResult>`)
+	case r.Method == "PUT":
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.objects[r.URL.Path] = fakeObject{contentType: r.Header.Get("Content-Type"), metadata: metadataHeaders(r.Header), content: b}
+	case r.Method == "GET" || r.Method == "HEAD":
+		obj, ok := s.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == "GET" {
+				fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code></Error>`)
+			}
+			return
+		}
+		for k, v := range obj.metadata {
+			w.Header()[k] = v
+		}
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.content)))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		if r.Method == "GET" {
+			w.Write(obj.content)
+		}
+	case r.Method == "DELETE" && q.Get("uploadId") != "":
+		// AbortMultipartUpload.
+		delete(s.uploads, q.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "DELETE":
+		delete(s.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unsupported", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveBucket handles the bucket-level requests of fakeS3.
+func (s *fakeS3) serveBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	writeError := func(status int, code string) {
+		w.WriteHeader(status)
+		if r.Method != "HEAD" {
+			fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code></Error>`, code)
+		}
+	}
+	_, exists := s.buckets[bucket]
+	if _, ok := r.URL.Query()["lifecycle"]; ok {
+		s.serveLifecycle(w, r, bucket)
+		return
+	}
+	switch r.Method {
+	case "PUT":
+		if exists {
+			writeError(http.StatusConflict, "BucketAlreadyOwnedByYou")
+			return
+		}
+		var cfg struct {
+			LocationConstraint string
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&cfg); err != nil && err != io.EOF {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if s.buckets == nil {
+			s.buckets = map[string]string{}
+		}
+		s.buckets[bucket] = cfg.LocationConstraint
+	case "HEAD":
+		if !exists {
+			writeError(http.StatusNotFound, "NotFound")
+		}
+	case "DELETE":
+		if !exists {
+			writeError(http.StatusNotFound, "NoSuchBucket")
+			return
+		}
+		for path := range s.objects {
+			if strings.HasPrefix(path, "/"+bucket+"/") {
+				writeError(http.StatusConflict, "BucketNotEmpty")
+				return
+			}
+		}
+		delete(s.buckets, bucket)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unsupported", http.StatusMethodNotAllowed)
+	}
+}
+
+// fakeS3Server serves a fakeS3 over HTTP.
+type fakeS3Server struct {
+	*fakeS3
+	ts *httptest.Server
+	// host is the endpoint of the server, without a scheme.
+	host string
+}
+
+func newFakeS3Server() *fakeS3Server {
+	srv := &fakeS3{objects: map[string]fakeObject{}}
+	ts := httptest.NewServer(srv)
+	return &fakeS3Server{fakeS3: srv, ts: ts, host: strings.TrimPrefix(ts.URL, "http://")}
+}
+
+// openBucket opens the bucket name on s, using a session in region.
+func (s *fakeS3Server) openBucket(ctx context.Context, name, region string) (*blob.Bucket, error) {
+	drv, err := s.openDriver(ctx, name, region)
+	if err != nil {
+		return nil, err
+	}
+	return blob.NewBucket(drv), nil
+}
+
+// openDriver is like openBucket, but returns the driver.Bucket.
+func (s *fakeS3Server) openDriver(ctx context.Context, name, region string) (driver.Bucket, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("FAKE_ID", "FAKE_SECRET", ""),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return openBucket(ctx, name, sess, &Options{
+		Region:           region,
+		Endpoint:         s.host,
+		DisableSSL:       true,
+		S3ForcePathStyle: true,
+	})
+}
+
+// fakeHarness runs drivertest tests against a fakeS3Server.
+type fakeHarness struct {
+	srv *fakeS3Server
+}
+
+func newFakeHarness(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+	return &fakeHarness{srv: newFakeS3Server()}, nil
+}
+
+func (h *fakeHarness) HTTPClient() *http.Client {
+	return nil
+}
+
+func (h *fakeHarness) MakeDriver(ctx context.Context) (driver.Bucket, error) {
+	return h.srv.openDriver(ctx, "bucket", "us-east-1")
+}
+
+func (h *fakeHarness) Close() {
+	h.srv.Close()
+}
+
+func (s *fakeS3Server) Close() {
+	s.ts.Close()
+}
+
+// serveLifecycle handles the lifecycle configuration requests of fakeS3.
+func (s *fakeS3) serveLifecycle(w http.ResponseWriter, r *http.Request, bucket string) {
+	switch r.Method {
+	case "GET":
+		lc, ok := s.lifecycles[bucket]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchLifecycleConfiguration</Code></Error>`)
+			return
+		}
+		w.Write(lc)
+	case "PUT":
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if s.lifecycles == nil {
+			s.lifecycles = map[string][]byte{}
+		}
+		s.lifecycles[bucket] = b
+	case "DELETE":
+		delete(s.lifecycles, bucket)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unsupported", http.StatusMethodNotAllowed)
+	}
+}
+
+func TestS3CompatibleEndpoint(t *testing.T) {
+	ctx := context.Background()
+	srv := newFakeS3Server()
+	defer srv.Close()
+
+	// Provide fake credentials for blob.Open.
+	for k, v := range map[string]string{"AWS_ACCESS_KEY_ID": "FAKE_ID", "AWS_SECRET_ACCESS_KEY": "FAKE_SECRET"} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Setenv(k, v)
+	}
+
+	tests := []struct {
+		name string
+		open func() (*blob.Bucket, error)
+	}{
+		{
+			name: "OpenBucket",
+			open: func() (*blob.Bucket, error) {
+				return srv.openBucket(ctx, "mybucket", "us-east-1")
+			},
+		},
+		{
+			name: "URL",
+			open: func() (*blob.Bucket, error) {
+				return blob.Open(ctx, "s3://mybucket?region=us-east-1&endpoint="+srv.host+"&disableSSL=true&s3ForcePathStyle=true")
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv.requests = nil
+			b, err := tc.open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			const key = "dir/hello.txt"
+			content := []byte("hello world")
+			if err := b.WriteAll(ctx, key, content, &blob.WriterOptions{ContentType: "text/plain"}); err != nil {
+				t.Fatal(err)
+			}
+			got, err := b.ReadAll(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("got %q want %q", got, content)
+			}
+			a, err := b.Attributes(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.ContentType != "text/plain" {
+				t.Errorf("got ContentType %q want %q", a.ContentType, "text/plain")
+			}
+			if err := b.Delete(ctx, key); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := b.NewReader(ctx, key); !blob.IsNotExist(err) {
+				t.Errorf("got err %v reading deleted blob, want IsNotExist", err)
+			}
+			want := []string{
+				"PUT /mybucket/dir/hello.txt",
+				"GET /mybucket/dir/hello.txt",
+				"HEAD /mybucket/dir/hello.txt",
+				"HEAD /mybucket/dir/hello.txt",
+				"DELETE /mybucket/dir/hello.txt",
+				"GET /mybucket/dir/hello.txt",
+			}
+			if diff := cmp.Diff(srv.requests, want); diff != "" {
+				t.Errorf("got requests\n%v\nwant\n%v\ndiff\n%s", srv.requests, want, diff)
+			}
+		})
+	}
+
+	t.Run("InvalidBool", func(t *testing.T) {
+		if _, err := blob.Open(ctx, "s3://mybucket?disableSSL=maybe"); err == nil {
+			t.Error("got nil error, want error for invalid disableSSL")
+		}
+	})
+}
+
+func TestCompose(t *testing.T) {
+	ctx := context.Background()
+	srv := newFakeS3Server()
+	defer srv.Close()
+	b, err := srv.openBucket(ctx, "mybucket", "us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := bytes.Repeat([]byte("a"), minPartSize)
+	small := []byte("small")
+	if err := b.WriteAll(ctx, "dir/large", large, &blob.WriterOptions{ContentType: "text/plain"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteAll(ctx, "small", small, &blob.WriterOptions{ContentType: "text/plain"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		description string
+		srcs        []string
+		want        []byte
+		// wantCopy is true if the parts should be copied server-side.
+		wantCopy bool
+	}{
+		{"large parts are copied", []string{"dir/large", "small"}, append(append([]byte{}, large...), small...), true},
+		{"small parts are streamed", []string{"small", "dir/large"}, append(append([]byte{}, small...), large...), false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			srv.requests = nil
+			opts := &blob.ComposeOptions{Metadata: map[string]string{"Foo": "bar"}}
+			if err := b.Compose(ctx, "dst", tc.srcs, opts); err != nil {
+				t.Fatal(err)
+			}
+			// Streaming reads the sources through the client.
+			gotCopy := true
+			for _, r := range srv.requests {
+				if strings.HasPrefix(r, "GET ") {
+					gotCopy = false
+				}
+			}
+			if gotCopy != tc.wantCopy {
+				t.Errorf("got server-side copy %v want %v; requests %v", gotCopy, tc.wantCopy, srv.requests)
+			}
+			got, err := b.ReadAll(ctx, "dst")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tc.want) {
+				t.Errorf("got %d bytes, want %d bytes", len(got), len(tc.want))
+			}
+			a, err := b.Attributes(ctx, "dst")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.ContentType != "text/plain" {
+				t.Errorf("got ContentType %q want %q", a.ContentType, "text/plain")
+			}
+			if diff := cmp.Diff(a.Metadata, map[string]string{"foo": "bar"}); diff != "" {
+				t.Errorf("got metadata diff %s", diff)
+			}
+		})
+	}
+
+	if err := b.Compose(ctx, "dst", []string{"missing", "small"}, nil); !blob.IsNotExist(err) {
+		t.Errorf("got err %v for missing source, want IsNotExist", err)
+	}
+}
+
+func TestBucketAdmin(t *testing.T) {
+	ctx := context.Background()
+	srv := newFakeS3Server()
+	defer srv.Close()
+	open := func(name, region string) *blob.Bucket {
+		b, err := srv.openBucket(ctx, name, region)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	b := open("newbucket", "us-west-2")
+	exists, err := b.BucketExists(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("got BucketExists true before CreateBucket, want false")
+	}
+	if err := b.CreateBucket(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.CreateBucket(ctx, nil); err == nil {
+		t.Error("got nil error creating an existing bucket, want error")
+	}
+	exists, err = b.BucketExists(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Error("got BucketExists false after CreateBucket, want true")
+	}
+	if err := b.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.DeleteBucket(ctx); err == nil {
+		t.Error("got nil error deleting a non-empty bucket, want error")
+	}
+	if err := b.Delete(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.DeleteBucket(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.DeleteBucket(ctx); !blob.IsNotExist(err) {
+		t.Errorf("got err %v deleting a deleted bucket, want IsNotExist", err)
+	}
+	// Reading from a missing bucket reports IsNotExist, too.
+	_, err = b.NewReader(ctx, "key")
+	var awsErr awserr.Error
+	if !blob.IsNotExist(err) || !blob.ErrorAs(err, &awsErr) || awsErr.Code() != s3.ErrCodeNoSuchBucket {
+		t.Errorf("got err %v reading from a deleted bucket, want IsNotExist with code %s", err, s3.ErrCodeNoSuchBucket)
+	}
+
+	// The location defaults to the session's region, except that us-east-1
+	// buckets have no location constraint.
+	if err := open("east", "us-east-1").CreateBucket(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := open("eu", "us-east-1").CreateBucket(ctx, &blob.CreateBucketOptions{Location: "eu-west-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := open("west", "us-west-2").CreateBucket(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"east": "", "eu": "eu-west-1", "west": "us-west-2"}
+	if diff := cmp.Diff(srv.buckets, want); diff != "" {
+		t.Errorf("got bucket locations %v diff %s", srv.buckets, diff)
+	}
+}
+
+func TestStorageClass(t *testing.T) {
+	ctx := context.Background()
+	srv := newFakeS3Server()
+	defer srv.Close()
+	b, err := srv.openBucket(ctx, "bucket", "us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		class   blob.StorageClass
+		s3Class string
+		want    blob.StorageClass
+	}{
+		{"", "", ""},
+		{blob.StorageClassStandard, "STANDARD", blob.StorageClassStandard},
+		{blob.StorageClassInfrequent, "STANDARD_IA", blob.StorageClassInfrequent},
+		{blob.StorageClassArchive, "GLACIER", blob.StorageClassArchive},
+	}
+	for _, test := range tests {
+		key := "key-" + string(test.class)
+		opts := &blob.WriterOptions{ContentType: "text/plain", StorageClass: test.class}
+		if err := b.WriteAll(ctx, key, []byte("hello"), opts); err != nil {
+			t.Fatal(err)
+		}
+		if got := srv.objects["/bucket/"+key].metadata.Get("X-Amz-Storage-Class"); got != test.s3Class {
+			t.Errorf("%q: got S3 storage class %q want %q", test.class, got, test.s3Class)
+		}
+		attrs, err := b.Attributes(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.StorageClass != test.want {
+			t.Errorf("%q: got Attributes.StorageClass %q want %q", test.class, attrs.StorageClass, test.want)
+		}
+	}
+}
+
+func TestLifecycleConformance(t *testing.T) {
+	drivertest.RunLifecycleTests(t, newFakeHarness)
+}
+
+func TestLifecycle(t *testing.T) {
+	ctx := context.Background()
+	srv := newFakeS3Server()
+	defer srv.Close()
+	b, err := srv.openBucket(ctx, "bucket", "us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := b.LifecycleRules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 0 {
+		t.Errorf("got %d rules for a new bucket, want 0", len(rules))
+	}
+	want := []*blob.LifecycleRule{{DeleteAfterDays: 30}, {Prefix: "tmp/", DeleteAfterDays: 1}}
+	if err := b.SetLifecycleRules(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+	var cfg s3.BucketLifecycleConfiguration
+	if err := xml.Unmarshal(srv.lifecycles["bucket"], &struct {
+		Rules *[]*s3.LifecycleRule `xml:"Rule"`
+	}{&cfg.Rules}); err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Rules) != len(want) {
+		t.Fatalf("got %d S3 rules, want %d", len(cfg.Rules), len(want))
+	}
+	for i, r := range cfg.Rules {
+		if aws.StringValue(r.Status) != "Enabled" || aws.StringValue(r.Filter.Prefix) != want[i].Prefix || aws.Int64Value(r.Expiration.Days) != int64(want[i].DeleteAfterDays) {
+			t.Errorf("got S3 rule %v, want an enabled rule for %+v", r, want[i])
+		}
+	}
+	rules, err = b.LifecycleRules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(rules, want); diff != "" {
+		t.Errorf("got rules diff %s", diff)
+	}
+
+	// Legacy prefixes are supported; rules that are disabled, filter on tags
+	// or don't expire objects are left out.
+	srv.lifecycles["bucket"] = []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<LifecycleConfiguration>
+  <Rule><ID>legacy</ID><Prefix>logs/</Prefix><Status>Enabled</Status><Expiration><Days>7</Days></Expiration></Rule>
+  <Rule><ID>disabled</ID><Filter><Prefix></Prefix></Filter><Status>Disabled</Status><Expiration><Days>1</Days></Expiration></Rule>
+  <Rule><ID>tag</ID><Filter><Tag><Key>k</Key><Value>v</Value></Tag></Filter><Status>Enabled</Status><Expiration><Days>1</Days></Expiration></Rule>
+  <Rule><ID>transition</ID><Filter><Prefix></Prefix></Filter><Status>Enabled</Status><Transition><Days>30</Days><StorageClass>GLACIER</StorageClass></Transition></Rule>
+</LifecycleConfiguration>`)
+	rules, err = b.LifecycleRules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(rules, []*blob.LifecycleRule{{Prefix: "logs/", DeleteAfterDays: 7}}); diff != "" {
+		t.Errorf("got rules diff %s", diff)
+	}
+
+	if err := b.SetLifecycleRules(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := srv.lifecycles["bucket"]; ok {
+		t.Error("got a lifecycle configuration after clearing the rules, want none")
+	}
+}
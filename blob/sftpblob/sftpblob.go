@@ -0,0 +1,661 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sftpblob provides a bucket implementation that operates on a
+// directory of a remote server using SFTP.
+//
+// Blob keys are escaped before being used as remote file names, using the
+// same algorithm as fileblob, except that "/" always maps to "/".
+// Content type and metadata are stored in a "<file>.attrs" sidecar file next
+// to each blob, and files with that extension are not visible as blobs.
+// Writes go to a temporary file that is renamed into place on Close, using
+// the posix-rename@openssh.com extension so that existing blobs are replaced
+// atomically; the server must support it (OpenSSH does).
+//
+// For blob.Open URLs, sftpblob registers for the "sftp" scheme.
+// The URL's Host is the server address; port 22 is used if none is given.
+// The URL's User is the login name, and its password, if any, is used for
+// password authentication. The URL's Path is used as the root directory.
+// The following query options are supported:
+//   - key_path: Sets path to a private key file used for public key
+//     authentication.
+//   - known_hosts: Sets path to the known_hosts file used to verify the
+//     server's host key. Defaults to $HOME/.ssh/known_hosts.
+//
+// Example URL: blob.Open("sftp://partner@sftp.example.com/outgoing?key_path=/keys/id_ed25519")
+//
+// Buckets opened for URLs that differ only in their Path share one
+// connection, which stays open for the life of the process and is redialed
+// if it fails. To control the lifetime of the connection, use OpenBucket
+// with your own *sftp.Client instead.
+//
+// It exposes the following types for As:
+// Bucket: *sftp.Client
+// Error: *sftp.StatusError
+package sftpblob
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/go-cloud/blob"
+	"github.com/google/go-cloud/blob/driver"
+	"github.com/google/go-cloud/internal/escape"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const defaultPageSize = 1000
+
+func init() {
+	blob.Register("sftp", func(ctx context.Context, u *url.URL) (driver.Bucket, error) {
+		client, err := urlClients.get(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		return openBucket(client, u.Path, nil)
+	})
+}
+
+// urlClients holds the connections opened for blob.Open URLs.
+var urlClients = &clientCache{conns: map[string]*conn{}}
+
+// clientCache shares connections between the buckets opened for URLs that
+// differ only in their Path.
+type clientCache struct {
+	mu    sync.Mutex
+	conns map[string]*conn
+}
+
+// conn is an SFTP session and the SSH connection it runs on.
+type conn struct {
+	client    *sftp.Client
+	sshClient *ssh.Client
+}
+
+func (c *conn) close() {
+	c.client.Close()
+	c.sshClient.Close()
+}
+
+// get returns a working client for the server, user and options in u,
+// dialing a new one if there isn't one.
+func (c *clientCache) get(ctx context.Context, u *url.URL) (*sftp.Client, error) {
+	k := *u
+	k.Path, k.RawPath, k.Fragment = "", "", ""
+	key := k.String()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cn := c.conns[key]; cn != nil {
+		// Check that the connection still works.
+		if _, err := cn.client.Getwd(); err == nil {
+			return cn.client, nil
+		}
+		cn.close()
+		delete(c.conns, key)
+	}
+	cn, err := dial(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	c.conns[key] = cn
+	return cn.client, nil
+}
+
+// dial connects to the SFTP server described by u.
+func dial(ctx context.Context, u *url.URL) (*conn, error) {
+	q := u.Query()
+	if u.User == nil || u.User.Username() == "" {
+		return nil, errors.New("sftpblob: URL must include a user name")
+	}
+	var auth []ssh.AuthMethod
+	if pw, ok := u.User.Password(); ok {
+		auth = append(auth, ssh.Password(pw))
+	}
+	if keyPath := q.Get("key_path"); keyPath != "" {
+		pem, err := ioutil.ReadFile(keyPath)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("sftpblob: URL must include a password or the key_path query option")
+	}
+	knownHosts := q.Get("known_hosts")
+	if knownHosts == "" {
+		knownHosts = filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHosts)
+	if err != nil {
+		return nil, err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	var d net.Dialer
+	tcpConn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(tcpConn, addr, &ssh.ClientConfig{
+		User:            u.User.Username(),
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		tcpConn.Close()
+		return nil, err
+	}
+	sshClient := ssh.NewClient(c, chans, reqs)
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, err
+	}
+	return &conn{client: client, sshClient: sshClient}, nil
+}
+
+// Options sets options for constructing a *blob.Bucket backed by sftpblob.
+type Options struct{}
+
+type bucket struct {
+	client *sftp.Client
+	dir    string
+}
+
+// openBucket creates a driver.Bucket that reads and writes to dir on the
+// server that client is connected to. dir must exist.
+func openBucket(client *sftp.Client, dir string, _ *Options) (driver.Bucket, error) {
+	if client == nil {
+		return nil, errors.New("sftpblob.OpenBucket: client is required")
+	}
+	dir = path.Clean(dir)
+	info, err := client.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return &bucket{client: client, dir: dir}, nil
+}
+
+// OpenBucket creates a *blob.Bucket that reads and writes to dir on the
+// server that client is connected to. dir must exist.
+// The caller is responsible for closing client when done with the bucket.
+func OpenBucket(client *sftp.Client, dir string, opts *Options) (*blob.Bucket, error) {
+	drv, err := openBucket(client, dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	return blob.NewBucket(drv), nil
+}
+
+// IsNotExist implements driver.IsNotExist.
+func (b *bucket) IsNotExist(err error) bool {
+	return os.IsNotExist(err)
+}
+
+var errNotImplemented = errors.New("not implemented")
+
+// IsNotImplemented implements driver.IsNotImplemented.
+func (b *bucket) IsNotImplemented(err error) bool {
+	return err == errNotImplemented
+}
+
+// IsRetryable implements driver.IsRetryable.
+// Errors from a broken SSH connection persist until a new *sftp.Client is
+// created, so no errors are retryable.
+func (b *bucket) IsRetryable(err error) bool {
+	return false
+}
+
+// As implements driver.As.
+func (b *bucket) As(i interface{}) bool {
+	p, ok := i.(**sftp.Client)
+	if !ok {
+		return false
+	}
+	*p = b.client
+	return true
+}
+
+// ErrorAs implements driver.ErrorAs.
+func (b *bucket) ErrorAs(err error, i interface{}) bool {
+	e, ok := err.(*sftp.StatusError)
+	if !ok {
+		return false
+	}
+	p, ok := i.(**sftp.StatusError)
+	if !ok {
+		return false
+	}
+	*p = e
+	return true
+}
+
+// pathForKey returns the remote path for key.
+func (b *bucket) pathForKey(key string) (string, error) {
+	p := path.Join(b.dir, escape.FileName(key, '/'))
+	if strings.HasSuffix(p, attrsExt) {
+		return "", errAttrsExt
+	}
+	return p, nil
+}
+
+// forKey returns the remote path, os.FileInfo, and attributes for key.
+func (b *bucket) forKey(key string) (string, os.FileInfo, *xattrs, error) {
+	p, err := b.pathForKey(key)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	info, err := b.client.Stat(p)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if info.IsDir() {
+		return "", nil, nil, os.ErrNotExist
+	}
+	xa, err := b.getAttrs(p)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return p, info, &xa, nil
+}
+
+// ListPaged implements driver.ListPaged.
+func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	if opts.BeforeList != nil {
+		if err := opts.BeforeList(func(interface{}) bool { return false }); err != nil {
+			return nil, err
+		}
+	}
+	pageSize := opts.PageSize
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+	pageToken := string(opts.PageToken)
+
+	// Walk the remote directory, collecting all of the blobs that match the
+	// prefix. The remote walk order follows escaped file names, so sort the
+	// unescaped keys afterwards.
+	var objs []*driver.ListObject
+	walker := b.client.Walk(b.dir)
+	for walker.Step() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if walker.Err() != nil {
+			// Couldn't read this file/directory for some reason; just skip it.
+			continue
+		}
+		p := walker.Path()
+		if p == b.dir || strings.HasSuffix(p, attrsExt) {
+			continue
+		}
+		// Strip the <b.dir> prefix from p; +1 is to include the separator.
+		key, err := escape.Key(p[len(b.dir)+1:], '/')
+		if err != nil {
+			continue
+		}
+		info := walker.Stat()
+		if info.IsDir() {
+			// Avoid recursing into subdirectories that can't contain any
+			// matching keys.
+			key += "/"
+			if len(key) > len(opts.Prefix) && !strings.HasPrefix(key, opts.Prefix) {
+				walker.SkipDir()
+			}
+			continue
+		}
+		if !strings.HasPrefix(key, opts.Prefix) {
+			continue
+		}
+		objs = append(objs, &driver.ListObject{
+			Key:     key,
+			ModTime: info.ModTime(),
+			Size:    info.Size(),
+		})
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].Key < objs[j].Key })
+
+	var result driver.ListPage
+	// If opts.Delimiter != "", lastPrefix contains the last "directory" key we
+	// added. It is used to avoid adding it again; all files in this "directory"
+	// are collapsed to the single directory entry.
+	var lastPrefix string
+	for _, obj := range objs {
+		// If using Delimiter, collapse "directories".
+		if opts.Delimiter != "" {
+			keyWithoutPrefix := obj.Key[len(opts.Prefix):]
+			if idx := strings.Index(keyWithoutPrefix, opts.Delimiter); idx != -1 {
+				prefix := opts.Prefix + keyWithoutPrefix[0:idx+len(opts.Delimiter)]
+				if prefix == lastPrefix {
+					continue
+				}
+				obj = &driver.ListObject{Key: prefix, IsDir: true}
+				lastPrefix = prefix
+			}
+		}
+		// If there's a pageToken, skip anything before it.
+		if pageToken != "" && obj.Key <= pageToken {
+			continue
+		}
+		// If we've already got a full page of results, set NextPageToken and stop.
+		if len(result.Objects) == pageSize {
+			result.NextPageToken = []byte(result.Objects[pageSize-1].Key)
+			break
+		}
+		result.Objects = append(result.Objects, obj)
+	}
+	return &result, nil
+}
+
+// Attributes implements driver.Attributes.
+func (b *bucket) Attributes(ctx context.Context, key string) (driver.Attributes, error) {
+	_, info, xa, err := b.forKey(key)
+	if err != nil {
+		return driver.Attributes{}, err
+	}
+	return driver.Attributes{
+		ContentType: xa.ContentType,
+		Metadata:    xa.Metadata,
+		ModTime:     info.ModTime(),
+		Size:        info.Size(),
+	}, nil
+}
+
+// NewRangeReader implements driver.NewRangeReader.
+func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length int64) (driver.Reader, error) {
+	p, info, xa, err := b.forKey(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := b.client.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	r := io.Reader(f)
+	if length > 0 {
+		r = io.LimitReader(r, length)
+	}
+	return reader{
+		r: r,
+		c: f,
+		attrs: driver.ReaderAttributes{
+			ContentType: xa.ContentType,
+			ModTime:     info.ModTime(),
+			Size:        info.Size(),
+		},
+	}, nil
+}
+
+type reader struct {
+	r     io.Reader
+	c     io.Closer
+	attrs driver.ReaderAttributes
+}
+
+func (r reader) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}
+
+func (r reader) Close() error {
+	return r.c.Close()
+}
+
+func (r reader) Attributes() driver.ReaderAttributes {
+	return r.attrs
+}
+
+func (r reader) As(i interface{}) bool { return false }
+
+// NewTypedWriter implements driver.NewTypedWriter.
+func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	// Whether SSH_FXP_RENAME replaces an existing file depends on the server,
+	// so there's no reliable way to create a file only if it doesn't exist.
+	if opts.IfNotExist {
+		return nil, errNotImplemented
+	}
+	p, err := b.pathForKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.client.MkdirAll(path.Dir(p)); err != nil {
+		return nil, err
+	}
+	// The temporary file name contains an invalid escape sequence, so it
+	// isn't visible as a blob while the write is in progress.
+	var suffix [8]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return nil, err
+	}
+	tmp := p + "%tmp" + hex.EncodeToString(suffix[:])
+	f, err := b.client.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	if opts.BeforeWrite != nil {
+		if err := opts.BeforeWrite(func(interface{}) bool { return false }); err != nil {
+			f.Close()
+			b.client.Remove(tmp)
+			return nil, err
+		}
+	}
+	var metadata map[string]string
+	if len(opts.Metadata) > 0 {
+		metadata = opts.Metadata
+	}
+	w := &writer{
+		ctx:    ctx,
+		client: b.client,
+		f:      f,
+		tmp:    tmp,
+		path:   p,
+		attrs: xattrs{
+			ContentType: contentType,
+			Metadata:    metadata,
+		},
+	}
+	if len(opts.ContentMD5) > 0 {
+		w.contentMD5 = opts.ContentMD5
+		w.md5hash = md5.New()
+	}
+	return w, nil
+}
+
+type writer struct {
+	ctx        context.Context
+	client     *sftp.Client
+	f          *sftp.File
+	tmp        string
+	path       string
+	attrs      xattrs
+	contentMD5 []byte
+	md5hash    hash.Hash
+}
+
+func (w *writer) Write(p []byte) (n int, err error) {
+	if w.md5hash != nil {
+		if _, err := w.md5hash.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	return w.f.Write(p)
+}
+
+func (w *writer) Close() error {
+	err := w.f.Close()
+	if err != nil {
+		_ = w.client.Remove(w.tmp)
+		return err
+	}
+	// Always delete the temp file. On success, it will have been renamed so
+	// the Remove will fail.
+	defer func() {
+		_ = w.client.Remove(w.tmp)
+	}()
+
+	// Check if the write was cancelled.
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+
+	// Check MD5 hash if necessary.
+	if w.md5hash != nil {
+		md5sum := w.md5hash.Sum(nil)
+		if !bytes.Equal(md5sum, w.contentMD5) {
+			return fmt.Errorf(
+				"the ContentMD5 you specified did not match what we received (%s != %s)",
+				base64.StdEncoding.EncodeToString(md5sum),
+				base64.StdEncoding.EncodeToString(w.contentMD5),
+			)
+		}
+	}
+	// Write the attributes file.
+	if err := setAttrs(w.client, w.path, w.attrs); err != nil {
+		return err
+	}
+	// Rename the temp file to path, replacing any existing blob.
+	if err := w.client.PosixRename(w.tmp, w.path); err != nil {
+		_ = w.client.Remove(w.path + attrsExt)
+		return err
+	}
+	return nil
+}
+
+// Delete implements driver.Delete.
+func (b *bucket) Delete(ctx context.Context, key string) error {
+	p, err := b.pathForKey(key)
+	if err != nil {
+		return err
+	}
+	if err := b.client.Remove(p); err != nil {
+		return err
+	}
+	if err := b.client.Remove(p + attrsExt); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Compose implements driver.Compose.
+func (b *bucket) Compose(ctx context.Context, dst string, srcs []string, opts *driver.ComposeOptions) error {
+	// SFTP has no server-side copy; let the concrete type stream the sources.
+	return errNotImplemented
+}
+
+// SignedURL implements driver.SignedURL.
+func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
+	return "", errNotImplemented
+}
+
+// LifecycleRules implements driver.LifecycleRules.
+func (b *bucket) LifecycleRules(ctx context.Context) ([]*driver.LifecycleRule, error) {
+	return nil, errNotImplemented
+}
+
+// SetLifecycleRules implements driver.SetLifecycleRules.
+func (b *bucket) SetLifecycleRules(ctx context.Context, rules []*driver.LifecycleRule) error {
+	return errNotImplemented
+}
+
+// CreateBucket implements driver.CreateBucket.
+func (b *bucket) CreateBucket(ctx context.Context, opts *driver.CreateBucketOptions) error {
+	return errNotImplemented
+}
+
+// DeleteBucket implements driver.DeleteBucket.
+func (b *bucket) DeleteBucket(ctx context.Context) error {
+	return errNotImplemented
+}
+
+// BucketExists implements driver.BucketExists.
+func (b *bucket) BucketExists(ctx context.Context) (bool, error) {
+	return false, errNotImplemented
+}
+
+const attrsExt = ".attrs"
+
+var errAttrsExt = fmt.Errorf("file extension %q is reserved", attrsExt)
+
+// xattrs stores extended attributes for an object, in the same format as
+// fileblob.
+type xattrs struct {
+	ContentType string            `json:"user.content_type"`
+	Metadata    map[string]string `json:"user.metadata"`
+}
+
+// setAttrs creates a "p.attrs" file along with the blob to store the
+// attributes.
+func setAttrs(client *sftp.Client, p string, xa xattrs) error {
+	f, err := client.Create(p + attrsExt)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(xa); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// getAttrs reads the "p.attrs" file. It doesn't return an error when there is
+// no such file.
+func (b *bucket) getAttrs(p string) (xattrs, error) {
+	f, err := b.client.Open(p + attrsExt)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return xattrs{ContentType: "application/octet-stream"}, nil
+		}
+		return xattrs{}, err
+	}
+	var xa xattrs
+	if err := json.NewDecoder(f).Decode(&xa); err != nil {
+		f.Close()
+		return xattrs{}, err
+	}
+	return xa, f.Close()
+}
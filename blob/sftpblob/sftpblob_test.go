@@ -0,0 +1,341 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sftpblob
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-cloud/blob"
+	"github.com/google/go-cloud/blob/driver"
+	"github.com/google/go-cloud/blob/drivertest"
+	"github.com/google/go-cloud/internal/escape"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	testUser     = "gopher"
+	testPassword = "s3cret"
+)
+
+// server is an in-process SSH server that serves the "sftp" subsystem from
+// the local filesystem.
+type server struct {
+	l       net.Listener
+	hostKey ssh.PublicKey
+	wg      sync.WaitGroup
+	// accepted is the number of connections accepted.
+	accepted int32
+}
+
+func newServer(t *testing.T) *server {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == testUser && string(pass) == testPassword {
+				return nil, nil
+			}
+			return nil, errors.New("access denied")
+		},
+	}
+	config.AddHostKey(signer)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{l: l, hostKey: signer.PublicKey()}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&s.accepted, 1)
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.serveConn(conn, config)
+			}()
+		}
+	}()
+	return s
+}
+
+func (s *server) serveConn(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	sc, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	defer sc.Close()
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range reqs {
+				// The payload of a subsystem request is the length-prefixed
+				// subsystem name.
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+			}
+		}()
+		srv, err := sftp.NewServer(ch)
+		if err != nil {
+			ch.Close()
+			continue
+		}
+		srv.Serve()
+		srv.Close()
+	}
+}
+
+func (s *server) addr() string {
+	return s.l.Addr().String()
+}
+
+func (s *server) close() {
+	s.l.Close()
+}
+
+// dialTest returns an SFTP client connected to s.
+func (s *server) dialTest() (*sftp.Client, error) {
+	c, err := ssh.Dial("tcp", s.addr(), &ssh.ClientConfig{
+		User:            testUser,
+		Auth:            []ssh.AuthMethod{ssh.Password(testPassword)},
+		HostKeyCallback: ssh.FixedHostKey(s.hostKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+type harness struct {
+	srv     *server
+	dir     string
+	clients []*sftp.Client
+}
+
+func newHarness(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+	dir, err := ioutil.TempDir("", "go-cloud-sftpblob")
+	if err != nil {
+		return nil, err
+	}
+	return &harness{srv: newServer(t), dir: dir}, nil
+}
+
+func (h *harness) HTTPClient() *http.Client {
+	return nil
+}
+
+func (h *harness) MakeDriver(ctx context.Context) (driver.Bucket, error) {
+	client, err := h.srv.dialTest()
+	if err != nil {
+		return nil, err
+	}
+	h.clients = append(h.clients, client)
+	return openBucket(client, h.dir, nil)
+}
+
+func (h *harness) Close() {
+	for _, c := range h.clients {
+		c.Close()
+	}
+	h.srv.close()
+	os.RemoveAll(h.dir)
+}
+
+func TestConformance(t *testing.T) {
+	drivertest.RunConformanceTests(t, newHarness, []drivertest.AsTest{verifyAs{}})
+}
+
+func TestLifecycleConformance(t *testing.T) {
+	drivertest.RunLifecycleTests(t, newHarness)
+}
+
+type verifyAs struct{}
+
+func (verifyAs) Name() string { return "verify As types for sftpblob" }
+
+func (verifyAs) BucketCheck(b *blob.Bucket) error {
+	var client *sftp.Client
+	if !b.As(&client) {
+		return errors.New("Bucket.As failed")
+	}
+	return nil
+}
+
+func (verifyAs) ErrorCheck(err error) error {
+	// Not-found errors are normalized to os.ErrNotExist by the sftp package,
+	// so there's no *sftp.StatusError to check here.
+	return nil
+}
+
+func (verifyAs) BeforeWrite(as func(interface{}) bool) error  { return nil }
+func (verifyAs) BeforeList(as func(interface{}) bool) error   { return nil }
+func (verifyAs) AttributesCheck(attrs *blob.Attributes) error { return nil }
+func (verifyAs) ReaderCheck(r *blob.Reader) error             { return nil }
+func (verifyAs) ListObjectCheck(o *blob.ListObject) error     { return nil }
+
+func TestOpenURL(t *testing.T) {
+	ctx := context.Background()
+	srv := newServer(t)
+	defer srv.close()
+	dir, err := ioutil.TempDir("", "go-cloud-sftpblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	knownHosts := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(srv.addr())}, srv.hostKey)
+	if err := ioutil.WriteFile(knownHosts, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	bucketDir := filepath.Join(dir, "bucket")
+	if err := os.Mkdir(bucketDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	u := &url.URL{
+		Scheme:   "sftp",
+		User:     url.UserPassword(testUser, testPassword),
+		Host:     srv.addr(),
+		Path:     filepath.ToSlash(bucketDir),
+		RawQuery: url.Values{"known_hosts": {knownHosts}}.Encode(),
+	}
+
+	b, err := blob.Open(ctx, u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteAll(ctx, "dir/hello.txt", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(bucketDir, "dir", "hello.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("got %q want %q", got, "hello")
+	}
+
+	// Another bucket on the same server shares the connection, until it
+	// fails.
+	other := *u
+	other.Path = filepath.ToSlash(filepath.Join(bucketDir, "dir"))
+	b2, err := blob.Open(ctx, other.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&srv.accepted); n != 1 {
+		t.Errorf("got %d connections for two buckets, want 1", n)
+	}
+	var client *sftp.Client
+	if !b2.As(&client) {
+		t.Fatal("Bucket.As failed")
+	}
+	client.Close()
+	b3, err := blob.Open(ctx, other.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := b3.ReadAll(ctx, "hello.txt"); err != nil || string(got) != "hello" {
+		t.Errorf("after reconnecting, got %q, %v", got, err)
+	}
+	if n := atomic.LoadInt32(&srv.accepted); n != 2 {
+		t.Errorf("got %d connections after one failed, want 2", n)
+	}
+
+	for _, tc := range []struct {
+		name string
+		url  func() *url.URL
+	}{
+		{"wrong password", func() *url.URL {
+			v := *u
+			v.User = url.UserPassword(testUser, "wrong")
+			return &v
+		}},
+		{"no credentials", func() *url.URL {
+			v := *u
+			v.User = url.User(testUser)
+			return &v
+		}},
+		{"unknown host key", func() *url.URL {
+			v := *u
+			v.RawQuery = url.Values{"known_hosts": {os.DevNull}}.Encode()
+			return &v
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := blob.Open(ctx, tc.url().String()); err == nil {
+				t.Error("got nil error, want error")
+			}
+		})
+	}
+}
+
+func TestEscape(t *testing.T) {
+	for _, key := range []string{"a/b/c", "~!@#$%^&*()", "weird-keys\\x", "☺☺", "sp ace.txt"} {
+		escaped := escape.FileName(key, '/')
+		got, err := escape.Key(escaped, '/')
+		if err != nil {
+			t.Errorf("%q: %v", key, err)
+			continue
+		}
+		if got != key {
+			t.Errorf("%q: got unescaped %q", key, got)
+		}
+	}
+	// Temporary upload files must never be visible as keys.
+	if _, err := escape.Key(fmt.Sprintf("foo%%tmp%x", 1234), '/'); err == nil {
+		t.Error("temporary file name unescaped successfully")
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package escape converts blob keys to file names and back, for the
+// providers that store blobs as files (fileblob and sftpblob). The algorithm
+// is:
+// -- Alphanumeric characters (A-Z a-z 0-9) are not escaped.
+// -- Space (' '), dash ('-'), underscore ('_'), and period ('.') are not escaped.
+// -- Slash ('/') is always escaped to the path separator character passed in.
+// -- All other characters are escaped similar to url.PathEscape:
+//    "%<hex UTF-8 byte>", with capital letters ABCDEF in the hex code.
+package escape
+
+import "fmt"
+
+// shouldEscape returns true if c should be escaped.
+func shouldEscape(c byte) bool {
+	switch {
+	case 'A' <= c && c <= 'Z':
+		return false
+	case 'a' <= c && c <= 'z':
+		return false
+	case '0' <= c && c <= '9':
+		return false
+	case c == ' ' || c == '-' || c == '_' || c == '.':
+		return false
+	case c == '/':
+		return false
+	}
+	return true
+}
+
+// FileName returns the file name for key, using sep as the path separator.
+// The code is modified from https://golang.org/src/net/url/url.go.
+func FileName(key string, sep byte) string {
+	hexCount := 0
+	replaceSlash := false
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if shouldEscape(c) {
+			hexCount++
+		} else if c == '/' && sep != '/' {
+			replaceSlash = true
+		}
+	}
+	if hexCount == 0 && !replaceSlash {
+		return key
+	}
+	t := make([]byte, len(key)+2*hexCount)
+	j := 0
+	for i := 0; i < len(key); i++ {
+		switch c := key[i]; {
+		case c == '/':
+			t[j] = sep
+			j++
+		case shouldEscape(c):
+			t[j] = '%'
+			t[j+1] = "0123456789ABCDEF"[c>>4]
+			t[j+2] = "0123456789ABCDEF"[c&15]
+			j += 3
+		default:
+			t[j] = key[i]
+			j++
+		}
+	}
+	return string(t)
+}
+
+// ishex returns true if c is a valid part of a hexadecimal number.
+func ishex(c byte) bool {
+	switch {
+	case '0' <= c && c <= '9':
+		return true
+	case 'a' <= c && c <= 'f':
+		return true
+	case 'A' <= c && c <= 'F':
+		return true
+	}
+	return false
+}
+
+// unhex returns the hexadecimal value of the hexadecimal character c.
+// For example, unhex('A') returns 10.
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10
+	}
+	return 0
+}
+
+// Key returns the key for the file name name, using sep as the path
+// separator. It returns an error if name has invalid escape sequences, or if
+// FileName(Key(name)) != name; such files aren't visible as blobs.
+// The code is modified from https://golang.org/src/net/url/url.go.
+func Key(name string, sep byte) (string, error) {
+	// Count %, check that they're well-formed.
+	n := 0
+	replaceSep := false
+	for i := 0; i < len(name); {
+		switch name[i] {
+		case '%':
+			n++
+			if i+2 >= len(name) || !ishex(name[i+1]) || !ishex(name[i+2]) {
+				bad := name[i:]
+				if len(bad) > 3 {
+					bad = bad[:3]
+				}
+				return "", fmt.Errorf("couldn't unescape %q near %q", name, bad)
+			}
+			i += 3
+		case sep:
+			replaceSep = sep != '/'
+			i++
+		default:
+			i++
+		}
+	}
+	key := name
+	if n > 0 || replaceSep {
+		t := make([]byte, len(name)-2*n)
+		j := 0
+		for i := 0; i < len(name); {
+			switch name[i] {
+			case '%':
+				t[j] = unhex(name[i+1])<<4 | unhex(name[i+2])
+				j++
+				i += 3
+			case sep:
+				t[j] = '/'
+				j++
+				i++
+			default:
+				t[j] = name[i]
+				j++
+				i++
+			}
+		}
+		key = string(t)
+	}
+	if escaped := FileName(key, sep); escaped != name {
+		return "", fmt.Errorf("%q unescaped to %q but escaped back to %q instead of itself", name, key, escaped)
+	}
+	return key, nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package escape
+
+import (
+	"os"
+	"testing"
+)
+
+func TestEscape(t *testing.T) {
+	for _, tc := range []struct {
+		key, want string
+	}{
+		{
+			key:  "abc09ABC -_.",
+			want: "abc09ABC -_.",
+		},
+		{
+			key:  "~!@#$%^&*()+`=[]{}\\|;:'\",<>,",
+			want: "%7E%21%40%23%24%25%5E%26%2A%28%29%2B%60%3D%5B%5D%7B%7D%5C%7C%3B%3A%27%22%2C%3C%3E%2C",
+		},
+		{
+			key:  "/",
+			want: string(os.PathSeparator),
+		},
+		{
+			key:  "☺☺",
+			want: "%E2%98%BA%E2%98%BA",
+		},
+	} {
+		got := FileName(tc.key, os.PathSeparator)
+		if got != tc.want {
+			t.Errorf("%s: got escaped %q want %q", tc.key, got, tc.want)
+		}
+		var err error
+		got, err = Key(got, os.PathSeparator)
+		if err != nil {
+			t.Error(err)
+		}
+		if got != tc.key {
+			t.Errorf("%s: got unescaped %q want %q", tc.key, got, tc.key)
+		}
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	for _, tc := range []struct {
+		filename, want string
+		wantErr        bool
+	}{
+		{
+			filename: "%7E",
+			want:     "~",
+		},
+		{
+			filename: "abc%7Eabc",
+			want:     "abc~abc",
+		},
+		{
+			filename: "%7e",
+			wantErr:  true, // wrong case in hex
+		},
+		{
+			filename: "aa~bb",
+			wantErr:  true, // ~ should be escaped
+		},
+		{
+			filename: "abc%gabc",
+			wantErr:  true, // invalid hex after %
+		},
+	} {
+		got, err := Key(tc.filename, os.PathSeparator)
+		if tc.wantErr != (err != nil) {
+			t.Errorf("%s: got err %v want %v", tc.filename, err, tc.wantErr)
+		}
+		if got != tc.want {
+			t.Errorf("%s: got unescaped %q want %q", tc.filename, got, tc.want)
+		}
+	}
+}
+
+func TestSeparator(t *testing.T) {
+	const key = "a/b%c"
+	for _, sep := range []byte{'/', '\\'} {
+		want := "a" + string(sep) + "b%25c"
+		got := FileName(key, sep)
+		if got != want {
+			t.Errorf("%c: got escaped %q want %q", sep, got, want)
+		}
+		unescaped, err := Key(got, sep)
+		if err != nil {
+			t.Error(err)
+		}
+		if unescaped != key {
+			t.Errorf("%c: got unescaped %q want %q", sep, unescaped, key)
+		}
+	}
+}
@@ -0,0 +1,449 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package faultblob provides a driver.Bucket that wraps another one and
+// injects faults into its operations, for testing how code copes with an
+// unreliable provider: slow operations, errors, short reads, and reads or
+// writes that fail part of the way through.
+//
+// It is for tests only, and lives under internal so that it can't be used in
+// production code.
+//
+// Faults are matched against each operation by Op and key pattern. Injected
+// errors are of type *Error; blob.IsNotExist returns true for those with
+// Kind NotExist, and they are exposed via blob.ErrorAs.
+//
+// Example:
+//   b := faultblob.OpenBucket(drv, &faultblob.Options{
+//     Faults: []*faultblob.Fault{
+//       {Ops: []faultblob.Op{faultblob.OpRead}, KeyPattern: "logs/*", Kind: faultblob.Unavailable, Count: 2},
+//     },
+//   })
+//
+// It exposes the following types for As:
+// Error: *faultblob.Error
+// All other types are those of the wrapped driver.Bucket.
+package faultblob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/google/go-cloud/blob"
+	"github.com/google/go-cloud/blob/driver"
+)
+
+// Op is an operation that faults can be injected into.
+type Op string
+
+// The operations that faults can be injected into. The key of OpList is the
+// listing prefix, and the key of OpCompose is the destination.
+const (
+	OpAttributes Op = "Attributes"
+	OpList       Op = "List"
+	OpRead       Op = "Read"
+	OpWrite      Op = "Write"
+	OpDelete     Op = "Delete"
+	OpCompose    Op = "Compose"
+)
+
+// Kind is the kind of error injected by a Fault.
+type Kind int
+
+const (
+	// NoError injects no error; the Fault may still add latency or limit
+	// reads.
+	NoError Kind = iota
+	// NotExist reports that the object doesn't exist.
+	NotExist
+	// Unavailable is a transient failure, like an HTTP 503, that may
+	// succeed if retried.
+	Unavailable
+	// Permanent is a failure that won't succeed if retried, like an HTTP
+	// 403.
+	Permanent
+)
+
+func (k Kind) String() string {
+	switch k {
+	case NoError:
+		return "no error"
+	case NotExist:
+		return "not exist"
+	case Unavailable:
+		return "unavailable"
+	case Permanent:
+		return "permanent"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Fault describes faults to inject into matching operations.
+type Fault struct {
+	// Ops restricts the fault to the given operations. If empty, the fault
+	// applies to all of them.
+	Ops []Op
+	// KeyPattern restricts the fault to keys matching the pattern, using
+	// the syntax of path.Match. If empty, the fault applies to all keys.
+	KeyPattern string
+	// Rate is the probability that the fault applies to a matching
+	// operation. If zero, it always applies.
+	Rate float64
+	// Count is the number of times the fault applies; after that, it is
+	// ignored. If zero, there is no limit.
+	Count int
+
+	// Latency delays the operation, or until its context is done.
+	Latency time.Duration
+	// Kind is the kind of error to return from the operation.
+	Kind Kind
+	// FailAfter, if positive, moves the error for OpRead and OpWrite to after
+	// that many bytes have been read or written. For OpRead with Kind NoError,
+	// the content is truncated: reads return io.EOF early.
+	FailAfter int64
+	// ReadLimit, if positive, is the maximum number of bytes each Read call
+	// returns for OpRead.
+	ReadLimit int
+}
+
+func (f *Fault) matches(op Op, key string) bool {
+	if len(f.Ops) > 0 {
+		found := false
+		for _, o := range f.Ops {
+			if o == op {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.KeyPattern != "" {
+		if ok, _ := path.Match(f.KeyPattern, key); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Error is an error injected by a Fault.
+type Error struct {
+	Op   Op
+	Key  string
+	Kind Kind
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("faultblob: injected %v error for %s %q", e.Kind, e.Op, e.Key)
+}
+
+// Retryable returns true for transient errors.
+func (e *Error) Retryable() bool {
+	return e.Kind == Unavailable
+}
+
+// Options sets options for a Bucket.
+type Options struct {
+	// Faults are the faults to inject. The first Fault that matches an
+	// operation, and hasn't reached its Count, applies.
+	Faults []*Fault
+	// Seed seeds the random numbers used for Fault.Rate.
+	Seed int64
+}
+
+// Bucket is a driver.Bucket that injects faults into the operations of
+// another driver.Bucket.
+type Bucket struct {
+	b driver.Bucket
+
+	mu     sync.Mutex
+	faults []*Fault
+	counts []int
+	rand   *rand.Rand
+}
+
+// NewBucket returns a driver.Bucket that injects faults into the operations
+// of b.
+func NewBucket(b driver.Bucket, opts *Options) *Bucket {
+	if opts == nil {
+		opts = &Options{}
+	}
+	fb := &Bucket{b: b, rand: rand.New(rand.NewSource(opts.Seed))}
+	fb.SetFaults(opts.Faults)
+	return fb
+}
+
+// OpenBucket returns a *blob.Bucket that injects faults into the operations
+// of b.
+func OpenBucket(b driver.Bucket, opts *Options) *blob.Bucket {
+	return blob.NewBucket(NewBucket(b, opts))
+}
+
+// SetFaults replaces the faults to inject, and resets their counts.
+func (b *Bucket) SetFaults(faults []*Fault) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.faults = faults
+	b.counts = make([]int, len(faults))
+}
+
+// fault returns the fault that applies to op on key, or nil.
+func (b *Bucket) fault(op Op, key string) *Fault {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, f := range b.faults {
+		if !f.matches(op, key) || (f.Count > 0 && b.counts[i] >= f.Count) {
+			continue
+		}
+		if f.Rate > 0 && b.rand.Float64() >= f.Rate {
+			continue
+		}
+		b.counts[i]++
+		return f
+	}
+	return nil
+}
+
+// inject applies the latency of the fault for op on key, if any, and returns
+// it along with the error to return immediately.
+func (b *Bucket) inject(ctx context.Context, op Op, key string) (*Fault, error) {
+	f := b.fault(op, key)
+	if f == nil {
+		return nil, nil
+	}
+	if f.Latency > 0 {
+		t := time.NewTimer(f.Latency)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return f, ctx.Err()
+		}
+	}
+	if f.Kind != NoError && !((op == OpRead || op == OpWrite) && f.FailAfter > 0) {
+		return f, &Error{Op: op, Key: key, Kind: f.Kind}
+	}
+	return f, nil
+}
+
+// IsNotExist implements driver.IsNotExist.
+func (b *Bucket) IsNotExist(err error) bool {
+	if e, ok := err.(*Error); ok {
+		return e.Kind == NotExist
+	}
+	return b.b.IsNotExist(err)
+}
+
+// IsNotImplemented implements driver.IsNotImplemented.
+func (b *Bucket) IsNotImplemented(err error) bool {
+	if _, ok := err.(*Error); ok {
+		return false
+	}
+	return b.b.IsNotImplemented(err)
+}
+
+// IsRetryable implements driver.IsRetryable.
+func (b *Bucket) IsRetryable(err error) bool {
+	if e, ok := err.(*Error); ok {
+		return e.Retryable()
+	}
+	return b.b.IsRetryable(err)
+}
+
+// As implements driver.As.
+func (b *Bucket) As(i interface{}) bool {
+	return b.b.As(i)
+}
+
+// ErrorAs implements driver.ErrorAs.
+func (b *Bucket) ErrorAs(err error, i interface{}) bool {
+	if e, ok := err.(*Error); ok {
+		p, ok := i.(**Error)
+		if !ok {
+			return false
+		}
+		*p = e
+		return true
+	}
+	return b.b.ErrorAs(err, i)
+}
+
+// Attributes implements driver.Attributes.
+func (b *Bucket) Attributes(ctx context.Context, key string) (driver.Attributes, error) {
+	if _, err := b.inject(ctx, OpAttributes, key); err != nil {
+		return driver.Attributes{}, err
+	}
+	return b.b.Attributes(ctx, key)
+}
+
+// ListPaged implements driver.ListPaged.
+func (b *Bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	if _, err := b.inject(ctx, OpList, opts.Prefix); err != nil {
+		return nil, err
+	}
+	return b.b.ListPaged(ctx, opts)
+}
+
+// NewRangeReader implements driver.NewRangeReader.
+func (b *Bucket) NewRangeReader(ctx context.Context, key string, offset, length int64) (driver.Reader, error) {
+	f, err := b.inject(ctx, OpRead, key)
+	if err != nil {
+		return nil, err
+	}
+	r, err := b.b.NewRangeReader(ctx, key, offset, length)
+	if err != nil || f == nil || (f.FailAfter <= 0 && f.ReadLimit <= 0) {
+		return r, err
+	}
+	return &reader{Reader: r, f: f, key: key}, nil
+}
+
+// reader limits the size of reads, and fails after f.FailAfter bytes.
+type reader struct {
+	driver.Reader
+	f   *Fault
+	key string
+	n   int64
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.f.ReadLimit > 0 && len(p) > r.f.ReadLimit {
+		p = p[:r.f.ReadLimit]
+	}
+	if r.f.FailAfter > 0 {
+		left := r.f.FailAfter - r.n
+		if left <= 0 {
+			if r.f.Kind == NoError {
+				return 0, io.EOF
+			}
+			return 0, &Error{Op: OpRead, Key: r.key, Kind: r.f.Kind}
+		}
+		if int64(len(p)) > left {
+			p = p[:left]
+		}
+	}
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// NewTypedWriter implements driver.NewTypedWriter.
+func (b *Bucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	f, err := b.inject(ctx, OpWrite, key)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil || f.Kind == NoError || f.FailAfter <= 0 {
+		return b.b.NewTypedWriter(ctx, key, contentType, opts)
+	}
+	// Canceling the context aborts the underlying write, so that a failed
+	// write doesn't create the object.
+	ctx, cancel := context.WithCancel(ctx)
+	w, err := b.b.NewTypedWriter(ctx, key, contentType, opts)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &writer{Writer: w, cancel: cancel, f: f, key: key}, nil
+}
+
+// writer fails after f.FailAfter bytes.
+type writer struct {
+	driver.Writer
+	cancel func()
+	f      *Fault
+	key    string
+	n      int64
+	err    error
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	left := w.f.FailAfter - w.n
+	if int64(len(p)) >= left {
+		n, err := w.Writer.Write(p[:left])
+		w.n += int64(n)
+		if err != nil {
+			return n, err
+		}
+		w.err = &Error{Op: OpWrite, Key: w.key, Kind: w.f.Kind}
+		w.cancel()
+		return n, w.err
+	}
+	n, err := w.Writer.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *writer) Close() error {
+	defer w.cancel()
+	if w.err != nil {
+		w.Writer.Close()
+		return w.err
+	}
+	return w.Writer.Close()
+}
+
+// Delete implements driver.Delete.
+func (b *Bucket) Delete(ctx context.Context, key string) error {
+	if _, err := b.inject(ctx, OpDelete, key); err != nil {
+		return err
+	}
+	return b.b.Delete(ctx, key)
+}
+
+// Compose implements driver.Compose.
+func (b *Bucket) Compose(ctx context.Context, dst string, srcs []string, opts *driver.ComposeOptions) error {
+	if _, err := b.inject(ctx, OpCompose, dst); err != nil {
+		return err
+	}
+	return b.b.Compose(ctx, dst, srcs, opts)
+}
+
+// SignedURL implements driver.SignedURL.
+func (b *Bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
+	return b.b.SignedURL(ctx, key, opts)
+}
+
+// LifecycleRules implements driver.LifecycleRules.
+func (b *Bucket) LifecycleRules(ctx context.Context) ([]*driver.LifecycleRule, error) {
+	return b.b.LifecycleRules(ctx)
+}
+
+// SetLifecycleRules implements driver.SetLifecycleRules.
+func (b *Bucket) SetLifecycleRules(ctx context.Context, rules []*driver.LifecycleRule) error {
+	return b.b.SetLifecycleRules(ctx, rules)
+}
+
+// CreateBucket implements driver.CreateBucket.
+func (b *Bucket) CreateBucket(ctx context.Context, opts *driver.CreateBucketOptions) error {
+	return b.b.CreateBucket(ctx, opts)
+}
+
+// DeleteBucket implements driver.DeleteBucket.
+func (b *Bucket) DeleteBucket(ctx context.Context) error {
+	return b.b.DeleteBucket(ctx)
+}
+
+// BucketExists implements driver.BucketExists.
+func (b *Bucket) BucketExists(ctx context.Context) (bool, error) {
+	return b.b.BucketExists(ctx)
+}
@@ -0,0 +1,367 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package faultblob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cloud/blob"
+	"github.com/google/go-cloud/blob/driver"
+)
+
+var errMemNotFound = errors.New("not found")
+
+// memBucket is a minimal in-memory driver.Bucket to wrap.
+type memBucket struct {
+	driver.Bucket
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (b *memBucket) IsNotExist(err error) bool             { return err == errMemNotFound }
+func (b *memBucket) IsNotImplemented(err error) bool       { return false }
+func (b *memBucket) IsRetryable(err error) bool            { return false }
+func (b *memBucket) As(i interface{}) bool                 { return false }
+func (b *memBucket) ErrorAs(err error, i interface{}) bool { return false }
+
+func (b *memBucket) Attributes(ctx context.Context, key string) (driver.Attributes, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	content, ok := b.objects[key]
+	if !ok {
+		return driver.Attributes{}, errMemNotFound
+	}
+	return driver.Attributes{ContentType: "application/octet-stream", Size: int64(len(content))}, nil
+}
+
+func (b *memBucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var page driver.ListPage
+	for key, content := range b.objects {
+		if strings.HasPrefix(key, opts.Prefix) {
+			page.Objects = append(page.Objects, &driver.ListObject{Key: key, Size: int64(len(content))})
+		}
+	}
+	sort.Slice(page.Objects, func(i, j int) bool { return page.Objects[i].Key < page.Objects[j].Key })
+	return &page, nil
+}
+
+type memReader struct {
+	io.Reader
+	size int64
+}
+
+func (r *memReader) Close() error { return nil }
+func (r *memReader) Attributes() driver.ReaderAttributes {
+	return driver.ReaderAttributes{ContentType: "application/octet-stream", Size: r.size}
+}
+func (r *memReader) As(i interface{}) bool { return false }
+
+func (b *memBucket) NewRangeReader(ctx context.Context, key string, offset, length int64) (driver.Reader, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	content, ok := b.objects[key]
+	if !ok {
+		return nil, errMemNotFound
+	}
+	content = content[offset:]
+	if length >= 0 && length < int64(len(content)) {
+		content = content[:length]
+	}
+	return &memReader{Reader: bytes.NewReader(content), size: int64(len(content))}, nil
+}
+
+type memWriter struct {
+	ctx context.Context
+	b   *memBucket
+	key string
+	buf bytes.Buffer
+}
+
+func (w *memWriter) Write(p []byte) (int, error) { return w.buf.Write(p) }
+
+func (w *memWriter) Close() error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	w.b.mu.Lock()
+	defer w.b.mu.Unlock()
+	w.b.objects[w.key] = w.buf.Bytes()
+	return nil
+}
+
+func (b *memBucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	return &memWriter{ctx: ctx, b: b, key: key}, nil
+}
+
+func (b *memBucket) Delete(ctx context.Context, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.objects[key]; !ok {
+		return errMemNotFound
+	}
+	delete(b.objects, key)
+	return nil
+}
+
+func newBucket(faults ...*Fault) (*Bucket, *blob.Bucket) {
+	mem := &memBucket{objects: map[string][]byte{
+		"a/1":   []byte("0123456789"),
+		"a/2":   []byte("abcdefghij"),
+		"b/1":   []byte("ABCDEFGHIJ"),
+		"other": []byte("other"),
+	}}
+	fb := NewBucket(mem, &Options{Faults: faults})
+	return fb, blob.NewBucket(fb)
+}
+
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+	_, b := newBucket(
+		&Fault{Ops: []Op{OpAttributes}, KeyPattern: "a/*", Kind: NotExist},
+		&Fault{Ops: []Op{OpRead}, KeyPattern: "b/*", Kind: Unavailable, Count: 2},
+		&Fault{Ops: []Op{OpDelete}, Kind: Permanent},
+	)
+
+	// A NotExist fault looks like a missing object.
+	if _, err := b.Attributes(ctx, "a/1"); !blob.IsNotExist(err) {
+		t.Errorf("got %v, want IsNotExist", err)
+	}
+	// Other keys and operations are unaffected.
+	if _, err := b.Attributes(ctx, "b/1"); err != nil {
+		t.Error(err)
+	}
+	if _, err := b.ReadAll(ctx, "a/1"); err != nil {
+		t.Error(err)
+	}
+
+	// The Unavailable fault applies twice, and is retryable.
+	for i := 0; i < 2; i++ {
+		_, err := b.ReadAll(ctx, "b/1")
+		var e *Error
+		if !blob.ErrorAs(err, &e) {
+			t.Fatalf("got %v, want a *faultblob.Error", err)
+		}
+		if !e.Retryable() || e.Op != OpRead || e.Key != "b/1" {
+			t.Errorf("got %+v, want a retryable read error for b/1", e)
+		}
+		if blob.IsNotExist(err) {
+			t.Errorf("got IsNotExist for %v, want false", err)
+		}
+	}
+	if _, err := b.ReadAll(ctx, "b/1"); err != nil {
+		t.Errorf("after Count faults: %v", err)
+	}
+
+	err := b.Delete(ctx, "other")
+	var e *Error
+	if !blob.ErrorAs(err, &e) || e.Retryable() {
+		t.Errorf("got %v, want a permanent *faultblob.Error", err)
+	}
+	// Errors from the wrapped bucket are still reported.
+	if _, err := b.ReadAll(ctx, "missing"); !blob.IsNotExist(err) {
+		t.Errorf("got %v for missing object, want IsNotExist", err)
+	}
+}
+
+func TestReads(t *testing.T) {
+	ctx := context.Background()
+	fb, b := newBucket()
+
+	// Short reads still read all of the content.
+	fb.SetFaults([]*Fault{{Ops: []Op{OpRead}, ReadLimit: 3}})
+	r, err := b.NewReader(ctx, "a/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := r.Read(make([]byte, 100)); n != 3 || err != nil {
+		t.Errorf("got Read %d, %v want 3, nil", n, err)
+	}
+	rest, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rest) != "3456789" {
+		t.Errorf("got %q want %q", rest, "3456789")
+	}
+
+	// Mid-stream failure.
+	fb.SetFaults([]*Fault{{Ops: []Op{OpRead}, Kind: Unavailable, FailAfter: 4}})
+	got, err := b.ReadAll(ctx, "a/1")
+	var e *Error
+	if !blob.ErrorAs(err, &e) || e.Kind != Unavailable {
+		t.Errorf("got %v, want an Unavailable *faultblob.Error", err)
+	}
+	if string(got) != "0123" {
+		t.Errorf("got %q before the failure, want %q", got, "0123")
+	}
+
+	// Truncated content.
+	fb.SetFaults([]*Fault{{Ops: []Op{OpRead}, FailAfter: 4}})
+	got, err = b.ReadAll(ctx, "a/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "0123" {
+		t.Errorf("got %q want %q", got, "0123")
+	}
+}
+
+func TestWrites(t *testing.T) {
+	ctx := context.Background()
+	_, b := newBucket(&Fault{Ops: []Op{OpWrite}, Kind: Permanent, FailAfter: 4})
+	w, err := b.NewWriter(ctx, "new", &blob.WriterOptions{ContentType: "text/plain"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := w.Write([]byte("0123456789")); n != 4 || err == nil {
+		t.Errorf("got Write %d, %v want 4, error", n, err)
+	}
+	if err := w.Close(); err == nil {
+		t.Error("got nil error from Close, want error")
+	}
+	if _, err := b.Attributes(ctx, "new"); !blob.IsNotExist(err) {
+		t.Errorf("got %v for failed write, want IsNotExist", err)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	_, b := newBucket(&Fault{Ops: []Op{OpList}, Latency: 50 * time.Millisecond})
+	start := time.Now()
+	if _, err := b.List(nil).Next(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("got List latency %v, want at least 50ms", d)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if _, err := b.List(nil).Next(ctx); err == nil {
+		t.Error("got nil error for List with an expired context, want error")
+	}
+}
+
+func TestRate(t *testing.T) {
+	ctx := context.Background()
+	_, b := newBucket(&Fault{Ops: []Op{OpAttributes}, Kind: Unavailable, Rate: 0.5})
+	var failed int
+	for i := 0; i < 100; i++ {
+		if _, err := b.Attributes(ctx, "other"); err != nil {
+			failed++
+		}
+	}
+	if failed < 20 || failed > 80 {
+		t.Errorf("got %d of 100 operations failing with Rate 0.5", failed)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	ctx := context.Background()
+	policy := &blob.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	fb, b := newBucket()
+	rb := b.WithRetryPolicy(policy)
+
+	// Transient errors are retried up to MaxAttempts.
+	fb.SetFaults([]*Fault{{Ops: []Op{OpAttributes, OpRead, OpDelete, OpList}, Kind: Unavailable, Count: 2}})
+	if _, err := rb.Attributes(ctx, "other"); err != nil {
+		t.Errorf("Attributes: %v", err)
+	}
+	if got, err := rb.ReadAll(ctx, "a/1"); err != nil || string(got) != "0123456789" {
+		t.Errorf("ReadAll: got %q, %v", got, err)
+	}
+	if _, err := rb.List(nil).Next(ctx); err != nil {
+		t.Errorf("List: %v", err)
+	}
+	if err := rb.Delete(ctx, "other"); err != nil {
+		t.Errorf("Delete: %v", err)
+	}
+	fb.SetFaults([]*Fault{{Ops: []Op{OpAttributes}, Kind: Unavailable, Count: 3}})
+	if _, err := rb.Attributes(ctx, "a/1"); err == nil {
+		t.Error("got nil error after MaxAttempts failures, want error")
+	}
+
+	// Permanent errors aren't retried, and the default is not to retry.
+	fb.SetFaults([]*Fault{{Ops: []Op{OpAttributes}, Kind: Permanent, Count: 1}})
+	if _, err := rb.Attributes(ctx, "a/1"); err == nil {
+		t.Error("got nil error for a permanent failure, want error")
+	}
+	fb.SetFaults([]*Fault{{Ops: []Op{OpAttributes}, Kind: Unavailable, Count: 1}})
+	if _, err := b.Attributes(ctx, "a/1"); err == nil {
+		t.Error("got nil error without a RetryPolicy, want error")
+	}
+}
+
+func TestRetryPolicyResumesReads(t *testing.T) {
+	ctx := context.Background()
+	fb, b := newBucket()
+	rb := b.WithRetryPolicy(&blob.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+
+	// Each reader fails after 4 bytes, so reading all 10 needs two resumes.
+	fb.SetFaults([]*Fault{{Ops: []Op{OpRead}, Kind: Unavailable, FailAfter: 4}})
+	got, err := rb.ReadAll(ctx, "a/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "0123456789" {
+		t.Errorf("got %q want %q", got, "0123456789")
+	}
+
+	r, err := rb.NewRangeReader(ctx, "a/2", 2, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "cdefgh" {
+		t.Errorf("got %q want %q", got, "cdefgh")
+	}
+	if r.Size() != 6 {
+		t.Errorf("got Size %d want 6", r.Size())
+	}
+}
+
+func TestRetryPolicyTimeout(t *testing.T) {
+	_, b := newBucket(&Fault{Ops: []Op{OpAttributes, OpRead, OpList}, Latency: time.Second})
+	rb := b.WithRetryPolicy(&blob.RetryPolicy{Timeout: 10 * time.Millisecond})
+	ctx := context.Background()
+	start := time.Now()
+	if _, err := rb.Attributes(ctx, "a/1"); err == nil {
+		t.Error("Attributes: got nil error, want timeout")
+	}
+	if _, err := rb.NewReader(ctx, "a/1"); err == nil {
+		t.Error("NewReader: got nil error, want timeout")
+	}
+	if _, err := rb.List(nil).Next(ctx); err == nil {
+		t.Error("List: got nil error, want timeout")
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("operations took %v, want them to time out", d)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package blobvar provides a runtimevar.Driver implementation that reads
+// variables from objects in a blob.Bucket.
+//
+// The object's attributes are polled, and its content is only read again
+// when its ETag changes. For providers without ETags, the content is read on
+// every poll, and compared with a hash of the previous content; ModTime and
+// Size can't be relied on to change, since a write can keep the size and
+// ModTime may have a resolution as coarse as a second.
+package blobvar
+
+import (
+	"context"
+	"crypto/sha256"
+	"time"
+
+	"github.com/google/go-cloud/blob"
+	"github.com/google/go-cloud/runtimevar"
+	"github.com/google/go-cloud/runtimevar/driver"
+)
+
+// New constructs a runtimevar.Variable object that watches the object with
+// key in bucket. Provide a decoder to unmarshal the object's content into
+// similar objects during the Watch call.
+func New(bucket *blob.Bucket, key string, decoder *runtimevar.Decoder, opts *Options) (*runtimevar.Variable, error) {
+	return runtimevar.New(newWatcher(bucket, key, decoder, opts)), nil
+}
+
+func newWatcher(bucket *blob.Bucket, key string, decoder *runtimevar.Decoder, opts *Options) *watcher {
+	if opts == nil {
+		opts = &Options{}
+	}
+	return &watcher{
+		bucket:  bucket,
+		key:     key,
+		wait:    driver.WaitDuration(opts.WaitDuration),
+		decoder: decoder,
+	}
+}
+
+// Options sets options.
+type Options struct {
+	// WaitDuration controls how quickly Watch polls. Defaults to 30 seconds.
+	WaitDuration time.Duration
+}
+
+// state implements driver.State.
+type state struct {
+	val        interface{}
+	updateTime time.Time
+	// version identifies the object content that val was decoded from.
+	version version
+	err     error
+}
+
+func (s *state) Value() (interface{}, error) {
+	return s.val, s.err
+}
+
+func (s *state) UpdateTime() time.Time {
+	return s.updateTime
+}
+
+// version identifies the content of an object: by its ETag, or by a hash of
+// the content if the provider has no ETags.
+type version struct {
+	etag string
+	sum  [sha256.Size]byte
+}
+
+// versionOf returns the version of the object with attrs and content b.
+func versionOf(attrs blob.Attributes, b []byte) version {
+	if attrs.ETag != "" {
+		return version{etag: attrs.ETag}
+	}
+	return version{sum: sha256.Sum256(b)}
+}
+
+// errorState returns a new State with err, unless prevS also represents
+// the same error, in which case it returns nil.
+func errorState(err error, prevS driver.State) driver.State {
+	s := &state{err: err}
+	if prevS == nil {
+		return s
+	}
+	prev := prevS.(*state)
+	if prev.err == nil {
+		// New error.
+		return s
+	}
+	if err == prev.err || err.Error() == prev.err.Error() {
+		return nil
+	}
+	if blob.IsNotExist(err) && blob.IsNotExist(prev.err) {
+		return nil
+	}
+	return s
+}
+
+// watcher implements driver.Watcher for variables stored in a blob.Bucket.
+type watcher struct {
+	bucket  *blob.Bucket
+	key     string
+	wait    time.Duration
+	decoder *runtimevar.Decoder
+}
+
+// WatchVariable implements driver.WatchVariable.
+func (w *watcher) WatchVariable(ctx context.Context, prev driver.State) (driver.State, time.Duration) {
+	attrs, err := w.bucket.Attributes(ctx, w.key)
+	if err != nil {
+		return errorState(err, prev), w.wait
+	}
+	unchanged := func(v version) bool {
+		return prev != nil && prev.(*state).err == nil && prev.(*state).version == v
+	}
+	if attrs.ETag != "" && unchanged(version{etag: attrs.ETag}) {
+		return nil, w.wait
+	}
+	b, err := w.bucket.ReadAll(ctx, w.key)
+	if err != nil {
+		return errorState(err, prev), w.wait
+	}
+	v := versionOf(attrs, b)
+	if unchanged(v) {
+		return nil, w.wait
+	}
+	val, err := w.decoder.Decode(b)
+	if err != nil {
+		return errorState(err, prev), w.wait
+	}
+	return &state{val: val, updateTime: attrs.ModTime, version: v}, w.wait
+}
+
+// Close implements driver.Close.
+func (w *watcher) Close() error {
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blobvar
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cloud/blob"
+	"github.com/google/go-cloud/blob/fileblob"
+	"github.com/google/go-cloud/runtimevar"
+	"github.com/google/go-cloud/runtimevar/driver"
+	"github.com/google/go-cloud/runtimevar/drivertest"
+)
+
+type harness struct {
+	dir    string
+	bucket *blob.Bucket
+}
+
+func newHarness(t *testing.T) (drivertest.Harness, error) {
+	dir, err := ioutil.TempDir("", "blobvar_test-")
+	if err != nil {
+		return nil, err
+	}
+	b, err := fileblob.OpenBucket(dir, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &harness{dir: dir, bucket: b}, nil
+}
+
+func (h *harness) MakeWatcher(ctx context.Context, name string, decoder *runtimevar.Decoder) (driver.Watcher, error) {
+	// Poll quickly for tests.
+	return newWatcher(h.bucket, name, decoder, &Options{WaitDuration: 1 * time.Millisecond}), nil
+}
+
+func (h *harness) CreateVariable(ctx context.Context, name string, val []byte) error {
+	return h.bucket.WriteAll(ctx, name, val, nil)
+}
+
+func (h *harness) UpdateVariable(ctx context.Context, name string, val []byte) error {
+	return h.bucket.WriteAll(ctx, name, val, nil)
+}
+
+func (h *harness) DeleteVariable(ctx context.Context, name string) error {
+	return h.bucket.Delete(ctx, name)
+}
+
+func (h *harness) Close() {
+	os.RemoveAll(h.dir)
+}
+
+func (h *harness) Mutable() bool { return true }
+
+func TestConformance(t *testing.T) {
+	drivertest.RunConformanceTests(t, newHarness)
+}
+
+func TestVersion(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		a, b       blob.Attributes
+		aVal, bVal string
+		want       bool
+	}{
+		// Without ETags, only the content matters.
+		{blob.Attributes{ModTime: now, Size: 5}, blob.Attributes{ModTime: now, Size: 5}, "hello", "hello", true},
+		{blob.Attributes{ModTime: now, Size: 5}, blob.Attributes{ModTime: now, Size: 5}, "hello", "world", false},
+		{blob.Attributes{ModTime: now, Size: 5}, blob.Attributes{ModTime: now.Add(time.Second), Size: 5}, "hello", "hello", true},
+		// With ETags, only the ETag matters.
+		{blob.Attributes{ETag: "a", ModTime: now}, blob.Attributes{ETag: "a", ModTime: now.Add(time.Second)}, "hello", "world", true},
+		{blob.Attributes{ETag: "a", ModTime: now}, blob.Attributes{ETag: "b", ModTime: now}, "hello", "hello", false},
+	}
+	for i, test := range tests {
+		if got := versionOf(test.a, []byte(test.aVal)) == versionOf(test.b, []byte(test.bVal)); got != test.want {
+			t.Errorf("%d: got %v want %v", i, got, test.want)
+		}
+	}
+}
+
+func TestSameSizeUpdate(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "blobvar_test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := fileblob.OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newWatcher(b, "v", runtimevar.StringDecoder, nil)
+
+	if err := b.WriteAll(ctx, "v", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	s, _ := w.WatchVariable(ctx, nil)
+	if v, err := s.Value(); err != nil || v != "hello" {
+		t.Fatalf("got %v, %v want %q", v, err, "hello")
+	}
+	// Rewrite the object with the same size, keeping its ModTime.
+	attrs, err := b.Attributes(ctx, "v")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteAll(ctx, "v", []byte("world"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(dir, "v"), attrs.ModTime, attrs.ModTime); err != nil {
+		t.Fatal(err)
+	}
+	s2, _ := w.WatchVariable(ctx, s)
+	if s2 == nil {
+		t.Fatal("got no change after a same-size update, want a new value")
+	}
+	if v, err := s2.Value(); err != nil || v != "world" {
+		t.Fatalf("got %v, %v want %q", v, err, "world")
+	}
+	if got, _ := w.WatchVariable(ctx, s2); got != nil {
+		t.Errorf("got %v for an unchanged variable, want nil", got)
+	}
+}
@@ -14,16 +14,39 @@
 
 // Package constantvar provides a runtimevar.Driver implementation for variables
 // that never change.
+//
+// For runtimevar.Open URLs, constantvar registers for the "constant" scheme.
+// The following query parameters are supported:
+// - val: The value of the variable, which is decoded using the decoder.
+// - err: If set, the variable returns an error with this text instead of
+//       a value.
+// Example URL: runtimevar.Open(ctx, "constant://?val=hello+world", nil)
 package constantvar
 
 import (
 	"context"
+	"errors"
+	"net/url"
 	"time"
 
 	"github.com/google/go-cloud/runtimevar"
 	"github.com/google/go-cloud/runtimevar/driver"
 )
 
+func init() {
+	runtimevar.Register("constant", func(_ context.Context, u *url.URL, opts *runtimevar.URLOptions) (driver.Watcher, error) {
+		q := u.Query()
+		if e := q.Get("err"); e != "" {
+			return &watcher{err: errors.New(e)}, nil
+		}
+		v, err := opts.Decoder.Decode([]byte(q.Get("val")))
+		if err != nil {
+			return nil, err
+		}
+		return &watcher{value: v, t: time.Now()}, nil
+	})
+}
+
 // New constructs a runtimevar.Variable that returns value from Watch.
 // Subsequent calls to Watch will block.
 func New(value interface{}) *runtimevar.Variable {
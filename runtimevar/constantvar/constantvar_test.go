@@ -68,3 +68,32 @@ func (h *harness) Mutable() bool { return false }
 func TestConformance(t *testing.T) {
 	drivertest.RunConformanceTests(t, newHarness)
 }
+
+func TestOpen(t *testing.T) {
+	ctx := context.Background()
+	v, err := runtimevar.Open(ctx, "constant://?val=hello+world", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	snap, err := v.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Value != "hello world" {
+		t.Errorf("got %v want %q", snap.Value, "hello world")
+	}
+
+	v, err = runtimevar.Open(ctx, "constant://?err=fail", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	if _, err := v.Watch(ctx); err == nil {
+		t.Error("got nil error from Watch, want error")
+	}
+
+	if _, err := runtimevar.Open(ctx, "constant://?val=notjson&decoder=json", nil); err == nil {
+		t.Error("got nil error opening a value that doesn't decode, want error")
+	}
+}
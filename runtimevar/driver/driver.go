@@ -18,6 +18,7 @@ package driver
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
@@ -80,3 +81,32 @@ type Watcher interface {
 	// Close cleans up any resources used by the Watcher object.
 	Close() error
 }
+
+// ErrRevisionMismatch is returned by the methods of Updater when the
+// variable's current revision is not the one given.
+var ErrRevisionMismatch = errors.New("revision mismatch")
+
+// Updater is an optional interface that a Watcher can implement to support
+// changing the variable. Its methods may be called concurrently with each
+// other and with WatchVariable.
+type Updater interface {
+	// Set sets the variable's value to val, creating the variable if it
+	// doesn't exist.
+	//
+	// If rev is not empty, the variable is only changed if its current
+	// revision is rev, and ErrRevisionMismatch is returned if it isn't.
+	// Implementations that can't check revisions return a different error
+	// if rev is not empty.
+	Set(ctx context.Context, val []byte, rev string) error
+
+	// Delete deletes the variable. rev is as for Set.
+	Delete(ctx context.Context, rev string) error
+}
+
+// Revisioner is an optional interface that a State can implement to report
+// the revision of the variable's value, for use with Updater.
+type Revisioner interface {
+	// Revision returns an opaque string identifying the version of the
+	// variable that the State was read from.
+	Revision() string
+}
@@ -0,0 +1,395 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package envvar provides a runtimevar.Driver implementation that reads
+// variables from environment variables.
+//
+// The value of the environment variable is decoded using the decoder, so
+// for example a JSON value can be decoded into a struct. If the environment
+// variable is not set, Options.Default is used instead, if provided.
+//
+// Options.Flag names a command-line flag that overrides the environment
+// variable. The value is taken from the first of these that is set: the
+// flag, if it was given on the command line; the process environment; the
+// dotenv file; Options.Default.
+//
+// Options.DotenvFile names a dotenv file with lines of the form NAME=VALUE.
+// Variables that are not set in the process environment are looked up in
+// it, and the file is watched so that edits are picked up. Values may be
+// unquoted, 'single quoted' (taken literally), or "double quoted" (with Go
+// escape sequences). Blank lines, lines starting with "#", and an "export "
+// prefix are ignored. Values can't span lines.
+//
+// For runtimevar.Open URLs, envvar registers for the "env" scheme.
+// The URL's Host is used as the name of the environment variable.
+// The following query parameters are supported:
+// - default: Sets Options.Default.
+// - dotenv: Sets Options.DotenvFile.
+// - flag: Sets Options.Flag, for a flag in flag.CommandLine.
+// - wait: Sets Options.WaitDuration.
+// Example URL: runtimevar.Open(ctx, "env://MYAPP_CONFIG?decoder=json&dotenv=.env", nil)
+package envvar
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-cloud/runtimevar"
+	"github.com/google/go-cloud/runtimevar/driver"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func init() {
+	runtimevar.Register("env", func(_ context.Context, u *url.URL, opts *runtimevar.URLOptions) (driver.Watcher, error) {
+		q := u.Query()
+		o := &Options{
+			DotenvFile:   q.Get("dotenv"),
+			Flag:         q.Get("flag"),
+			WaitDuration: opts.WaitDuration,
+		}
+		if _, ok := q["default"]; ok {
+			o.Default = []byte(q.Get("default"))
+		}
+		return newWatcher(u.Host, opts.Decoder, o)
+	})
+}
+
+// Options sets options.
+type Options struct {
+	// Default, if not nil, is decoded and used as the value when the
+	// environment variable is not set.
+	Default []byte
+	// DotenvFile, if not empty, is the path of a dotenv file to look up the
+	// variable in when it is not set in the process environment. The file is
+	// watched for changes; if it does not exist, it is treated as empty until
+	// it is created. The directory containing it must exist.
+	DotenvFile string
+	// Flag, if not empty, is the name of a flag in FlagSet whose value is used
+	// instead of the environment variable if the flag was set on the command
+	// line. The flag's own default value is not used; set Default instead.
+	// The flag must be defined before New is called.
+	Flag string
+	// FlagSet is the flag set that Flag is defined in. Defaults to
+	// flag.CommandLine.
+	FlagSet *flag.FlagSet
+	// WaitDuration controls how often the process environment is checked for
+	// changes, and the frequency of retries after an error. Defaults to 30
+	// seconds.
+	WaitDuration time.Duration
+}
+
+// New constructs a runtimevar.Variable object that reads the environment
+// variable name. Provide a decoder to unmarshal its value into similar
+// objects during the Watch call.
+func New(name string, decoder *runtimevar.Decoder, opts *Options) (*runtimevar.Variable, error) {
+	w, err := newWatcher(name, decoder, opts)
+	if err != nil {
+		return nil, err
+	}
+	return runtimevar.New(w), nil
+}
+
+func newWatcher(name string, decoder *runtimevar.Decoder, opts *Options) (*watcher, error) {
+	if name == "" {
+		return nil, fmt.Errorf("envvar: empty variable name")
+	}
+	if opts == nil {
+		opts = &Options{}
+	}
+	w := &watcher{
+		name:    name,
+		decoder: decoder,
+		dflt:    opts.Default,
+		wait:    driver.WaitDuration(opts.WaitDuration),
+	}
+	if opts.Flag != "" {
+		w.flags = opts.FlagSet
+		if w.flags == nil {
+			w.flags = flag.CommandLine
+		}
+		if w.flags.Lookup(opts.Flag) == nil {
+			return nil, fmt.Errorf("envvar: flag -%s is not defined", opts.Flag)
+		}
+		w.flag = opts.Flag
+	}
+	if opts.DotenvFile == "" {
+		return w, nil
+	}
+	file, err := filepath.Abs(opts.DotenvFile)
+	if err != nil {
+		return nil, err
+	}
+	notifier, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// Watch the directory rather than the file, so that we see the file
+	// being created, and editors that replace the file by renaming.
+	if err := notifier.Add(filepath.Dir(file)); err != nil {
+		notifier.Close()
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w.file = file
+	// See struct comments for why it's buffered.
+	w.changed = make(chan struct{}, 1)
+	w.closeCh = make(chan error)
+	w.shutdown = cancel
+	go w.notify(ctx, notifier)
+	return w, nil
+}
+
+// state implements driver.State.
+type state struct {
+	val        interface{}
+	updateTime time.Time
+	raw        []byte
+	err        error
+}
+
+func (s *state) Value() (interface{}, error) {
+	return s.val, s.err
+}
+
+func (s *state) UpdateTime() time.Time {
+	return s.updateTime
+}
+
+// equal reports whether s and t hold the same value or the same error.
+func (s *state) equal(t *state) bool {
+	if s.err != nil || t.err != nil {
+		return s.err != nil && t.err != nil && s.err.Error() == t.err.Error()
+	}
+	return bytes.Equal(s.raw, t.raw)
+}
+
+// watcher implements driver.Watcher for environment variables.
+type watcher struct {
+	name    string
+	decoder *runtimevar.Decoder
+	dflt    []byte
+	wait    time.Duration
+	// flags and flag are set when the variable can be set by a flag.
+	flags *flag.FlagSet
+	flag  string
+
+	// The fields below are only set when watching a dotenv file.
+	file string
+	// changed is written to by the background goroutine when the file may
+	// have changed. It is buffered and written to without blocking, so that
+	// a change that happens while WatchVariable isn't waiting is not lost.
+	changed chan struct{}
+	// closeCh is used to return any errors from closing the notifier
+	// back to watcher.Close.
+	closeCh chan error
+	// shutdown tells the background goroutine to exit.
+	shutdown func()
+}
+
+// WatchVariable implements driver.WatchVariable.
+func (w *watcher) WatchVariable(ctx context.Context, prev driver.State) (driver.State, time.Duration) {
+	for {
+		cur := w.read()
+		if prev == nil || !cur.equal(prev.(*state)) {
+			if cur.err != nil {
+				return cur, w.wait
+			}
+			return cur, 0
+		}
+		if w.changed == nil {
+			// Only the process environment to check; poll.
+			return nil, w.wait
+		}
+		select {
+		case <-ctx.Done():
+			return &state{err: ctx.Err()}, 0
+		case <-w.changed:
+		case <-time.After(w.wait):
+		}
+	}
+}
+
+// read returns the current state of the variable.
+func (w *watcher) read() *state {
+	b, err := w.lookup()
+	if err != nil {
+		return &state{err: err}
+	}
+	val, err := w.decoder.Decode(b)
+	if err != nil {
+		return &state{err: err}
+	}
+	return &state{val: val, updateTime: time.Now(), raw: b}
+}
+
+// lookup returns the raw value of the variable, from the flag, the process
+// environment, the dotenv file, or the default, in that order.
+func (w *watcher) lookup() ([]byte, error) {
+	if w.flag != "" {
+		set := false
+		w.flags.Visit(func(f *flag.Flag) {
+			if f.Name == w.flag {
+				set = true
+			}
+		})
+		if set {
+			return []byte(w.flags.Lookup(w.flag).Value.String()), nil
+		}
+	}
+	if v, ok := os.LookupEnv(w.name); ok {
+		return []byte(v), nil
+	}
+	if w.file != "" {
+		env, err := readDotenv(w.file)
+		if err != nil {
+			return nil, err
+		}
+		if v, ok := env[w.name]; ok {
+			return []byte(v), nil
+		}
+	}
+	if w.dflt != nil {
+		return w.dflt, nil
+	}
+	return nil, fmt.Errorf("envvar: %s is not set", w.name)
+}
+
+// notify is run by a background goroutine. It signals w.changed for each
+// event on the dotenv file. It exits when ctx is canceled, and writes any
+// error from closing notifier to w.closeCh.
+func (w *watcher) notify(ctx context.Context, notifier *fsnotify.Watcher) {
+	for {
+		select {
+		case <-ctx.Done():
+			w.closeCh <- notifier.Close()
+			return
+		case event := <-notifier.Events:
+			if event.Name != w.file {
+				continue
+			}
+		case <-notifier.Errors:
+			// We may have missed an event; re-read the file to be safe.
+		}
+		select {
+		case w.changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Close implements driver.Close.
+func (w *watcher) Close() error {
+	if w.shutdown == nil {
+		return nil
+	}
+	// Tell the background goroutine to shut down by canceling its ctx.
+	w.shutdown()
+	// Wait for it to return the result of closing the notifier.
+	return <-w.closeCh
+}
+
+// readDotenv reads and parses the dotenv file at path. A file that does not
+// exist is treated as empty.
+func readDotenv(path string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	env, err := parseDotenv(b)
+	if err != nil {
+		return nil, fmt.Errorf("envvar: %s: %v", path, err)
+	}
+	return env, nil
+}
+
+// parseDotenv parses the content of a dotenv file. Errors are prefixed with
+// the line number.
+func parseDotenv(b []byte) (map[string]string, error) {
+	env := map[string]string{}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: missing \"=\"", n)
+		}
+		name := strings.TrimSpace(line[:i])
+		if name == "" {
+			return nil, fmt.Errorf("line %d: missing variable name", n)
+		}
+		val, err := parseDotenvValue(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		env[name] = val
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+func parseDotenvValue(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, `"`):
+		end := closingQuote(v)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated quoted value %s", v)
+		}
+		return strconv.Unquote(v[:end+1])
+	case strings.HasPrefix(v, "'"):
+		end := strings.Index(v[1:], "'")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated quoted value %s", v)
+		}
+		return v[1 : end+1], nil
+	}
+	// Unquoted values end at a comment.
+	if i := strings.Index(v, " #"); i >= 0 {
+		v = strings.TrimSpace(v[:i])
+	}
+	return v, nil
+}
+
+// closingQuote returns the index of the double quote that closes the
+// quoted string starting at v[0], or -1 if there is none.
+func closingQuote(v string) int {
+	for i := 1; i < len(v); i++ {
+		switch v[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
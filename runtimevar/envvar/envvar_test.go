@@ -0,0 +1,262 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envvar
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cloud/runtimevar"
+	"github.com/google/go-cloud/runtimevar/driver"
+	"github.com/google/go-cloud/runtimevar/drivertest"
+	"github.com/google/go-cmp/cmp"
+)
+
+// harness stores variables in a dotenv file.
+type harness struct {
+	dir string
+	mu  sync.Mutex
+	env map[string]string
+}
+
+func newHarness(t *testing.T) (drivertest.Harness, error) {
+	dir, err := ioutil.TempDir("", "envvar_test-")
+	if err != nil {
+		return nil, err
+	}
+	return &harness{dir: dir, env: map[string]string{}}, nil
+}
+
+func (h *harness) MakeWatcher(ctx context.Context, name string, decoder *runtimevar.Decoder) (driver.Watcher, error) {
+	// Retry quickly for tests.
+	return newWatcher(name, decoder, &Options{DotenvFile: h.file(), WaitDuration: 1 * time.Millisecond})
+}
+
+func (h *harness) file() string {
+	return filepath.Join(h.dir, ".env")
+}
+
+// write rewrites the dotenv file after f updates h.env.
+func (h *harness) write(f func()) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	f()
+	var names []string
+	for name := range h.env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b []byte
+	for _, name := range names {
+		b = append(b, fmt.Sprintf("%s=%s\n", name, strconv.Quote(h.env[name]))...)
+	}
+	return ioutil.WriteFile(h.file(), b, 0666)
+}
+
+func (h *harness) CreateVariable(ctx context.Context, name string, val []byte) error {
+	return h.write(func() { h.env[name] = string(val) })
+}
+
+func (h *harness) UpdateVariable(ctx context.Context, name string, val []byte) error {
+	return h.write(func() { h.env[name] = string(val) })
+}
+
+func (h *harness) DeleteVariable(ctx context.Context, name string) error {
+	return h.write(func() { delete(h.env, name) })
+}
+
+func (h *harness) Close() {
+	os.RemoveAll(h.dir)
+}
+
+func (h *harness) Mutable() bool { return true }
+
+func TestConformance(t *testing.T) {
+	drivertest.RunConformanceTests(t, newHarness)
+}
+
+func TestEnvironment(t *testing.T) {
+	const name = "ENVVAR_TEST_VARIABLE"
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "envvar_test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dotenv := filepath.Join(dir, ".env")
+	if err := ioutil.WriteFile(dotenv, []byte(name+"=from-file\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv(name)
+
+	tests := []struct {
+		description string
+		env         *string
+		args        []string
+		opts        *Options
+		want        string
+		wantErr     bool
+	}{
+		{description: "not set", wantErr: true},
+		{description: "default", opts: &Options{Default: []byte("from-default")}, want: "from-default"},
+		{description: "environment", env: strPtr("from-env"), opts: &Options{Default: []byte("from-default")}, want: "from-env"},
+		{description: "set but empty", env: strPtr(""), opts: &Options{Default: []byte("from-default")}, want: ""},
+		{description: "dotenv", opts: &Options{DotenvFile: dotenv, Default: []byte("from-default")}, want: "from-file"},
+		{description: "environment over dotenv", env: strPtr("from-env"), opts: &Options{DotenvFile: dotenv}, want: "from-env"},
+		{description: "missing dotenv", opts: &Options{DotenvFile: filepath.Join(dir, "missing.env"), Default: []byte("from-default")}, want: "from-default"},
+		{description: "flag", env: strPtr("from-env"), args: []string{"-config=from-flag"}, opts: &Options{Flag: "config", DotenvFile: dotenv, Default: []byte("from-default")}, want: "from-flag"},
+		{description: "flag set to empty", env: strPtr("from-env"), args: []string{"-config="}, opts: &Options{Flag: "config"}, want: ""},
+		{description: "flag not set", env: strPtr("from-env"), opts: &Options{Flag: "config"}, want: "from-env"},
+		{description: "flag not set over dotenv", opts: &Options{Flag: "config", DotenvFile: dotenv}, want: "from-file"},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			os.Unsetenv(name)
+			if test.env != nil {
+				os.Setenv(name, *test.env)
+			}
+			if test.opts != nil && test.opts.Flag != "" {
+				fs := flag.NewFlagSet("test", flag.ContinueOnError)
+				fs.String("config", "from-flag-default", "")
+				if err := fs.Parse(test.args); err != nil {
+					t.Fatal(err)
+				}
+				test.opts.FlagSet = fs
+			}
+			v, err := New(name, runtimevar.StringDecoder, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer v.Close()
+			snap, err := v.Watch(ctx)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+			if err == nil && snap.Value.(string) != test.want {
+				t.Errorf("got %q want %q", snap.Value, test.want)
+			}
+		})
+	}
+}
+
+func TestUndefinedFlag(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	if _, err := New("ENVVAR_TEST_VARIABLE", runtimevar.StringDecoder, &Options{Flag: "config", FlagSet: fs}); err == nil {
+		t.Error("got nil error for an undefined flag, want error")
+	}
+}
+
+func TestEnvironmentChange(t *testing.T) {
+	const name = "ENVVAR_TEST_VARIABLE"
+	ctx := context.Background()
+	os.Setenv(name, "one")
+	defer os.Unsetenv(name)
+
+	v, err := New(name, runtimevar.StringDecoder, &Options{WaitDuration: 1 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	if snap, err := v.Watch(ctx); err != nil || snap.Value.(string) != "one" {
+		t.Fatalf("got %v, %v want %q", snap.Value, err, "one")
+	}
+	os.Setenv(name, "two")
+	if snap, err := v.Watch(ctx); err != nil || snap.Value.(string) != "two" {
+		t.Fatalf("got %v, %v want %q", snap.Value, err, "two")
+	}
+}
+
+func TestParseDotenv(t *testing.T) {
+	const content = `
+# A comment.
+PLAIN=hello world
+export EXPORTED=yes
+  SPACED = value
+COMMENTED=value # a comment
+DOUBLE="line one\nline two # not a comment"
+SINGLE='no \n escapes'
+EMPTY=
+JSON={"a": 1}
+`
+	want := map[string]string{
+		"PLAIN":     "hello world",
+		"EXPORTED":  "yes",
+		"SPACED":    "value",
+		"COMMENTED": "value",
+		"DOUBLE":    "line one\nline two # not a comment",
+		"SINGLE":    `no \n escapes`,
+		"EMPTY":     "",
+		"JSON":      `{"a": 1}`,
+	}
+	got, err := parseDotenv([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Error(diff)
+	}
+
+	for _, bad := range []string{"NOEQUALS", "=value", `A="unterminated`, "A='unterminated"} {
+		if _, err := parseDotenv([]byte(bad)); err == nil {
+			t.Errorf("%q: got nil error, want error", bad)
+		}
+	}
+}
+
+func TestReadDotenvError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envvar_test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ".env")
+	if err := ioutil.WriteFile(path, []byte("A=1\n\nNOEQUALS\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	_, err = readDotenv(path)
+	if want := fmt.Sprintf(`envvar: %s: line 3: missing "="`, path); err == nil || err.Error() != want {
+		t.Errorf("got error %v want %q", err, want)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	const name = "ENVVAR_TEST_VARIABLE"
+	ctx := context.Background()
+	os.Unsetenv(name)
+
+	v, err := runtimevar.Open(ctx, "env://"+name+"?decoder=json&default=%7B%22a%22%3A1%7D", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	snap, err := v.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(snap.Value, map[string]interface{}{"a": 1.0}); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func strPtr(s string) *string { return &s }
@@ -13,21 +13,55 @@
 // limitations under the License.
 
 // Package etcdvar provides a runtimevar.Driver implementation to read
-// variables from etcd.
+// variables from etcd. A variable can be a single key, or all the keys with
+// a prefix, using NewPrefix.
+//
+// Variable.Set and Variable.Delete are supported for single keys, with
+// compare-and-swap on the etcd revision of the key's last change.
+//
+// For runtimevar.Open URLs, etcdvar registers for the "etcd" scheme.
+// The URL's Host is the address of an etcd server, which is connected to
+// over HTTP; the URL's Path, without its leading "/", is the variable name.
+// Use "//" for names that start with "/". The connection is closed when the
+// Variable is closed. If the "prefix" query parameter is "true", the name is
+// used as a prefix, as for NewPrefix.
+// Example URL: runtimevar.Open(ctx, "etcd://localhost:2379/myapp/config", nil)
 package etcdvar
 
 import (
 	"context"
 	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/coreos/etcd/clientv3"
 	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/coreos/etcd/mvcc/mvccpb"
 	"github.com/google/go-cloud/runtimevar"
 	"github.com/google/go-cloud/runtimevar/driver"
 	"google.golang.org/grpc/codes"
 )
 
+func init() {
+	runtimevar.Register("etcd", func(_ context.Context, u *url.URL, opts *runtimevar.URLOptions) (driver.Watcher, error) {
+		cli, err := clientv3.NewFromURL("http://" + u.Host)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimPrefix(u.Path, "/")
+		if u.Query().Get("prefix") == "true" {
+			w := newPrefixWatcher(name, cli, opts.Decoder)
+			w.closeClient = cli.Close
+			return w, nil
+		}
+		w := newWatcher(name, cli, opts.Decoder)
+		w.closeClient = cli.Close
+		return w, nil
+	})
+}
+
 // Options sets options.
 // It is provided for future extensibility.
 type Options struct{}
@@ -45,20 +79,50 @@ func newWatcher(name string, cli *clientv3.Client, decoder *runtimevar.Decoder)
 	// The cancel function will be used to shut it down during Close.
 	ctx, cancel := context.WithCancel(context.Background())
 	w := &watcher{
-		// See struct comments for why it's buffered.
-		ch:       make(chan *state, 1),
-		shutdown: cancel,
+		background: background{
+			// See struct comments for why it's buffered.
+			ch:       make(chan *state, 1),
+			shutdown: cancel,
+		},
+		cli:  cli,
+		name: name,
 	}
 	go w.watch(ctx, cli, name, decoder)
 	return w
 }
 
+// NewPrefix constructs a runtimevar.Variable object that uses client to
+// watch all the keys in etcd that start with prefix. Its Snapshot values are
+// of type map[string]interface{}, mapping each key, without prefix, to its
+// value decoded with decoder. Each change to the keys, including an etcd
+// transaction that changes several of them, produces a single new Snapshot,
+// whose Revision is the etcd revision of the change. If any value fails to
+// decode, the variable holds the error until the value is fixed.
+func NewPrefix(prefix string, cli *clientv3.Client, decoder *runtimevar.Decoder, _ *Options) (*runtimevar.Variable, error) {
+	return runtimevar.New(newPrefixWatcher(prefix, cli, decoder)), nil
+}
+
+func newPrefixWatcher(prefix string, cli *clientv3.Client, decoder *runtimevar.Decoder) *prefixWatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &prefixWatcher{
+		background: background{
+			// See struct comments for why it's buffered.
+			ch:       make(chan *state, 1),
+			shutdown: cancel,
+		},
+	}
+	go w.watch(ctx, cli, prefix, decoder)
+	return w
+}
+
 // state implements driver.State.
 type state struct {
 	val        interface{}
 	updateTime time.Time
 	version    int64
-	err        error
+	// modRevision is the etcd revision of the last change to the key.
+	modRevision int64
+	err         error
 }
 
 func (s *state) Value() (interface{}, error) {
@@ -69,8 +133,24 @@ func (s *state) UpdateTime() time.Time {
 	return s.updateTime
 }
 
-// watcher implements driver.Watcher.
+// Revision implements driver.Revisioner.
+func (s *state) Revision() string {
+	if s.err != nil {
+		return ""
+	}
+	return strconv.FormatInt(s.modRevision, 10)
+}
+
+// watcher implements driver.Watcher and driver.Updater for a single key.
 type watcher struct {
+	background
+	cli  *clientv3.Client
+	name string
+}
+
+// background implements driver.Watcher for a variable that is read by a
+// background goroutine.
+type background struct {
 	// The background goroutine writes new *state values to ch.
 	// It is buffered so that the background goroutine can write without
 	// blocking; it always drains the buffer before writing so that the latest
@@ -80,22 +160,25 @@ type watcher struct {
 	ch chan *state
 	// shutdown tells the background goroutine to exit.
 	shutdown func()
+	// closeClient, if not nil, closes the client the watcher was opened
+	// with.
+	closeClient func() error
 }
 
 // WatchVariable implements driver.WatchVariable.
-func (w *watcher) WatchVariable(ctx context.Context, _ driver.State) (driver.State, time.Duration) {
+func (b *background) WatchVariable(ctx context.Context, _ driver.State) (driver.State, time.Duration) {
 	select {
 	case <-ctx.Done():
 		return &state{err: ctx.Err()}, 0
-	case cur := <-w.ch:
+	case cur := <-b.ch:
 		return cur, 0
 	}
 }
 
 // updateState checks to see if s and prev both represent the same error.
-// If not, it drains any previous state buffered in w.ch, then writes s to it.
+// If not, it drains any previous state buffered in b.ch, then writes s to it.
 // It always return s.
-func (w *watcher) updateState(s, prev *state) *state {
+func (b *background) updateState(s, prev *state) *state {
 	if s.err != nil && prev != nil && prev.err != nil {
 		if s.err == prev.err {
 			// s represents the same error as prev.
@@ -115,12 +198,12 @@ func (w *watcher) updateState(s, prev *state) *state {
 	}
 	// Drain any buffered value on ch; it is now stale.
 	select {
-	case <-w.ch:
+	case <-b.ch:
 	default:
 	}
 	// This write can't block, since we're the only writer, ch has a buffer
 	// size of 1, and we just read anything that was buffered.
-	w.ch <- s
+	b.ch <- s
 	return s
 }
 
@@ -147,7 +230,7 @@ func (w *watcher) watch(ctx context.Context, cli *clientv3.Client, name string,
 				if err != nil {
 					cur = w.updateState(&state{err: err}, cur)
 				}
-				cur = w.updateState(&state{val: val, updateTime: time.Now(), version: kv.Version}, cur)
+				cur = w.updateState(&state{val: val, updateTime: time.Now(), version: kv.Version, modRevision: kv.ModRevision}, cur)
 			}
 		}
 
@@ -160,12 +243,142 @@ func (w *watcher) watch(ctx context.Context, cli *clientv3.Client, name string,
 	}
 }
 
+// prefixWatcher implements driver.Watcher for all the keys with a prefix.
+type prefixWatcher struct {
+	background
+}
+
+// prefixRetryWait is how long prefixWatcher.watch waits after failing to
+// read or watch the keys before trying again.
+const prefixRetryWait = time.Second
+
+// watch is run by a background goroutine.
+// It reads the keys with prefix, then keeps them up to date with a single
+// cli.Watch that starts after the revision that was read, writing new states
+// to w.ch. If the watch fails, it is restarted after the last revision seen,
+// or if that revision has been compacted, the keys are read again.
+// It exits when ctx is canceled, and closes w.ch.
+func (w *prefixWatcher) watch(ctx context.Context, cli *clientv3.Client, prefix string, decoder *runtimevar.Decoder) {
+	var cur *state
+	defer close(w.ch)
+
+	// raw holds the values of the keys as of revision rev. rev is 0 if
+	// the keys need to be read.
+	var raw map[string][]byte
+	var rev int64
+	for ctx.Err() == nil {
+		if rev == 0 {
+			resp, err := cli.Get(ctx, prefix, clientv3.WithPrefix())
+			if err != nil {
+				cur = w.updateState(&state{err: err}, cur)
+				select {
+				case <-ctx.Done():
+				case <-time.After(prefixRetryWait):
+				}
+				continue
+			}
+			raw = map[string][]byte{}
+			for _, kv := range resp.Kvs {
+				raw[string(kv.Key)] = kv.Value
+			}
+			rev = resp.Header.Revision
+			cur = w.updateState(decodePrefix(raw, prefix, decoder, rev), cur)
+		}
+
+		watchCtx, cancel := context.WithCancel(ctx)
+		for resp := range cli.Watch(watchCtx, prefix, clientv3.WithPrefix(), clientv3.WithRev(rev+1)) {
+			if resp.CompactRevision != 0 {
+				// Events we haven't seen are gone; start over.
+				rev = 0
+				break
+			}
+			if resp.Err() != nil {
+				break
+			}
+			if len(resp.Events) == 0 {
+				continue
+			}
+			for _, ev := range resp.Events {
+				switch ev.Type {
+				case mvccpb.PUT:
+					raw[string(ev.Kv.Key)] = ev.Kv.Value
+				case mvccpb.DELETE:
+					delete(raw, string(ev.Kv.Key))
+				}
+				rev = ev.Kv.ModRevision
+			}
+			cur = w.updateState(decodePrefix(raw, prefix, decoder, rev), cur)
+		}
+		cancel()
+		if rev != 0 {
+			// The watch failed; don't retry in a tight loop.
+			select {
+			case <-ctx.Done():
+			case <-time.After(prefixRetryWait):
+			}
+		}
+	}
+}
+
+// decodePrefix returns the state for the keys in raw, as of revision rev.
+func decodePrefix(raw map[string][]byte, prefix string, decoder *runtimevar.Decoder, rev int64) *state {
+	val := make(map[string]interface{}, len(raw))
+	for k, b := range raw {
+		v, err := decoder.Decode(b)
+		if verr, ok := err.(*runtimevar.ValidationError); ok {
+			return &state{err: &runtimevar.ValidationError{Err: fmt.Errorf("%q: %v", k, verr.Err)}}
+		}
+		if err != nil {
+			return &state{err: fmt.Errorf("%q: %v", k, err)}
+		}
+		val[strings.TrimPrefix(k, prefix)] = v
+	}
+	return &state{val: val, updateTime: time.Now(), modRevision: rev}
+}
+
+// Set implements driver.Updater. Revisions are the etcd revisions of the
+// last change to the key; the revision "0" matches a key that doesn't exist.
+func (w *watcher) Set(ctx context.Context, val []byte, rev string) error {
+	return w.update(ctx, clientv3.OpPut(w.name, string(val)), rev)
+}
+
+// Delete implements driver.Updater.
+func (w *watcher) Delete(ctx context.Context, rev string) error {
+	return w.update(ctx, clientv3.OpDelete(w.name), rev)
+}
+
+// update performs op, if rev is empty or matches the key's revision.
+func (w *watcher) update(ctx context.Context, op clientv3.Op, rev string) error {
+	if rev == "" {
+		_, err := w.cli.Do(ctx, op)
+		return err
+	}
+	modRev, err := strconv.ParseInt(rev, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid revision %q", rev)
+	}
+	resp, err := w.cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(w.name), "=", modRev)).
+		Then(op).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return driver.ErrRevisionMismatch
+	}
+	return nil
+}
+
 // Close implements driver.Close.
-func (w *watcher) Close() error {
+func (b *background) Close() error {
 	// Tell the background goroutine to shut down by canceling its ctx.
-	w.shutdown()
+	b.shutdown()
 	// Wait for it to exit.
-	for _ = range w.ch {
+	for _ = range b.ch {
+	}
+	if b.closeClient != nil {
+		return b.closeClient()
 	}
 	return nil
 }
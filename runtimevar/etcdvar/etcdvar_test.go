@@ -16,6 +16,7 @@ package etcdvar
 
 import (
 	"context"
+	"strconv"
 	"testing"
 
 	"github.com/coreos/etcd/clientv3"
@@ -23,6 +24,7 @@ import (
 	"github.com/google/go-cloud/runtimevar"
 	"github.com/google/go-cloud/runtimevar/driver"
 	"github.com/google/go-cloud/runtimevar/drivertest"
+	"github.com/google/go-cmp/cmp"
 )
 
 var (
@@ -84,3 +86,194 @@ func (h *harness) Close() {
 func TestConformance(t *testing.T) {
 	drivertest.RunConformanceTests(t, newHarness)
 }
+
+func TestOpen(t *testing.T) {
+	if etcdErr != nil {
+		t.Fatal(etcdErr)
+	}
+	ctx := context.Background()
+	h, err := newHarness(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if err := h.CreateVariable(ctx, "/open/test", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	v, err := runtimevar.Open(ctx, "etcd://localhost:2379//open/test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	snap, err := v.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Value != "hello" {
+		t.Errorf("got %v want %q", snap.Value, "hello")
+	}
+}
+
+func TestSet(t *testing.T) {
+	if etcdErr != nil {
+		t.Fatal(etcdErr)
+	}
+	ctx := context.Background()
+	h, err := newHarness(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	const name = "set-test"
+	v := runtimevar.New(newWatcher(name, h.(*harness).client, runtimevar.StringDecoder))
+	defer v.Close()
+	defer h.DeleteVariable(ctx, name)
+
+	// "0" matches a key that doesn't exist.
+	if err := v.Set(ctx, []byte("hello"), "0"); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := v.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Value != "hello" || snap.Revision == "" {
+		t.Fatalf("got %v with revision %q, want %q with a revision", snap.Value, snap.Revision, "hello")
+	}
+	if err := v.Set(ctx, []byte("world"), snap.Revision); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Set(ctx, []byte("lost"), snap.Revision); err != runtimevar.ErrRevisionMismatch {
+		t.Errorf("got %v want %v", err, runtimevar.ErrRevisionMismatch)
+	}
+	snap2, err := v.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap2.Value != "world" || snap2.Revision == snap.Revision {
+		t.Errorf("got %v with revision %q, want %q with a new revision", snap2.Value, snap2.Revision, "world")
+	}
+
+	if err := v.Delete(ctx, snap.Revision); err != runtimevar.ErrRevisionMismatch {
+		t.Errorf("got %v want %v", err, runtimevar.ErrRevisionMismatch)
+	}
+	if err := v.Delete(ctx, snap2.Revision); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Watch(ctx); err == nil {
+		t.Error("got nil error after Delete, want error")
+	}
+	if err := v.Set(ctx, []byte("again"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if snap, err := v.Watch(ctx); err != nil || snap.Value != "again" {
+		t.Errorf("got %v, %v want %q", snap.Value, err, "again")
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	if etcdErr != nil {
+		t.Fatal(etcdErr)
+	}
+	ctx := context.Background()
+	h, err := newHarness(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	cli := h.(*harness).client
+	const prefix = "/prefix-test/tenants/"
+	defer cli.Delete(ctx, prefix, clientv3.WithPrefix())
+
+	if _, err := cli.Put(ctx, prefix+"a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cli.Put(ctx, prefix+"b", "2"); err != nil {
+		t.Fatal(err)
+	}
+	// Not under the prefix.
+	if _, err := cli.Put(ctx, "/prefix-test/other", "x"); err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Delete(ctx, "/prefix-test/other")
+
+	v, err := NewPrefix(prefix, cli, runtimevar.StringDecoder, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	check := func(want map[string]interface{}) runtimevar.Snapshot {
+		t.Helper()
+		snap, err := v.Watch(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(snap.Value, want); diff != "" {
+			t.Errorf("value diff:\n%s", diff)
+		}
+		return snap
+	}
+	snap1 := check(map[string]interface{}{"a": "1", "b": "2"})
+
+	// A transaction is seen as a single change.
+	resp, err := cli.Txn(ctx).Then(
+		clientv3.OpPut(prefix+"c", "3"),
+		clientv3.OpPut(prefix+"b", "two"),
+		clientv3.OpDelete(prefix+"a"),
+	).Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap2 := check(map[string]interface{}{"b": "two", "c": "3"})
+	if want := strconv.FormatInt(resp.Header.Revision, 10); snap2.Revision != want || snap1.Revision == want {
+		t.Errorf("got revisions %q then %q, want a change to %q", snap1.Revision, snap2.Revision, want)
+	}
+
+	if _, err := cli.Delete(ctx, prefix, clientv3.WithPrefix()); err != nil {
+		t.Fatal(err)
+	}
+	check(map[string]interface{}{})
+}
+
+func TestPrefixDecodeError(t *testing.T) {
+	if etcdErr != nil {
+		t.Fatal(etcdErr)
+	}
+	ctx := context.Background()
+	h, err := newHarness(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	cli := h.(*harness).client
+	const prefix = "/prefix-decode-test/"
+	defer cli.Delete(ctx, prefix, clientv3.WithPrefix())
+
+	if _, err := cli.Put(ctx, prefix+"a", `{"x": 1}`); err != nil {
+		t.Fatal(err)
+	}
+	v, err := runtimevar.Open(ctx, "etcd://localhost:2379/"+prefix+"?prefix=true&decoder=json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	if _, err := v.Watch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cli.Put(ctx, prefix+"b", "not json"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Watch(ctx); err == nil {
+		t.Error("got nil error for a value that doesn't decode, want error")
+	}
+	if _, err := cli.Put(ctx, prefix+"b", `{"x": 2}`); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := v.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(snap.Value.(map[string]interface{})); got != 2 {
+		t.Errorf("got %d keys want 2", got)
+	}
+}
@@ -28,14 +28,31 @@
 // * Saving a configuration file in vim using :w will incur events Rename and Create. When the
 // Rename event occurs, the file is temporarily removed and hence Watch will return error.  A
 // follow-up Watch call will then detect the Create event.
+//
+// Variable.Set and Variable.Delete are supported. Revisions are hashes of the
+// file's content.
+//
+// For runtimevar.Open URLs, filevar registers for the "file" scheme.
+// The URL's Path is used as the file name; the URL's Host is ignored.
+// If os.PathSeparator != "/", any leading "/" from the Path is dropped.
+// The "wait" query parameter sets Options.WaitDuration, and if the
+// "decode_by_ext" query parameter is "true", Options.DecodeByExtension is
+// set; unless another decoder is given, the file is then decoded into the
+// default type for its extension.
+// Example URL: runtimevar.Open(ctx, "file:///etc/myapp/config.json?decoder=json", nil)
 package filevar
 
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/google/go-cloud/runtimevar"
@@ -44,6 +61,25 @@ import (
 	"github.com/fsnotify/fsnotify"
 )
 
+func init() {
+	runtimevar.Register("file", func(_ context.Context, u *url.URL, opts *runtimevar.URLOptions) (driver.Watcher, error) {
+		path := u.Path
+		if os.PathSeparator != '/' && strings.HasPrefix(path, "/") {
+			path = path[1:]
+		}
+		decoder := opts.Decoder
+		byExt := u.Query().Get("decode_by_ext") == "true"
+		if byExt && decoder == runtimevar.StringDecoder {
+			// StringDecoder is the default; let the extension decide.
+			decoder = nil
+		}
+		return newWatcher(path, decoder, &Options{
+			WaitDuration:      opts.WaitDuration,
+			DecodeByExtension: byExt,
+		})
+	})
+}
+
 // New constructs a runtimevar.Variable object with this package as the driver
 // implementation.  The decoder argument allows users to dictate the decoding function to parse the
 // file as well as the type to unmarshal into.
@@ -60,6 +96,12 @@ func newWatcher(file string, decoder *runtimevar.Decoder, opts *Options) (*watch
 		opts = &Options{}
 	}
 
+	if opts.DecodeByExtension {
+		var err error
+		if decoder, err = decoderForExtension(file, decoder); err != nil {
+			return nil, err
+		}
+	}
 	// Use absolute file path.
 	file, err := filepath.Abs(file)
 	if err != nil {
@@ -77,6 +119,7 @@ func newWatcher(file string, decoder *runtimevar.Decoder, opts *Options) (*watch
 	// result being passed back via closeCh.
 	ctx, cancel := context.WithCancel(context.Background())
 	w := &watcher{
+		file: file,
 		// See struct comments for why it's buffered.
 		ch:       make(chan *state, 1),
 		closeCh:  make(chan error),
@@ -102,8 +145,24 @@ func (s *state) UpdateTime() time.Time {
 	return s.updateTime
 }
 
+// Revision implements driver.Revisioner.
+func (s *state) Revision() string {
+	if s.err != nil {
+		return ""
+	}
+	return revision(s.raw)
+}
+
+// revision returns the revision of a file with content b.
+func revision(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
 // watcher implements driver.Watcher for configurations stored in files.
 type watcher struct {
+	// file is the absolute path of the file.
+	file string
 	// The background goroutine writes new *state values to ch.
 	// It is buffered so that the background goroutine can write without
 	// blocking; it always drains the buffer before writing so that the latest
@@ -216,6 +275,12 @@ func (w *watcher) watch(ctx context.Context, notifier *fsnotify.Watcher, file st
 				if event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Remove|fsnotify.Rename) == 0 {
 					continue
 				}
+				if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+					// The file was removed, or replaced by renaming
+					// another file over it, which ends the watch on it.
+					notifier.Remove(file)
+					addedToNotifier = false
+				}
 				wait = false
 
 			case err := <-notifier.Errors:
@@ -230,6 +295,111 @@ type Options struct {
 	// WaitDuration controls the frequency of retries after an error. For example,
 	// if the file does not exist. Defaults to 30 seconds.
 	WaitDuration time.Duration
+	// DecodeByExtension chooses the decoding function from the file's
+	// extension: ".json", ".yaml" or ".yml", ".toml", ".gob", or ".txt" for
+	// strings. The type to decode into and the validation function are
+	// still taken from the decoder argument; if it is nil, JSON, YAML and
+	// TOML files are decoded into a map[string]interface{}, and other files
+	// into their declared type.
+	DecodeByExtension bool
+}
+
+// decoderForExtension returns a Decoder for file, based on its extension,
+// that decodes into the type of decoder, if it is not nil.
+func decoderForExtension(file string, decoder *runtimevar.Decoder) (*runtimevar.Decoder, error) {
+	var fn runtimevar.Decode
+	switch ext := strings.ToLower(filepath.Ext(file)); ext {
+	case ".json":
+		fn = runtimevar.JSONDecode
+	case ".yaml", ".yml":
+		fn = runtimevar.YAMLDecode
+	case ".toml":
+		fn = runtimevar.TOMLDecode
+	case ".gob":
+		if decoder == nil {
+			return nil, fmt.Errorf("filevar: a decoder with a type is required for %s", file)
+		}
+		fn = runtimevar.GobDecode
+	case ".txt":
+		if decoder == nil {
+			return runtimevar.StringDecoder, nil
+		}
+		return decoder, nil
+	default:
+		return nil, fmt.Errorf("filevar: no decoder for extension %q of %s", ext, file)
+	}
+	if decoder == nil {
+		return runtimevar.NewDecoder(map[string]interface{}{}, fn), nil
+	}
+	return &runtimevar.Decoder{Type: decoder.Type, Func: fn, Validate: decoder.Validate}, nil
+}
+
+// Set implements driver.Updater. The file is replaced by renaming a
+// temporary file in the same directory over it, keeping the file's
+// permissions, or using 0644 for a new file. If rev is not empty, the file's
+// content is compared with rev before writing, which is not atomic with
+// respect to other processes writing the file.
+func (w *watcher) Set(ctx context.Context, val []byte, rev string) error {
+	if err := w.checkRevision(rev); err != nil {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(w.file); err == nil {
+		mode = fi.Mode().Perm()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(w.file), "."+filepath.Base(w.file)+".tmp")
+	if err != nil {
+		return err
+	}
+	// TempFile creates the file with mode 0600.
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if _, err := f.Write(val); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), w.file); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// Delete implements driver.Updater, with the same caveat about rev as Set.
+func (w *watcher) Delete(ctx context.Context, rev string) error {
+	if err := w.checkRevision(rev); err != nil {
+		return err
+	}
+	return os.Remove(w.file)
+}
+
+// checkRevision returns driver.ErrRevisionMismatch if rev is not empty and
+// is not the revision of the file.
+func (w *watcher) checkRevision(rev string) error {
+	if rev == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(w.file)
+	if os.IsNotExist(err) {
+		return driver.ErrRevisionMismatch
+	}
+	if err != nil {
+		return err
+	}
+	if revision(b) != rev {
+		return driver.ErrRevisionMismatch
+	}
+	return nil
 }
 
 // Close implements driver.WatchVariable.
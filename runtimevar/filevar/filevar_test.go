@@ -25,6 +25,7 @@ import (
 	"github.com/google/go-cloud/runtimevar"
 	"github.com/google/go-cloud/runtimevar/driver"
 	"github.com/google/go-cloud/runtimevar/drivertest"
+	"github.com/google/go-cmp/cmp"
 )
 
 type harness struct {
@@ -82,3 +83,240 @@ func (h *harness) Mutable() bool { return true }
 func TestConformance(t *testing.T) {
 	drivertest.RunConformanceTests(t, newHarness)
 }
+
+func TestOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filevar_test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(`{"a": "b"}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	v, err := runtimevar.Open(ctx, "file://"+filepath.ToSlash(path)+"?decoder=json&wait=1ms", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	snap, err := v.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := snap.Value.(map[string]interface{})["a"]; got != "b" {
+		t.Errorf("got %v want %q", got, "b")
+	}
+}
+
+func TestDecodeByExtension(t *testing.T) {
+	type Config struct {
+		Name string `json:"name" toml:"name"`
+	}
+	dir, err := ioutil.TempDir("", "filevar_test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		file    string
+		content string
+		decoder *runtimevar.Decoder
+		want    interface{}
+		wantErr bool
+	}{
+		{file: "config.json", content: `{"name": "a"}`, want: map[string]interface{}{"name": "a"}},
+		{file: "config.yaml", content: "name: a\n", want: map[string]interface{}{"name": "a"}},
+		{file: "config.YML", content: "name: a\n", decoder: runtimevar.NewDecoder(Config{}, nil), want: Config{Name: "a"}},
+		{file: "config.toml", content: `name = "a"`, decoder: runtimevar.NewDecoder(Config{}, nil), want: Config{Name: "a"}},
+		{file: "config.txt", content: "a", want: "a"},
+		{file: "config.gob", wantErr: true},
+		{file: "config.xml", wantErr: true},
+	} {
+		t.Run(tc.file, func(t *testing.T) {
+			path := filepath.Join(dir, tc.file)
+			if err := ioutil.WriteFile(path, []byte(tc.content), 0666); err != nil {
+				t.Fatal(err)
+			}
+			v, err := New(path, tc.decoder, &Options{DecodeByExtension: true})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer v.Close()
+			snap, err := v.Watch(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(snap.Value, tc.want); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+
+	// With Open, the default decoder is replaced.
+	path := filepath.Join(dir, "config.yaml")
+	v, err := runtimevar.Open(ctx, "file://"+filepath.ToSlash(path)+"?decode_by_ext=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	snap, err := v.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(snap.Value, map[string]interface{}{"name": "a"}); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filevar_test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(`{"port": 80}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	validate, err := runtimevar.JSONSchemaValidator([]byte(`{"required": ["port"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	v, err := New(path, runtimevar.NewDecoder(map[string]interface{}{}, runtimevar.JSONDecode, validate), &Options{WaitDuration: 1 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	want := map[string]interface{}{"port": 80.0}
+	snap, err := v.Latest(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(snap.Value, want); diff != "" {
+		t.Error(diff)
+	}
+
+	// A typo is reported, and the last valid value is kept.
+	if err := ioutil.WriteFile(path, []byte(`{"prot": 80}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; v.LastError() == nil; i++ {
+		if i == 1000 {
+			t.Fatal("invalid value was not reported")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := v.LastError().(*runtimevar.ValidationError); !ok {
+		t.Errorf("got error %v, want a *runtimevar.ValidationError", v.LastError())
+	}
+	snap, err = v.Latest(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(snap.Value, want); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filevar_test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	v, err := New(filepath.Join(dir, "config.txt"), runtimevar.StringDecoder, &Options{WaitDuration: 1 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+
+	// A revision can't match a file that doesn't exist.
+	if err := v.Set(ctx, []byte("hello"), "bogus"); err != runtimevar.ErrRevisionMismatch {
+		t.Errorf("got %v want %v", err, runtimevar.ErrRevisionMismatch)
+	}
+	if err := v.Set(ctx, []byte("hello"), ""); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := v.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Value != "hello" || snap.Revision == "" {
+		t.Fatalf("got %v with revision %q, want %q with a revision", snap.Value, snap.Revision, "hello")
+	}
+
+	// Compare-and-swap.
+	if err := v.Set(ctx, []byte("world"), snap.Revision); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Set(ctx, []byte("lost"), snap.Revision); err != runtimevar.ErrRevisionMismatch {
+		t.Errorf("got %v want %v", err, runtimevar.ErrRevisionMismatch)
+	}
+	snap2, err := v.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap2.Value != "world" || snap2.Revision == snap.Revision {
+		t.Errorf("got %v with revision %q, want %q with a new revision", snap2.Value, snap2.Revision, "world")
+	}
+
+	if err := v.Delete(ctx, snap.Revision); err != runtimevar.ErrRevisionMismatch {
+		t.Errorf("got %v want %v", err, runtimevar.ErrRevisionMismatch)
+	}
+	if err := v.Delete(ctx, snap2.Revision); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Watch(ctx); err == nil {
+		t.Error("got nil error after Delete, want error")
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("got %d files left in dir, want 0", len(files))
+	}
+}
+
+func TestSetKeepsMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filevar_test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	path := filepath.Join(dir, "config.txt")
+	w, err := newWatcher(path, runtimevar.StringDecoder, &Options{WaitDuration: 1 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	checkMode := func(want os.FileMode) {
+		t.Helper()
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fi.Mode().Perm(); got != want {
+			t.Errorf("got mode %v want %v", got, want)
+		}
+	}
+	// A new file is readable by everyone.
+	if err := w.Set(ctx, []byte("hello"), ""); err != nil {
+		t.Fatal(err)
+	}
+	checkMode(0644)
+	// An existing file keeps its mode.
+	if err := os.Chmod(path, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Set(ctx, []byte("world"), ""); err != nil {
+		t.Fatal(err)
+	}
+	checkMode(0640)
+}
@@ -0,0 +1,235 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package httpvar provides a runtimevar.Driver implementation that reads
+// variables from an HTTP endpoint.
+//
+// The URL is polled with GET requests. When the server provides an ETag or
+// Last-Modified header, requests are conditional, so the content is only
+// downloaded again when it changes. Responses with a status code other than
+// 2xx are reported as errors, and polling backs off while they continue.
+//
+// For runtimevar.Open URLs, httpvar registers for the "http" and "https"
+// schemes, and uses http.DefaultClient. The "wait" query parameter sets
+// Options.WaitDuration and is removed from the URL; other query parameters
+// are sent to the server.
+// Example URL: runtimevar.Open(ctx, "https://config.example.com/myapp.json?decoder=json", nil)
+package httpvar
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/go-cloud/runtimevar"
+	"github.com/google/go-cloud/runtimevar/driver"
+)
+
+func init() {
+	opener := func(_ context.Context, u *url.URL, opts *runtimevar.URLOptions) (driver.Watcher, error) {
+		return newWatcher(u.String(), http.DefaultClient, opts.Decoder, &Options{WaitDuration: opts.WaitDuration}), nil
+	}
+	runtimevar.Register("http", opener)
+	runtimevar.Register("https", opener)
+}
+
+// New constructs a runtimevar.Variable object that polls url using client.
+// If client is nil, http.DefaultClient is used. Provide a decoder to
+// unmarshal the response bodies into similar objects during the Watch call.
+func New(url string, client *http.Client, decoder *runtimevar.Decoder, opts *Options) (*runtimevar.Variable, error) {
+	return runtimevar.New(newWatcher(url, client, decoder, opts)), nil
+}
+
+func newWatcher(url string, client *http.Client, decoder *runtimevar.Decoder, opts *Options) *watcher {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if opts == nil {
+		opts = &Options{}
+	}
+	wait := driver.WaitDuration(opts.WaitDuration)
+	maxWait := opts.MaxErrorWait
+	if maxWait <= 0 {
+		maxWait = 10 * wait
+	}
+	return &watcher{
+		url:     url,
+		client:  client,
+		wait:    wait,
+		maxWait: maxWait,
+		decoder: decoder,
+	}
+}
+
+// Options sets options.
+type Options struct {
+	// WaitDuration controls how quickly Watch polls. Defaults to 30 seconds.
+	WaitDuration time.Duration
+	// MaxErrorWait limits how long Watch waits between polls while requests
+	// are failing; the wait doubles after each failure, starting from
+	// WaitDuration. Defaults to 10 times WaitDuration.
+	MaxErrorWait time.Duration
+}
+
+// StatusError is returned as the error of a variable when the server
+// responds with a status code other than 2xx.
+type StatusError struct {
+	// URL is the URL that was requested.
+	URL string
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("httpvar: %s: %s", e.URL, http.StatusText(e.StatusCode))
+}
+
+// state implements driver.State.
+type state struct {
+	val        interface{}
+	updateTime time.Time
+	raw        []byte
+	err        error
+}
+
+func (s *state) Value() (interface{}, error) {
+	return s.val, s.err
+}
+
+func (s *state) UpdateTime() time.Time {
+	return s.updateTime
+}
+
+// errorState returns a new State with err, unless prevS also represents
+// the same error, in which case it returns nil.
+func errorState(err error, prevS driver.State) driver.State {
+	s := &state{err: err}
+	if prevS == nil {
+		return s
+	}
+	prev := prevS.(*state)
+	if prev.err == nil {
+		// New error.
+		return s
+	}
+	if err == prev.err || err.Error() == prev.err.Error() {
+		return nil
+	}
+	return s
+}
+
+// watcher implements driver.Watcher for variables fetched over HTTP.
+type watcher struct {
+	url     string
+	client  *http.Client
+	wait    time.Duration
+	maxWait time.Duration
+	decoder *runtimevar.Decoder
+	// errWait is the wait after the last failed request, or zero if the
+	// last request succeeded.
+	errWait time.Duration
+	// etag and lastModified are the latest validators the server sent for
+	// the last value that was returned, for conditional requests. They are
+	// updated by responses that don't change the value.
+	etag         string
+	lastModified string
+}
+
+// WatchVariable implements driver.WatchVariable.
+func (w *watcher) WatchVariable(ctx context.Context, prev driver.State) (driver.State, time.Duration) {
+	s, err := w.fetch(ctx, prev)
+	if err != nil {
+		w.errWait *= 2
+		if w.errWait == 0 {
+			w.errWait = w.wait
+		}
+		if w.errWait > w.maxWait {
+			w.errWait = w.maxWait
+		}
+		return errorState(err, prev), w.errWait
+	}
+	w.errWait = 0
+	if s == nil {
+		// No change.
+		return nil, w.wait
+	}
+	return s, w.wait
+}
+
+// fetch requests the variable, conditionally on it having changed since
+// prev. It returns a nil State if it hasn't changed.
+func (w *watcher) fetch(ctx context.Context, prev driver.State) (*state, error) {
+	req, err := http.NewRequest("GET", w.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if prev != nil && prev.(*state).err == nil {
+		if w.etag != "" {
+			req.Header.Set("If-None-Match", w.etag)
+		}
+		if w.lastModified != "" {
+			req.Header.Set("If-Modified-Since", w.lastModified)
+		}
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		// The server may send new validators with a 304.
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			w.etag = etag
+		}
+		if lm := resp.Header.Get("Last-Modified"); lm != "" {
+			w.lastModified = lm
+		}
+		return nil, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &StatusError{URL: w.url, StatusCode: resp.StatusCode}
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if prev != nil && prev.(*state).err == nil && bytes.Equal(b, prev.(*state).raw) {
+		// The server doesn't support conditional requests, or its
+		// validators changed, but the content is the same.
+		w.etag = resp.Header.Get("ETag")
+		w.lastModified = resp.Header.Get("Last-Modified")
+		return nil, nil
+	}
+	val, err := w.decoder.Decode(b)
+	if err != nil {
+		return nil, err
+	}
+	updateTime := time.Now()
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		updateTime = t
+	}
+	w.etag = resp.Header.Get("ETag")
+	w.lastModified = resp.Header.Get("Last-Modified")
+	return &state{val: val, updateTime: updateTime, raw: b}, nil
+}
+
+// Close implements driver.Close.
+func (w *watcher) Close() error {
+	return nil
+}
@@ -0,0 +1,186 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpvar
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cloud/runtimevar"
+	"github.com/google/go-cloud/runtimevar/driver"
+	"github.com/google/go-cloud/runtimevar/drivertest"
+)
+
+// fakeServer serves variables, with a version number as their ETag.
+type fakeServer struct {
+	mu      sync.Mutex
+	vars    map[string][]byte
+	version int
+	etags   map[string]string
+	// status, if not zero, is returned for all requests.
+	status int
+	// full and notModified count the responses of each kind.
+	full, notModified int
+}
+
+func newFakeServer() *fakeServer {
+	return &fakeServer{vars: map[string][]byte{}, etags: map[string]string{}}
+}
+
+func (s *fakeServer) set(name string, val []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+	s.vars[name] = val
+	s.etags[name] = fmt.Sprintf(`"%d"`, s.version)
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	val, ok := s.vars[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	etag := s.etags[name]
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		s.notModified++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	s.full++
+	w.Write(val)
+}
+
+type harness struct {
+	srv *fakeServer
+	ts  *httptest.Server
+}
+
+func newHarness(t *testing.T) (drivertest.Harness, error) {
+	srv := newFakeServer()
+	return &harness{srv: srv, ts: httptest.NewServer(srv)}, nil
+}
+
+func (h *harness) MakeWatcher(ctx context.Context, name string, decoder *runtimevar.Decoder) (driver.Watcher, error) {
+	// Poll quickly for tests.
+	return newWatcher(h.ts.URL+"/"+name, h.ts.Client(), decoder, &Options{WaitDuration: 1 * time.Millisecond}), nil
+}
+
+func (h *harness) CreateVariable(ctx context.Context, name string, val []byte) error {
+	h.srv.set(name, val)
+	return nil
+}
+
+func (h *harness) UpdateVariable(ctx context.Context, name string, val []byte) error {
+	h.srv.set(name, val)
+	return nil
+}
+
+func (h *harness) DeleteVariable(ctx context.Context, name string) error {
+	h.srv.mu.Lock()
+	defer h.srv.mu.Unlock()
+	delete(h.srv.vars, name)
+	return nil
+}
+
+func (h *harness) Close() {
+	h.ts.Close()
+}
+
+func (h *harness) Mutable() bool { return true }
+
+func TestConformance(t *testing.T) {
+	drivertest.RunConformanceTests(t, newHarness)
+}
+
+func TestConditionalRequests(t *testing.T) {
+	ctx := context.Background()
+	srv := newFakeServer()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	srv.set("v", []byte("hello"))
+
+	w := newWatcher(ts.URL+"/v", nil, runtimevar.StringDecoder, nil)
+	s, _ := w.WatchVariable(ctx, nil)
+	if v, err := s.Value(); err != nil || v != "hello" {
+		t.Fatalf("got %v, %v want %q", v, err, "hello")
+	}
+	for i := 0; i < 3; i++ {
+		if got, _ := w.WatchVariable(ctx, s); got != nil {
+			t.Fatalf("got %v for an unchanged variable, want nil", got)
+		}
+	}
+	if srv.full != 1 || srv.notModified != 3 {
+		t.Errorf("got %d full and %d not modified responses, want 1 and 3", srv.full, srv.notModified)
+	}
+
+	// Rewriting the same content changes the ETag but not the value; the new
+	// ETag is used for the following requests.
+	srv.set("v", []byte("hello"))
+	for i := 0; i < 3; i++ {
+		if got, _ := w.WatchVariable(ctx, s); got != nil {
+			t.Fatalf("got %v for an unchanged variable, want nil", got)
+		}
+	}
+	if srv.full != 2 || srv.notModified != 5 {
+		t.Errorf("got %d full and %d not modified responses, want 2 and 5", srv.full, srv.notModified)
+	}
+}
+
+func TestErrorBackoff(t *testing.T) {
+	ctx := context.Background()
+	srv := newFakeServer()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	srv.set("v", []byte("hello"))
+	srv.status = http.StatusServiceUnavailable
+
+	w := newWatcher(ts.URL+"/v", nil, runtimevar.StringDecoder, &Options{WaitDuration: time.Second, MaxErrorWait: 5 * time.Second})
+	var prev driver.State
+	for _, want := range []time.Duration{1, 2, 4, 5, 5} {
+		s, wait := w.WatchVariable(ctx, prev)
+		if wait != want*time.Second {
+			t.Errorf("got wait %v want %v", wait, want*time.Second)
+		}
+		if s != nil {
+			if _, err := s.Value(); err == nil {
+				t.Fatal("got nil error, want error")
+			} else if e, ok := err.(*StatusError); !ok || e.StatusCode != http.StatusServiceUnavailable {
+				t.Errorf("got %v, want a 503 *StatusError", err)
+			}
+			prev = s
+		}
+	}
+
+	// The wait is reset after a success.
+	srv.status = 0
+	if _, wait := w.WatchVariable(ctx, prev); wait != time.Second {
+		t.Errorf("got wait %v after success, want 1s", wait)
+	}
+}
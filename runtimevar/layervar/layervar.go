@@ -0,0 +1,260 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package layervar provides a runtimevar.Driver implementation that merges
+// the values of several other variables, such as defaults from a file,
+// overrides from etcd, and emergency overrides from an environment variable.
+//
+// Each layer's value must be an object: a map[string]interface{}, or a
+// struct or map that encodes to a JSON object. The layers are converted to
+// JSON objects and deep-merged in order, so later layers take precedence:
+// nested objects are merged key by key, and any other value replaces the
+// value from earlier layers. A new merged value is produced whenever any
+// layer changes. A layer that fails to read keeps its last good value.
+//
+// A struct sets every field that isn't tagged omitempty, including fields
+// with zero values, so a struct-valued layer replaces all of those fields
+// from earlier layers and is listed as their source in Merged.Sources. Use
+// structs for the defaults layer; for layers that override only some keys,
+// decode into a map[string]interface{}, or tag every field omitempty.
+package layervar
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/google/go-cloud/runtimevar"
+	"github.com/google/go-cloud/runtimevar/driver"
+)
+
+// Layer is one of the variables merged by New.
+type Layer struct {
+	// Name identifies the layer in Merged.Sources.
+	Name string
+	// Variable is watched for the layer's value, using Variable.OnChange.
+	// See the package documentation for how struct values are merged.
+	Variable *runtimevar.Variable
+	// Optional layers are left out of the merged value until they have a
+	// value. The merged value isn't produced until all other layers have
+	// one.
+	Optional bool
+}
+
+// Merged is the Snapshot.Value of variables created by New.
+type Merged struct {
+	// Value is the merged value, decoded with the decoder passed to New.
+	Value interface{}
+	// Sources maps each top-level key of the merged value to the Name of
+	// the last layer that set it. Parts of a nested object may have come
+	// from earlier layers.
+	Sources map[string]string
+}
+
+// New constructs a runtimevar.Variable that merges the values of layers, in
+// order of increasing precedence. The merged value is encoded as JSON and
+// decoded with decoder; if decoder is nil, it is decoded into a
+// map[string]interface{}.
+//
+// The returned Variable owns the layers' Variables, and closes them when it
+// is closed.
+func New(layers []Layer, decoder *runtimevar.Decoder) (*runtimevar.Variable, error) {
+	w, err := newWatcher(layers, decoder)
+	if err != nil {
+		return nil, err
+	}
+	return runtimevar.New(w), nil
+}
+
+func newWatcher(layers []Layer, decoder *runtimevar.Decoder) (*watcher, error) {
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("layervar: no layers")
+	}
+	seen := map[string]bool{}
+	for _, l := range layers {
+		if l.Variable == nil {
+			return nil, fmt.Errorf("layervar: layer %q has no Variable", l.Name)
+		}
+		if seen[l.Name] {
+			return nil, fmt.Errorf("layervar: duplicate layer name %q", l.Name)
+		}
+		seen[l.Name] = true
+	}
+	if decoder == nil {
+		decoder = runtimevar.NewDecoder(map[string]interface{}{}, runtimevar.JSONDecode)
+	}
+	w := &watcher{
+		layers:  layers,
+		decoder: decoder,
+		// See struct comments for why it's buffered.
+		changed: make(chan struct{}, 1),
+		snaps:   make([]*runtimevar.Snapshot, len(layers)),
+	}
+	for i, l := range layers {
+		i := i
+		l.Variable.OnChange(func(snap runtimevar.Snapshot) {
+			w.mu.Lock()
+			w.snaps[i] = &snap
+			w.mu.Unlock()
+			select {
+			case w.changed <- struct{}{}:
+			default:
+			}
+		})
+	}
+	return w, nil
+}
+
+// state implements driver.State.
+type state struct {
+	val        *Merged
+	updateTime time.Time
+	// raw is the JSON encoding of the merged value.
+	raw []byte
+	err error
+}
+
+func (s *state) Value() (interface{}, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.val, nil
+}
+
+func (s *state) UpdateTime() time.Time {
+	return s.updateTime
+}
+
+// equal reports whether s and t hold the same value or the same error.
+func (s *state) equal(t *state) bool {
+	if s.err != nil || t.err != nil {
+		return s.err != nil && t.err != nil && s.err.Error() == t.err.Error()
+	}
+	return string(s.raw) == string(t.raw) && reflect.DeepEqual(s.val.Sources, t.val.Sources)
+}
+
+// watcher implements driver.Watcher by merging layers.
+type watcher struct {
+	layers  []Layer
+	decoder *runtimevar.Decoder
+	// changed is written to by the layers' OnChange callbacks. It is
+	// buffered and written to without blocking, so that a change that
+	// happens while WatchVariable isn't waiting is not lost.
+	changed chan struct{}
+
+	mu sync.Mutex
+	// snaps holds the latest snapshot of each layer, or nil if the layer
+	// has no value yet. Protected by mu.
+	snaps []*runtimevar.Snapshot
+}
+
+// WatchVariable implements driver.WatchVariable.
+func (w *watcher) WatchVariable(ctx context.Context, prev driver.State) (driver.State, time.Duration) {
+	for {
+		if cur := w.merge(); cur != nil && (prev == nil || !cur.equal(prev.(*state))) {
+			return cur, 0
+		}
+		select {
+		case <-ctx.Done():
+			return &state{err: ctx.Err()}, 0
+		case <-w.changed:
+		}
+	}
+}
+
+// merge returns the merged state of the layers, or nil if a required layer
+// has no value yet.
+func (w *watcher) merge() *state {
+	w.mu.Lock()
+	snaps := append([]*runtimevar.Snapshot(nil), w.snaps...)
+	w.mu.Unlock()
+
+	merged := map[string]interface{}{}
+	sources := map[string]string{}
+	var updateTime time.Time
+	for i, snap := range snaps {
+		l := w.layers[i]
+		if snap == nil {
+			if l.Optional {
+				continue
+			}
+			return nil
+		}
+		m, err := toObject(snap.Value)
+		if err != nil {
+			return &state{err: fmt.Errorf("layervar: layer %q: %v", l.Name, err)}
+		}
+		mergeObjects(merged, m)
+		for k := range m {
+			sources[k] = l.Name
+		}
+		if snap.UpdateTime.After(updateTime) {
+			updateTime = snap.UpdateTime
+		}
+	}
+	raw, err := json.Marshal(merged)
+	if err != nil {
+		return &state{err: fmt.Errorf("layervar: %v", err)}
+	}
+	val, err := w.decoder.Decode(raw)
+	if verr, ok := err.(*runtimevar.ValidationError); ok {
+		return &state{err: verr}
+	}
+	if err != nil {
+		return &state{err: fmt.Errorf("layervar: decoding merged value: %v", err)}
+	}
+	return &state{val: &Merged{Value: val, Sources: sources}, updateTime: updateTime, raw: raw}
+}
+
+// toObject converts v to a JSON object. The result is never shared with v,
+// so it can be modified.
+func toObject(v interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil || m == nil {
+		return nil, fmt.Errorf("value of type %T is not an object", v)
+	}
+	return m, nil
+}
+
+// mergeObjects deep-merges src into dst. Objects are merged key by key;
+// other values in src replace those in dst.
+func mergeObjects(dst, src map[string]interface{}) {
+	for k, sv := range src {
+		if sm, ok := sv.(map[string]interface{}); ok {
+			if dm, ok := dst[k].(map[string]interface{}); ok {
+				mergeObjects(dm, sm)
+				continue
+			}
+		}
+		dst[k] = sv
+	}
+}
+
+// Close implements driver.Close. It closes the layers' Variables.
+func (w *watcher) Close() error {
+	var firstErr error
+	for _, l := range w.layers {
+		if err := l.Variable.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,219 @@
+// Copyright 2018 The Go Cloud Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package layervar
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cloud/runtimevar"
+	"github.com/google/go-cloud/runtimevar/constantvar"
+	"github.com/google/go-cloud/runtimevar/driver"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeState implements driver.State.
+type fakeState struct {
+	val interface{}
+	err error
+}
+
+func (s *fakeState) Value() (interface{}, error) { return s.val, s.err }
+func (s *fakeState) UpdateTime() time.Time       { return time.Time{} }
+
+// chanWatcher is a driver.Watcher that returns the values sent on ch.
+type chanWatcher struct {
+	ch chan *fakeState
+}
+
+func (w *chanWatcher) WatchVariable(ctx context.Context, prev driver.State) (driver.State, time.Duration) {
+	select {
+	case <-ctx.Done():
+		return &fakeState{err: ctx.Err()}, 0
+	case s := <-w.ch:
+		return s, 0
+	}
+}
+
+func (w *chanWatcher) Close() error { return nil }
+
+func newChanVar() (*runtimevar.Variable, chan *fakeState) {
+	ch := make(chan *fakeState)
+	return runtimevar.New(&chanWatcher{ch: ch}), ch
+}
+
+type Config struct {
+	Name     string            `json:"name"`
+	Replicas int               `json:"replicas"`
+	Labels   map[string]string `json:"labels"`
+}
+
+func TestLayers(t *testing.T) {
+	ctx := context.Background()
+	defaults := constantvar.New(Config{Name: "app", Replicas: 1, Labels: map[string]string{"tier": "web", "env": "dev"}})
+	overrides, overridesCh := newChanVar()
+	emergency, emergencyCh := newChanVar()
+
+	v, err := New([]Layer{
+		{Name: "defaults", Variable: defaults},
+		{Name: "overrides", Variable: overrides},
+		{Name: "emergency", Variable: emergency, Optional: true},
+	}, runtimevar.NewDecoder(Config{}, runtimevar.JSONDecode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+
+	check := func(want Config, wantSources map[string]string) {
+		t.Helper()
+		snap, err := v.Watch(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := snap.Value.(*Merged)
+		if diff := cmp.Diff(got.Value, want); diff != "" {
+			t.Errorf("value diff:\n%s", diff)
+		}
+		if diff := cmp.Diff(got.Sources, wantSources); diff != "" {
+			t.Errorf("sources diff:\n%s", diff)
+		}
+	}
+
+	// The merged value waits for the required overrides layer, but not for
+	// the optional emergency layer.
+	overridesCh <- &fakeState{val: map[string]interface{}{"labels": map[string]interface{}{"env": "prod"}}}
+	check(
+		Config{Name: "app", Replicas: 1, Labels: map[string]string{"tier": "web", "env": "prod"}},
+		map[string]string{"name": "defaults", "replicas": "defaults", "labels": "overrides"},
+	)
+
+	emergencyCh <- &fakeState{val: map[string]interface{}{"replicas": 10}}
+	check(
+		Config{Name: "app", Replicas: 10, Labels: map[string]string{"tier": "web", "env": "prod"}},
+		map[string]string{"name": "defaults", "replicas": "emergency", "labels": "overrides"},
+	)
+
+	// An error in a layer keeps its last good value; a value that isn't an
+	// object is an error.
+	overridesCh <- &fakeState{err: context.DeadlineExceeded}
+	overridesCh <- &fakeState{val: "not an object"}
+	if _, err := v.Watch(ctx); err == nil {
+		t.Error("got nil error for a layer that isn't an object, want error")
+	}
+	overridesCh <- &fakeState{val: map[string]interface{}{"name": "renamed"}}
+	check(
+		Config{Name: "renamed", Replicas: 10, Labels: map[string]string{"tier": "web", "env": "dev"}},
+		map[string]string{"name": "overrides", "replicas": "emergency", "labels": "defaults"},
+	)
+}
+
+func TestStructLayers(t *testing.T) {
+	ctx := context.Background()
+	defaults := map[string]interface{}{"name": "app", "replicas": 3, "labels": map[string]interface{}{"tier": "web"}}
+	type sparseConfig struct {
+		Name     string            `json:"name,omitempty"`
+		Replicas int               `json:"replicas,omitempty"`
+		Labels   map[string]string `json:"labels,omitempty"`
+	}
+
+	for _, tc := range []struct {
+		description string
+		override    interface{}
+		want        Config
+		wantSources map[string]string
+	}{
+		{
+			// Every field of a struct is set, so the zero values replace the
+			// defaults.
+			description: "struct",
+			override:    Config{Name: "renamed"},
+			want:        Config{Name: "renamed"},
+			wantSources: map[string]string{"name": "overrides", "replicas": "overrides", "labels": "overrides"},
+		},
+		{
+			description: "struct with omitempty fields",
+			override:    sparseConfig{Name: "renamed"},
+			want:        Config{Name: "renamed", Replicas: 3, Labels: map[string]string{"tier": "web"}},
+			wantSources: map[string]string{"name": "overrides", "replicas": "defaults", "labels": "defaults"},
+		},
+		{
+			description: "map",
+			override:    map[string]interface{}{"name": "renamed"},
+			want:        Config{Name: "renamed", Replicas: 3, Labels: map[string]string{"tier": "web"}},
+			wantSources: map[string]string{"name": "overrides", "replicas": "defaults", "labels": "defaults"},
+		},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			v, err := New([]Layer{
+				{Name: "defaults", Variable: constantvar.New(defaults)},
+				{Name: "overrides", Variable: constantvar.New(tc.override)},
+			}, runtimevar.NewDecoder(Config{}, runtimevar.JSONDecode))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer v.Close()
+			snap, err := v.Watch(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := snap.Value.(*Merged)
+			if diff := cmp.Diff(got.Value, tc.want); diff != "" {
+				t.Errorf("value diff:\n%s", diff)
+			}
+			if diff := cmp.Diff(got.Sources, tc.wantSources); diff != "" {
+				t.Errorf("sources diff:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMergeObjects(t *testing.T) {
+	dst := map[string]interface{}{
+		"a": 1.0,
+		"b": map[string]interface{}{"x": 1.0, "y": 2.0},
+		"c": map[string]interface{}{"x": 1.0},
+		"d": []interface{}{1.0, 2.0},
+	}
+	mergeObjects(dst, map[string]interface{}{
+		"b": map[string]interface{}{"y": 3.0, "z": 4.0},
+		"c": "replaced",
+		"d": []interface{}{3.0},
+		"e": nil,
+	})
+	want := map[string]interface{}{
+		"a": 1.0,
+		"b": map[string]interface{}{"x": 1.0, "y": 3.0, "z": 4.0},
+		"c": "replaced",
+		"d": []interface{}{3.0},
+		"e": nil,
+	}
+	if diff := cmp.Diff(dst, want); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	v := constantvar.New(map[string]interface{}{})
+	for _, layers := range [][]Layer{
+		nil,
+		{{Name: "a"}},
+		{{Name: "a", Variable: v}, {Name: "a", Variable: v}},
+	} {
+		if _, err := New(layers, nil); err == nil {
+			t.Errorf("%v: got nil error, want error", layers)
+		}
+	}
+}
@@ -17,11 +17,29 @@
 //
 // Construct a Client, then use NewVariable to construct any number of
 // runtimevar.Variable objects.
+//
+// Variable.Set and Variable.Delete are supported, without compare-and-swap.
+// Revisions are parameter versions.
+//
+// For runtimevar.Open URLs, paramstore registers for the "paramstore"
+// scheme. The URL's Host followed by its Path is used as the parameter name,
+// so names that start with "/" can be written with an empty Host.
+// The AWS session is created as described in
+// https://docs.aws.amazon.com/sdk-for-go/api/aws/session/.
+// The following query parameters are supported:
+// - region: The AWS region for requests.
+// - wait: Sets Options.WaitDuration.
+// Example URLs:
+// -- paramstore://myvar?region=us-east-2
+// -- paramstore:///myapp/config reads the parameter "/myapp/config".
 package paramstore
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/google/go-cloud/runtimevar"
@@ -30,9 +48,27 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ssm"
 )
 
+func init() {
+	runtimevar.Register("paramstore", openURL)
+}
+
+// openURL implements runtimevar.FromURLFunc.
+func openURL(_ context.Context, u *url.URL, opts *runtimevar.URLOptions) (driver.Watcher, error) {
+	cfg := &aws.Config{}
+	if region := u.Query().Get("region"); region != "" {
+		cfg.Region = aws.String(region)
+	}
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(sess).newWatcher(u.Host+u.Path, opts.Decoder, &Options{WaitDuration: opts.WaitDuration})
+}
+
 // Client stores long-lived variables for connecting to Parameter Store.
 type Client struct {
 	sess client.ConfigProvider
@@ -87,6 +123,14 @@ func (s *state) UpdateTime() time.Time {
 	return s.updateTime
 }
 
+// Revision implements driver.Revisioner.
+func (s *state) Revision() string {
+	if s.err != nil {
+		return ""
+	}
+	return strconv.FormatInt(s.version, 10)
+}
+
 // errorState returns a new State with err, unless prevS also represents
 // the same error, in which case it returns nil.
 func errorState(err error, prevS driver.State) driver.State {
@@ -155,6 +199,36 @@ func (w *watcher) Close() error {
 	return nil
 }
 
+// errNoRevisions is returned by Set and Delete for a non-empty revision.
+var errNoRevisions = errors.New("paramstore: compare-and-swap is not supported")
+
+// Set implements driver.Updater. The parameter is created as a String
+// parameter, or overwritten if it exists. Parameter Store can't make the
+// change conditional, so rev must be empty.
+func (w *watcher) Set(ctx context.Context, val []byte, rev string) error {
+	if rev != "" {
+		return errNoRevisions
+	}
+	svc := ssm.New(w.sess)
+	_, err := svc.PutParameterWithContext(ctx, &ssm.PutParameterInput{
+		Name:      aws.String(w.name),
+		Type:      aws.String("String"),
+		Value:     aws.String(string(val)),
+		Overwrite: aws.Bool(true),
+	})
+	return err
+}
+
+// Delete implements driver.Updater. rev must be empty.
+func (w *watcher) Delete(ctx context.Context, rev string) error {
+	if rev != "" {
+		return errNoRevisions
+	}
+	svc := ssm.New(w.sess)
+	_, err := svc.DeleteParameterWithContext(ctx, &ssm.DeleteParameterInput{Name: aws.String(w.name)})
+	return err
+}
+
 type param struct {
 	name       string
 	value      string
@@ -16,15 +16,26 @@ package paramstore
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/google/go-cloud/internal/testing/setup"
 	"github.com/google/go-cloud/runtimevar"
 	"github.com/google/go-cloud/runtimevar/driver"
 	"github.com/google/go-cloud/runtimevar/drivertest"
+	"github.com/google/go-cmp/cmp"
 )
 
 // This constant records the region used for the last --record.
@@ -79,3 +90,135 @@ func (h *harness) Mutable() bool { return true }
 func TestConformance(t *testing.T) {
 	drivertest.RunConformanceTests(t, newHarness)
 }
+
+func TestOpenURL(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		url        string
+		wait       time.Duration
+		wantName   string
+		wantRegion string
+		wantWait   time.Duration
+	}{
+		{url: "paramstore://myvar?region=us-west-1", wantName: "myvar", wantRegion: "us-west-1", wantWait: driver.DefaultWaitDuration},
+		{url: "paramstore:///myapp/config?region=us-east-2", wait: 5 * time.Second, wantName: "/myapp/config", wantRegion: "us-east-2", wantWait: 5 * time.Second},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dw, err := openURL(ctx, u, &runtimevar.URLOptions{Decoder: runtimevar.StringDecoder, WaitDuration: test.wait})
+		if err != nil {
+			t.Fatalf("%s: %v", test.url, err)
+		}
+		w := dw.(*watcher)
+		if w.name != test.wantName {
+			t.Errorf("%s: got name %q want %q", test.url, w.name, test.wantName)
+		}
+		if got := aws.StringValue(w.sess.(*session.Session).Config.Region); got != test.wantRegion {
+			t.Errorf("%s: got region %q want %q", test.url, got, test.wantRegion)
+		}
+		if w.wait != test.wantWait {
+			t.Errorf("%s: got wait %v want %v", test.url, w.wait, test.wantWait)
+		}
+	}
+
+	v, err := runtimevar.Open(ctx, "paramstore:///myapp/config?region=us-east-2&wait=5s", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+// fakeSSM is an in-process Parameter Store that supports PutParameter and
+// DeleteParameter.
+type fakeSSM struct {
+	mu     sync.Mutex
+	params map[string]string
+	// ops records the operation of each request.
+	ops []string
+}
+
+func (s *fakeSSM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	op := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "AmazonSSM.")
+	s.ops = append(s.ops, op)
+	var req struct {
+		Name, Type, Value string
+		Overwrite         bool
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	switch {
+	case op == "PutParameter" && req.Type == "String" && req.Overwrite:
+		s.params[req.Name] = req.Value
+		fmt.Fprint(w, `{"Version": 1}`)
+	case op == "DeleteParameter":
+		if _, ok := s.params[req.Name]; !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type": "ParameterNotFound"}`)
+			return
+		}
+		delete(s.params, req.Name)
+		fmt.Fprint(w, `{}`)
+	default:
+		http.Error(w, "unsupported", http.StatusBadRequest)
+	}
+}
+
+func TestSetDelete(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeSSM{params: map[string]string{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	sess, err := session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("FAKE_ID", "FAKE_SECRET", ""),
+		Endpoint:    aws.String(srv.URL),
+		Region:      aws.String(region),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewClient(sess).newWatcher("/myapp/config", runtimevar.StringDecoder, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Parameter Store can't check revisions, so they are rejected before
+	// making a request.
+	if err := w.Set(ctx, []byte("hello"), "1"); err != errNoRevisions {
+		t.Errorf("Set: got %v want %v", err, errNoRevisions)
+	}
+	if err := w.Delete(ctx, "1"); err != errNoRevisions {
+		t.Errorf("Delete: got %v want %v", err, errNoRevisions)
+	}
+	if len(fake.ops) != 0 {
+		t.Errorf("got requests %v with a revision, want none", fake.ops)
+	}
+
+	if err := w.Set(ctx, []byte("hello"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.params["/myapp/config"]; got != "hello" {
+		t.Errorf("got parameter %q after Set, want %q", got, "hello")
+	}
+	if err := w.Delete(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.params["/myapp/config"]; ok {
+		t.Error("parameter still exists after Delete")
+	}
+	if err := w.Delete(ctx, ""); err == nil {
+		t.Error("got nil error deleting a missing parameter, want error")
+	}
+	if diff := cmp.Diff(fake.ops, []string{"PutParameter", "DeleteParameter", "DeleteParameter"}); diff != "" {
+		t.Errorf("got requests diff %s", diff)
+	}
+}
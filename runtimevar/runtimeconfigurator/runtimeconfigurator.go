@@ -17,12 +17,26 @@
 //
 // Construct a Client, then use NewVariable to construct any number of
 // runtimevar.Variable objects.
+//
+// Variable.Set and Variable.Delete are supported, without compare-and-swap.
+//
+// For runtimevar.Open URLs, runtimeconfigurator registers for the
+// "runtimeconfig" scheme. The URL's Host is the project ID, the first
+// segment of its Path is the config, and the rest of the Path is the
+// variable. Default credentials are used, as described in
+// https://cloud.google.com/docs/authentication/production; the connection is
+// closed when the Variable is closed. The "wait" query parameter sets
+// Options.WaitDuration.
+// Example URL: runtimevar.Open(ctx, "runtimeconfig://myproject/myconfig/myvar", nil)
 package runtimeconfigurator
 
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/golang/protobuf/ptypes"
@@ -37,6 +51,35 @@ import (
 	"google.golang.org/grpc/credentials/oauth"
 )
 
+func init() {
+	runtimevar.Register("runtimeconfig", openURL)
+}
+
+// openURL implements runtimevar.FromURLFunc.
+func openURL(ctx context.Context, u *url.URL, opts *runtimevar.URLOptions) (driver.Watcher, error) {
+	parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+	if u.Host == "" || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("runtimeconfig URL %q must have the form runtimeconfig://project/config/variable", u)
+	}
+	name := ResourceName{ProjectID: u.Host, Config: parts[0], Variable: parts[1]}
+	creds, err := gcp.DefaultCredentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stub, cleanup, err := Dial(ctx, gcp.CredentialsTokenSource(creds))
+	if err != nil {
+		return nil, err
+	}
+	return &watcher{
+		client:  stub,
+		wait:    driver.WaitDuration(opts.WaitDuration),
+		name:    name.String(),
+		parent:  name.configPath(),
+		decoder: opts.Decoder,
+		cleanup: cleanup,
+	}, nil
+}
+
 // Set is a Wire provider set that provides *Client using a default
 // connection to the Runtime Configurator API given a GCP token source.
 var Set = wire.NewSet(
@@ -93,6 +136,7 @@ func (c *Client) newWatcher(name ResourceName, decoder *runtimevar.Decoder, opts
 		client:  c.client,
 		wait:    driver.WaitDuration(opts.WaitDuration),
 		name:    name.String(),
+		parent:  name.configPath(),
 		decoder: decoder,
 	}, nil
 }
@@ -163,14 +207,59 @@ type watcher struct {
 	client  pb.RuntimeConfigManagerClient
 	wait    time.Duration
 	name    string
+	parent  string // the path of the config that contains the variable
 	decoder *runtimevar.Decoder
+	// cleanup, if not nil, closes the connection the watcher was opened
+	// with.
+	cleanup func()
 }
 
 // Close implements driver.Close.
 func (w *watcher) Close() error {
+	if w.cleanup != nil {
+		w.cleanup()
+	}
 	return nil
 }
 
+// errNoRevisions is returned by Set and Delete for a non-empty revision.
+var errNoRevisions = errors.New("runtimeconfigurator: compare-and-swap is not supported")
+
+// Set implements driver.Updater. The variable is updated, or created if it
+// doesn't exist. Runtime Configurator can't make the change conditional, so
+// rev must be empty.
+func (w *watcher) Set(ctx context.Context, val []byte, rev string) error {
+	if rev != "" {
+		return errNoRevisions
+	}
+	_, err := w.client.UpdateVariable(ctx, &pb.UpdateVariableRequest{
+		Name: w.name,
+		Variable: &pb.Variable{
+			Contents: &pb.Variable_Value{Value: val},
+		},
+	})
+	if grpc.Code(err) != codes.NotFound {
+		return err
+	}
+	_, err = w.client.CreateVariable(ctx, &pb.CreateVariableRequest{
+		Parent: w.parent,
+		Variable: &pb.Variable{
+			Name:     w.name,
+			Contents: &pb.Variable_Value{Value: val},
+		},
+	})
+	return err
+}
+
+// Delete implements driver.Updater. rev must be empty.
+func (w *watcher) Delete(ctx context.Context, rev string) error {
+	if rev != "" {
+		return errNoRevisions
+	}
+	_, err := w.client.DeleteVariable(ctx, &pb.DeleteVariableRequest{Name: w.name})
+	return err
+}
+
 // WatchVariable implements driver.WatchVariable.
 func (w *watcher) WatchVariable(ctx context.Context, prev driver.State) (driver.State, time.Duration) {
 	// Get the variable from the backend.
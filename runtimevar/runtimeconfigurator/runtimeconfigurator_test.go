@@ -16,13 +16,24 @@ package runtimeconfigurator
 
 import (
 	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/golang/protobuf/ptypes/empty"
 	"github.com/google/go-cloud/internal/testing/setup"
 	"github.com/google/go-cloud/runtimevar"
 	"github.com/google/go-cloud/runtimevar/driver"
 	"github.com/google/go-cloud/runtimevar/drivertest"
+	"github.com/google/go-cmp/cmp"
 	pb "google.golang.org/genproto/googleapis/cloud/runtimeconfig/v1beta1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // This constant records the project used for the last --record.
@@ -114,3 +125,145 @@ func (h *harness) Mutable() bool { return true }
 func TestConformance(t *testing.T) {
 	drivertest.RunConformanceTests(t, newHarness)
 }
+
+func TestOpenURL(t *testing.T) {
+	ctx := context.Background()
+	// Use fake default credentials; they are only used to make requests.
+	dir, err := ioutil.TempDir("", "runtimeconfigurator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	credsFile := filepath.Join(dir, "creds.json")
+	creds := `{"type": "service_account", "project_id": "fake", "client_email": "fake@fake.iam.gserviceaccount.com", "private_key": "fake"}`
+	if err := ioutil.WriteFile(credsFile, []byte(creds), 0666); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
+	os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", credsFile)
+
+	for _, bad := range []string{
+		"runtimeconfig:///myconfig/myvar",
+		"runtimeconfig://myproject/myconfig",
+		"runtimeconfig://myproject/myconfig/",
+		"runtimeconfig://myproject//myvar",
+	} {
+		u, err := url.Parse(bad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := openURL(ctx, u, &runtimevar.URLOptions{Decoder: runtimevar.StringDecoder}); err == nil {
+			t.Errorf("%s: got nil error, want error", bad)
+		}
+	}
+
+	u, err := url.Parse("runtimeconfig://myproject/myconfig/my/var")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dw, err := openURL(ctx, u, &runtimevar.URLOptions{Decoder: runtimevar.StringDecoder, WaitDuration: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dw.Close()
+	w := dw.(*watcher)
+	if want := "projects/myproject/configs/myconfig/variables/my/var"; w.name != want {
+		t.Errorf("got name %q want %q", w.name, want)
+	}
+	if want := "projects/myproject/configs/myconfig"; w.parent != want {
+		t.Errorf("got parent %q want %q", w.parent, want)
+	}
+	if w.wait != 5*time.Second {
+		t.Errorf("got wait %v want %v", w.wait, 5*time.Second)
+	}
+
+	v, err := runtimevar.Open(ctx, "runtimeconfig://myproject/myconfig/myvar?wait=5s", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+// fakeClient is a pb.RuntimeConfigManagerClient that stores variable values
+// in a map. Calls to methods it doesn't implement panic.
+type fakeClient struct {
+	pb.RuntimeConfigManagerClient
+	vars map[string][]byte
+	// calls records the method name of each call.
+	calls []string
+}
+
+func (c *fakeClient) UpdateVariable(ctx context.Context, req *pb.UpdateVariableRequest, opts ...grpc.CallOption) (*pb.Variable, error) {
+	c.calls = append(c.calls, "UpdateVariable")
+	if _, ok := c.vars[req.Name]; !ok {
+		return nil, status.Error(codes.NotFound, "variable not found")
+	}
+	c.vars[req.Name] = req.Variable.GetValue()
+	return req.Variable, nil
+}
+
+func (c *fakeClient) CreateVariable(ctx context.Context, req *pb.CreateVariableRequest, opts ...grpc.CallOption) (*pb.Variable, error) {
+	c.calls = append(c.calls, "CreateVariable")
+	if !strings.HasPrefix(req.Variable.Name, req.Parent+"/") {
+		return nil, status.Errorf(codes.InvalidArgument, "variable %q is not in config %q", req.Variable.Name, req.Parent)
+	}
+	c.vars[req.Variable.Name] = req.Variable.GetValue()
+	return req.Variable, nil
+}
+
+func (c *fakeClient) DeleteVariable(ctx context.Context, req *pb.DeleteVariableRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	c.calls = append(c.calls, "DeleteVariable")
+	if _, ok := c.vars[req.Name]; !ok {
+		return nil, status.Error(codes.NotFound, "variable not found")
+	}
+	delete(c.vars, req.Name)
+	return &empty.Empty{}, nil
+}
+
+func TestSetDelete(t *testing.T) {
+	ctx := context.Background()
+	const (
+		parent = "projects/p/configs/c"
+		name   = parent + "/variables/v"
+	)
+	fake := &fakeClient{vars: map[string][]byte{}}
+	w := &watcher{client: fake, name: name, parent: parent, decoder: runtimevar.StringDecoder}
+
+	// Runtime Configurator can't check revisions, so they are rejected
+	// before making a call.
+	if err := w.Set(ctx, []byte("hello"), "1"); err != errNoRevisions {
+		t.Errorf("Set: got %v want %v", err, errNoRevisions)
+	}
+	if err := w.Delete(ctx, "1"); err != errNoRevisions {
+		t.Errorf("Delete: got %v want %v", err, errNoRevisions)
+	}
+	if len(fake.calls) != 0 {
+		t.Errorf("got calls %v with a revision, want none", fake.calls)
+	}
+
+	// The first Set creates the variable; the second updates it.
+	if err := w.Set(ctx, []byte("hello"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Set(ctx, []byte("world"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(fake.vars[name]); got != "world" {
+		t.Errorf("got variable %q after Set, want %q", got, "world")
+	}
+	if err := w.Delete(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.vars[name]; ok {
+		t.Error("variable still exists after Delete")
+	}
+	if err := w.Delete(ctx, ""); grpc.Code(err) != codes.NotFound {
+		t.Errorf("got error %v deleting a missing variable, want NotFound", err)
+	}
+	want := []string{"UpdateVariable", "CreateVariable", "UpdateVariable", "DeleteVariable", "DeleteVariable"}
+	if diff := cmp.Diff(fake.calls, want); diff != "" {
+		t.Errorf("got calls diff %s", diff)
+	}
+}
@@ -21,11 +21,20 @@ import (
 	"context"
 	"encoding/gob"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
 	"reflect"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/BurntSushi/toml"
+	"github.com/ghodss/yaml"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
 	"github.com/google/go-cloud/runtimevar/driver"
+	"github.com/xeipuuv/gojsonschema"
 )
 
 // Snapshot contains a variable and metadata about it.
@@ -39,18 +48,44 @@ type Snapshot struct {
 
 	// UpdateTime is the time when the last changed was detected.
 	UpdateTime time.Time
+
+	// Revision identifies the version of the variable that Value was read
+	// from, for compare-and-swap with Variable.Set and Variable.Delete. It is
+	// empty if the provider doesn't support revisions.
+	Revision string
 }
 
 // Variable provides the ability to read runtime variables with its blocking Watch method.
+//
+// Alternatively, Latest and OnChange start watching the variable in a
+// background goroutine, and are safe to call from multiple goroutines. Watch
+// can't be used once they have been called.
 type Variable struct {
 	watcher  driver.Watcher
 	nextCall time.Time
 	prev     driver.State
+
+	// cbMu is held while calling OnChange callbacks, so that each callback
+	// sees the values in order.
+	cbMu sync.Mutex
+	// The following fields are protected by mu:
+	mu        sync.Mutex
+	bgStarted bool
+	bgCancel  func()
+	bgDone    chan struct{}
+	closed    bool
+	// closing is closed by Close, to wake up calls to Latest.
+	closing chan struct{}
+	// haveGood is closed when latest is first set.
+	haveGood  chan struct{}
+	latest    Snapshot
+	lastErr   error // the error from the last background watch, if it failed
+	callbacks []func(Snapshot)
 }
 
 // New constructs a Variable object given a driver.Watcher implementation.
 func New(w driver.Watcher) *Variable {
-	return &Variable{watcher: w}
+	return &Variable{watcher: w, closing: make(chan struct{}), haveGood: make(chan struct{})}
 }
 
 // Watch blocks until there are variable changes, the Context's Done channel
@@ -60,6 +95,8 @@ func New(w driver.Watcher) *Variable {
 // updated value.
 //
 // If method returns an error, the returned Snapshot object is a zero value and cannot be used.
+// If the new value was rejected by the Decoder's Validate function, the error
+// is a *ValidationError.
 //
 // The first call to this method should return the current variable unless there are errors in
 // retrieving the value.
@@ -70,6 +107,16 @@ func New(w driver.Watcher) *Variable {
 // To stop this function from blocking, caller can passed in Context object constructed via
 // WithCancel and call the cancel function.
 func (c *Variable) Watch(ctx context.Context) (Snapshot, error) {
+	c.mu.Lock()
+	bg := c.bgStarted
+	c.mu.Unlock()
+	if bg {
+		return Snapshot{}, errors.New("Variable.Watch: can't be called after Latest or OnChange")
+	}
+	return c.watch(ctx)
+}
+
+func (c *Variable) watch(ctx context.Context) (Snapshot, error) {
 	for {
 		wait := c.nextCall.Sub(time.Now())
 		if wait > 0 {
@@ -91,18 +138,330 @@ func (c *Variable) Watch(ctx context.Context) (Snapshot, error) {
 		c.prev = cur
 		v, err := cur.Value()
 		if err != nil {
-			// Mask underlying errors.
+			// Values rejected by the Decoder are reported as they are, so
+			// callers can tell them apart; other errors are masked.
+			if verr, ok := err.(*ValidationError); ok {
+				return Snapshot{}, verr
+			}
 			return Snapshot{}, fmt.Errorf("Variable.Watch: %v", err)
 		}
-		return Snapshot{Value: v, UpdateTime: cur.UpdateTime()}, nil
+		snap := Snapshot{Value: v, UpdateTime: cur.UpdateTime()}
+		if r, ok := cur.(driver.Revisioner); ok {
+			snap.Revision = r.Revision()
+		}
+		return snap, nil
+	}
+}
+
+// Latest returns the most recent value of the variable that was read
+// without error. The first call starts watching the variable in the
+// background; until its first value has been read, Latest blocks. If ctx is
+// Done first, Latest returns the error from the last attempt to read the
+// variable, or ctx.Err() if there was none.
+func (c *Variable) Latest(ctx context.Context) (Snapshot, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return Snapshot{}, errors.New("Variable.Latest: Variable is closed")
+	}
+	c.startBackgroundLocked()
+	c.mu.Unlock()
+
+	select {
+	case <-c.haveGood:
+	case <-c.closing:
+		return Snapshot{}, errors.New("Variable.Latest: Variable is closed")
+	case <-ctx.Done():
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		select {
+		case <-c.haveGood:
+			// The value arrived at the same time.
+			return c.latest, nil
+		default:
+		}
+		if c.lastErr != nil {
+			return Snapshot{}, c.lastErr
+		}
+		return Snapshot{}, ctx.Err()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.latest, nil
+}
+
+// LastError returns the error from the most recent attempt to read the
+// variable in the background, or nil if it succeeded or Latest and OnChange
+// haven't been called. For example, it reports a new value that was
+// rejected by the Decoder's Validate function as a *ValidationError, while
+// Latest keeps returning the last valid value.
+func (c *Variable) LastError() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastErr
+}
+
+// OnChange registers f to be called with each new value of the variable,
+// starting with the current value if there is one. Errors reading the
+// variable are not reported to f; the variable keeps its last good value.
+// OnChange starts watching the variable in the background, like Latest.
+//
+// Callbacks are called from one goroutine at a time, so they should return
+// quickly, and they must not call OnChange or Close.
+func (c *Variable) OnChange(f func(Snapshot)) {
+	c.cbMu.Lock()
+	defer c.cbMu.Unlock()
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.callbacks = append(c.callbacks, f)
+	c.startBackgroundLocked()
+	var cur Snapshot
+	haveCur := false
+	select {
+	case <-c.haveGood:
+		cur, haveCur = c.latest, true
+	default:
+	}
+	c.mu.Unlock()
+	if haveCur {
+		f(cur)
+	}
+}
+
+// startBackgroundLocked starts the background goroutine for Latest and
+// OnChange, if it isn't running. c.mu must be held.
+func (c *Variable) startBackgroundLocked() {
+	if c.bgStarted {
+		return
+	}
+	c.bgStarted = true
+	ctx, cancel := context.WithCancel(context.Background())
+	c.bgCancel = cancel
+	c.bgDone = make(chan struct{})
+	go c.background(ctx)
+}
+
+// background watches the variable until ctx is canceled, recording each
+// result and calling the OnChange callbacks for new values.
+func (c *Variable) background(ctx context.Context) {
+	defer close(c.bgDone)
+	for {
+		snap, err := c.watch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		c.cbMu.Lock()
+		c.mu.Lock()
+		if err != nil {
+			c.lastErr = err
+			c.mu.Unlock()
+			c.cbMu.Unlock()
+			continue
+		}
+		c.latest = snap
+		c.lastErr = nil
+		select {
+		case <-c.haveGood:
+		default:
+			close(c.haveGood)
+		}
+		callbacks := c.callbacks
+		c.mu.Unlock()
+		for _, f := range callbacks {
+			f(snap)
+		}
+		c.cbMu.Unlock()
 	}
 }
 
-// Close cleans up any resources used by the Variable object.
+// Close cleans up any resources used by the Variable object, stopping any
+// background watching started by Latest or OnChange.
 func (c *Variable) Close() error {
+	c.mu.Lock()
+	if !c.closed {
+		close(c.closing)
+	}
+	c.closed = true
+	cancel, done := c.bgCancel, c.bgDone
+	c.bgCancel = nil
+	c.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
 	return c.watcher.Close()
 }
 
+// ErrRevisionMismatch is returned by Set and Delete when the variable's
+// current revision is not the one given.
+var ErrRevisionMismatch = driver.ErrRevisionMismatch
+
+// Set sets the variable's value to val, which is the encoded form that the
+// variable's decoder decodes. It returns an error if the provider doesn't
+// support changing variables.
+//
+// If rev is not empty, the variable is only changed if its current revision
+// is rev, such as the Revision of a Snapshot; otherwise Set returns
+// ErrRevisionMismatch. Providers that can't check revisions return a
+// different error if rev is not empty.
+//
+// Set may be called concurrently with other methods.
+func (c *Variable) Set(ctx context.Context, val []byte, rev string) error {
+	u, ok := c.watcher.(driver.Updater)
+	if !ok {
+		return errors.New("Variable.Set: the provider does not support changing variables")
+	}
+	if err := u.Set(ctx, val, rev); err != nil {
+		if err == driver.ErrRevisionMismatch {
+			return ErrRevisionMismatch
+		}
+		return fmt.Errorf("Variable.Set: %v", err)
+	}
+	return nil
+}
+
+// Delete deletes the variable. rev is as for Set.
+//
+// Delete may be called concurrently with other methods.
+func (c *Variable) Delete(ctx context.Context, rev string) error {
+	u, ok := c.watcher.(driver.Updater)
+	if !ok {
+		return errors.New("Variable.Delete: the provider does not support changing variables")
+	}
+	if err := u.Delete(ctx, rev); err != nil {
+		if err == driver.ErrRevisionMismatch {
+			return ErrRevisionMismatch
+		}
+		return fmt.Errorf("Variable.Delete: %v", err)
+	}
+	return nil
+}
+
+// URLOptions holds the options that Open parses from a URL for all
+// providers.
+type URLOptions struct {
+	// Decoder decodes the variable's value. It is never nil.
+	Decoder *Decoder
+	// WaitDuration is the value of the "wait" query parameter, or zero if
+	// it isn't set.
+	WaitDuration time.Duration
+}
+
+// FromURLFunc is for use by provider implementations.
+// It allows providers to convert a parsed URL from Open to a driver.Watcher.
+type FromURLFunc func(context.Context, *url.URL, *URLOptions) (driver.Watcher, error)
+
+var (
+	// registry maps scheme strings to provider-specific instantiation functions.
+	registry = map[string]FromURLFunc{}
+	// registryMu protects registry.
+	registryMu sync.Mutex
+)
+
+// Register is for use by provider implementations. It allows providers to
+// register an instantiation function for URLs with the given scheme. It is
+// expected to be called from the provider implementation's package init
+// function.
+//
+// fn will be called from Open, with the URL and the options parsed from it.
+// The query parameters handled by Open are removed from the URL.
+//
+// Register panics if a provider has already registered for scheme.
+func Register(scheme string, fn FromURLFunc) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, found := registry[scheme]; found {
+		panic(fmt.Sprintf("runtimevar.Register: a provider has already registered for scheme %q", scheme))
+	}
+	registry[scheme] = fn
+}
+
+// fromRegistry looks up the registered function for scheme.
+// It returns nil if scheme has not been registered for.
+func fromRegistry(scheme string) FromURLFunc {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	return registry[scheme]
+}
+
+// Open creates a *Variable from a URL.
+// See provider documentation for more details on supported scheme(s) and
+// option(s).
+//
+// The following query parameters are supported for all providers:
+// - decoder: The name of a decoder to use if decoder is nil; one of
+//       "string", "bytes", "json", "yaml" or "toml". JSON, YAML and TOML
+//       values are decoded into a map[string]interface{}. If neither
+//       decoder nor this parameter is set, StringDecoder is used.
+// - wait: A duration such as "10s"; sets the provider's WaitDuration
+//       option.
+// Example URL: runtimevar.Open(ctx, "file:///etc/myapp/config.json?decoder=json&wait=5s", nil)
+func Open(ctx context.Context, urlstr string, decoder *Decoder) (*Variable, error) {
+	u, err := url.Parse(urlstr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" {
+		return nil, fmt.Errorf("invalid URL %q, missing scheme", urlstr)
+	}
+	fn := fromRegistry(u.Scheme)
+	if fn == nil {
+		return nil, fmt.Errorf("no provider registered for scheme %q", u.Scheme)
+	}
+	q := u.Query()
+	opts := &URLOptions{Decoder: decoder}
+	if name := q.Get("decoder"); name != "" {
+		if decoder != nil {
+			return nil, fmt.Errorf("runtimevar.Open: URL %q sets a decoder, but one was also passed to Open", urlstr)
+		}
+		opts.Decoder = decoderByName(name)
+		if opts.Decoder == nil {
+			return nil, fmt.Errorf("runtimevar.Open: unknown decoder %q", name)
+		}
+	}
+	if opts.Decoder == nil {
+		opts.Decoder = StringDecoder
+	}
+	if v := q.Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("runtimevar.Open: invalid value %q for query parameter wait: %v", v, err)
+		}
+		opts.WaitDuration = d
+	}
+	q.Del("decoder")
+	q.Del("wait")
+	u.RawQuery = q.Encode()
+	w, err := fn(ctx, u, opts)
+	if err != nil {
+		return nil, err
+	}
+	return New(w), nil
+}
+
+// decoderByName returns the decoder for the "decoder" query parameter of
+// Open, or nil if name is unknown.
+func decoderByName(name string) *Decoder {
+	switch name {
+	case "string":
+		return StringDecoder
+	case "bytes":
+		return BytesDecoder
+	case "json":
+		return NewDecoder(map[string]interface{}{}, JSONDecode)
+	case "yaml":
+		return NewDecoder(map[string]interface{}{}, YAMLDecode)
+	case "toml":
+		return NewDecoder(map[string]interface{}{}, TOMLDecode)
+	}
+	return nil
+}
+
 // Decode is a function type for unmarshaling/decoding bytes into given object.
 type Decode func([]byte, interface{}) error
 
@@ -114,24 +473,89 @@ type Decoder struct {
 	Type reflect.Type
 	// Func is a Decode function.
 	Func Decode
+	// Validate, if not nil, is called with each decoded value. If it
+	// returns an error, Decode fails with a *ValidationError, so drivers
+	// report the value as an error instead of returning it.
+	Validate Validator
+}
+
+// Validator checks a decoded value, returning an error if it is invalid.
+type Validator func(value interface{}) error
+
+// ValidationError is returned by Decoder.Decode when a decoded value is
+// rejected by the Decoder's Validate function.
+type ValidationError struct {
+	// Err is the error returned by Validate.
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid value: %v", e.Err)
 }
 
 // NewDecoder constructs a Decoder for given object that uses the given Decode function.
-func NewDecoder(obj interface{}, fn Decode) *Decoder {
-	return &Decoder{
+// Any validators are called in order with each decoded value; the first error
+// rejects the value.
+func NewDecoder(obj interface{}, fn Decode, validators ...Validator) *Decoder {
+	d := &Decoder{
 		Type: reflect.TypeOf(obj),
 		Func: fn,
 	}
+	switch len(validators) {
+	case 0:
+	case 1:
+		d.Validate = validators[0]
+	default:
+		d.Validate = func(v interface{}) error {
+			for _, validate := range validators {
+				if err := validate(v); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	return d
 }
 
-// Decode decodes given bytes into an object of type Type using Func.
+// Decode decodes given bytes into an object of type Type using Func, and
+// checks it with Validate.
 func (d *Decoder) Decode(b []byte) (interface{}, error) {
 	nv := reflect.New(d.Type).Interface()
 	if err := d.Func(b, nv); err != nil {
 		return nil, err
 	}
-	ptr := reflect.ValueOf(nv)
-	return ptr.Elem().Interface(), nil
+	v := reflect.ValueOf(nv).Elem().Interface()
+	if d.Validate != nil {
+		if err := d.Validate(v); err != nil {
+			return nil, &ValidationError{Err: err}
+		}
+	}
+	return v, nil
+}
+
+// JSONSchemaValidator returns a Validator that checks values against the
+// JSON Schema in schema. Values are encoded as JSON to be checked, so struct
+// fields are matched by their "json" names.
+func JSONSchemaValidator(schema []byte) (Validator, error) {
+	s, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(schema))
+	if err != nil {
+		return nil, fmt.Errorf("runtimevar: invalid JSON Schema: %v", err)
+	}
+	return func(v interface{}) error {
+		res, err := s.Validate(gojsonschema.NewGoLoader(v))
+		if err != nil {
+			return err
+		}
+		if res.Valid() {
+			return nil
+		}
+		var msgs []string
+		for _, e := range res.Errors() {
+			msgs = append(msgs, e.String())
+		}
+		return errors.New(strings.Join(msgs, "; "))
+	}, nil
 }
 
 // Simple Decoder objects.
@@ -157,6 +581,67 @@ func GobDecode(data []byte, obj interface{}) error {
 	return gob.NewDecoder(bytes.NewBuffer(data)).Decode(obj)
 }
 
+// YAMLDecode decodes YAML into given object. The YAML is converted to JSON
+// and decoded with JSONDecode, so structs use their "json" field tags, and
+// mappings are decoded as map[string]interface{} rather than
+// map[interface{}]interface{}.
+func YAMLDecode(data []byte, obj interface{}) error {
+	return yaml.Unmarshal(data, obj)
+}
+
+// TOMLDecode decodes TOML into given object. Structs use their "toml" field
+// tags.
+func TOMLDecode(data []byte, obj interface{}) error {
+	return toml.Unmarshal(data, obj)
+}
+
+// ProtoFormat is the encoding of protocol buffer messages for
+// NewProtoDecoder.
+type ProtoFormat int
+
+// Protocol buffer encodings.
+const (
+	// ProtoBinary is the binary wire format.
+	ProtoBinary ProtoFormat = iota
+	// ProtoText is the text format.
+	ProtoText
+	// ProtoJSON is the JSON mapping of protocol buffers. Unknown fields are
+	// ignored.
+	ProtoJSON
+)
+
+// NewProtoDecoder constructs a Decoder that decodes bytes in the given format
+// into messages of the same type as msg, which must be a pointer to a
+// generated message struct. The decoded values are of the same type as msg.
+func NewProtoDecoder(msg proto.Message, format ProtoFormat) *Decoder {
+	return &Decoder{
+		Type: reflect.TypeOf(msg),
+		Func: func(data []byte, obj interface{}) error {
+			// obj is a pointer to a message pointer; allocate the message.
+			v := reflect.ValueOf(obj).Elem()
+			m := reflect.New(v.Type().Elem())
+			pb := m.Interface().(proto.Message)
+			var err error
+			switch format {
+			case ProtoBinary:
+				err = proto.Unmarshal(data, pb)
+			case ProtoText:
+				err = proto.UnmarshalText(string(data), pb)
+			case ProtoJSON:
+				u := jsonpb.Unmarshaler{AllowUnknownFields: true}
+				err = u.Unmarshal(bytes.NewReader(data), pb)
+			default:
+				err = fmt.Errorf("runtimevar: unknown ProtoFormat %d", format)
+			}
+			if err != nil {
+				return err
+			}
+			v.Set(m)
+			return nil
+		},
+	}
+}
+
 func stringDecode(b []byte, obj interface{}) error {
 	// obj is a pointer to a string.
 	v := reflect.ValueOf(obj).Elem()
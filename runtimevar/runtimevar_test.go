@@ -23,10 +23,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/ghodss/yaml"
+	"github.com/golang/protobuf/proto"
+	durpb "github.com/golang/protobuf/ptypes/duration"
 	"github.com/google/go-cloud/runtimevar"
 	"github.com/google/go-cloud/runtimevar/driver"
 	"github.com/google/go-cmp/cmp"
@@ -193,6 +199,204 @@ func TestVariable(t *testing.T) {
 	}
 }
 
+// chanWatcher is a driver.Watcher that returns the states sent on ch.
+type chanWatcher struct {
+	ch chan *state
+}
+
+func (w *chanWatcher) WatchVariable(ctx context.Context, prev driver.State) (driver.State, time.Duration) {
+	select {
+	case <-ctx.Done():
+		return &state{err: ctx.Err()}, 0
+	case s := <-w.ch:
+		return s, 0
+	}
+}
+
+func (w *chanWatcher) Close() error { return nil }
+
+func TestLatest(t *testing.T) {
+	ctx := context.Background()
+	w := &chanWatcher{ch: make(chan *state)}
+	v := runtimevar.New(w)
+	defer v.Close()
+
+	// latestWithin calls Latest with a short timeout.
+	latestWithin := func() (runtimevar.Snapshot, error) {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		return v.Latest(ctx)
+	}
+	// waitFor calls Latest until it returns want.
+	waitFor := func(want string) {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			if snap, err := v.Latest(ctx); err == nil && snap.Value == want {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatalf("Latest never returned %q", want)
+	}
+
+	if _, err := latestWithin(); err != context.DeadlineExceeded {
+		t.Errorf("got %v before the first value, want %v", err, context.DeadlineExceeded)
+	}
+	w.ch <- &state{err: errors.New("fail")}
+	if _, err := latestWithin(); err == nil || !strings.Contains(err.Error(), "fail") {
+		t.Errorf("got %v after an error, want the error", err)
+	}
+	if _, err := v.Watch(ctx); err == nil {
+		t.Error("Watch after Latest: got nil error, want error")
+	}
+
+	w.ch <- &state{val: "v1"}
+	waitFor("v1")
+	// Errors don't replace the latest good value.
+	w.ch <- &state{err: errors.New("fail")}
+	w.ch <- &state{val: "v2"}
+	waitFor("v2")
+
+	// Latest is safe to call concurrently.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if snap, err := v.Latest(ctx); err != nil || snap.Value != "v2" {
+				t.Errorf("got %v, %v want %q", snap.Value, err, "v2")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := v.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Latest(ctx); err == nil {
+		t.Error("Latest after Close: got nil error, want error")
+	}
+}
+
+func TestLatestClose(t *testing.T) {
+	v := runtimevar.New(&chanWatcher{ch: make(chan *state)})
+	errc := make(chan error)
+	go func() {
+		_, err := v.Latest(context.Background())
+		errc <- err
+	}()
+	// Give Latest time to block waiting for a value, then Close.
+	time.Sleep(10 * time.Millisecond)
+	if err := v.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Error("got nil error from Latest after Close, want error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Latest kept blocking after Close")
+	}
+}
+
+func TestOnChange(t *testing.T) {
+	w := &chanWatcher{ch: make(chan *state)}
+	v := runtimevar.New(w)
+	defer v.Close()
+
+	got1 := make(chan interface{}, 10)
+	v.OnChange(func(snap runtimevar.Snapshot) { got1 <- snap.Value })
+	w.ch <- &state{val: "v1"}
+	w.ch <- &state{err: errors.New("fail")}
+	w.ch <- &state{val: "v2"}
+	for _, want := range []string{"v1", "v2"} {
+		select {
+		case got := <-got1:
+			if got != want {
+				t.Errorf("got %v want %q", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+
+	// A callback registered later is called with the current value first.
+	got2 := make(chan interface{}, 10)
+	v.OnChange(func(snap runtimevar.Snapshot) { got2 <- snap.Value })
+	if got := <-got2; got != "v2" {
+		t.Errorf("got %v want %q", got, "v2")
+	}
+	w.ch <- &state{val: "v3"}
+	for _, got := range []chan interface{}{got1, got2} {
+		select {
+		case v := <-got:
+			if v != "v3" {
+				t.Errorf("got %v want %q", v, "v3")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for v3")
+		}
+	}
+}
+
+// updatingWatcher is a chanWatcher that implements driver.Updater.
+type updatingWatcher struct {
+	chanWatcher
+	val, rev string
+}
+
+func (w *updatingWatcher) Set(ctx context.Context, val []byte, rev string) error {
+	if rev == "fail" {
+		return errors.New("fail")
+	}
+	if rev != "" && rev != w.rev {
+		return driver.ErrRevisionMismatch
+	}
+	w.val, w.rev = string(val), w.rev+"+"
+	return nil
+}
+
+func (w *updatingWatcher) Delete(ctx context.Context, rev string) error {
+	if rev != "" && rev != w.rev {
+		return driver.ErrRevisionMismatch
+	}
+	w.val, w.rev = "", ""
+	return nil
+}
+
+func TestSetDelete(t *testing.T) {
+	ctx := context.Background()
+	v := runtimevar.New(&chanWatcher{})
+	if err := v.Set(ctx, []byte("x"), ""); err == nil {
+		t.Error("Set: got nil error for a read-only provider, want error")
+	}
+	if err := v.Delete(ctx, ""); err == nil {
+		t.Error("Delete: got nil error for a read-only provider, want error")
+	}
+
+	w := &updatingWatcher{rev: "1"}
+	v = runtimevar.New(w)
+	if err := v.Set(ctx, []byte("x"), "1"); err != nil {
+		t.Fatal(err)
+	}
+	if w.val != "x" {
+		t.Errorf("got %q want %q", w.val, "x")
+	}
+	if err := v.Set(ctx, []byte("y"), "1"); err != runtimevar.ErrRevisionMismatch {
+		t.Errorf("got %v want %v", err, runtimevar.ErrRevisionMismatch)
+	}
+	if err := v.Set(ctx, []byte("y"), "fail"); err == nil || err == runtimevar.ErrRevisionMismatch {
+		t.Errorf("got %v, want a different error", err)
+	}
+	if err := v.Delete(ctx, "1"); err != runtimevar.ErrRevisionMismatch {
+		t.Errorf("got %v want %v", err, runtimevar.ErrRevisionMismatch)
+	}
+	if err := v.Delete(ctx, "1+"); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestDecoder(t *testing.T) {
 	type Struct struct {
 		FieldA string
@@ -244,6 +448,11 @@ func TestDecoder(t *testing.T) {
 			encodeFn: gobMarshal,
 			decodeFn: runtimevar.GobDecode,
 		},
+		{
+			desc:     "YAML",
+			encodeFn: yaml.Marshal,
+			decodeFn: runtimevar.YAMLDecode,
+		},
 	} {
 		for i, input := range inputs {
 			t.Run(fmt.Sprintf("%s_%d", tc.desc, i), func(t *testing.T) {
@@ -275,6 +484,174 @@ func gobMarshal(v interface{}) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+func TestYAMLDecodeMap(t *testing.T) {
+	const input = `
+name: server
+ports:
+- 80
+- 443
+tls:
+  enabled: true
+`
+	got, err := runtimevar.NewDecoder(map[string]interface{}{}, runtimevar.YAMLDecode).Decode([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"name":  "server",
+		"ports": []interface{}{80.0, 443.0},
+		"tls":   map[string]interface{}{"enabled": true},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("value diff:\n%v", diff)
+	}
+}
+
+func TestTOMLDecode(t *testing.T) {
+	type Config struct {
+		Name  string `toml:"name"`
+		Ports []int  `toml:"ports"`
+		TLS   struct {
+			Enabled bool `toml:"enabled"`
+		} `toml:"tls"`
+	}
+	const input = `
+name = "server"
+ports = [80, 443]
+
+[tls]
+enabled = true
+`
+	got, err := runtimevar.NewDecoder(Config{}, runtimevar.TOMLDecode).Decode([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Config{Name: "server", Ports: []int{80, 443}}
+	want.TLS.Enabled = true
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("value diff:\n%v", diff)
+	}
+
+	if _, err := runtimevar.NewDecoder(Config{}, runtimevar.TOMLDecode).Decode([]byte("name = ")); err == nil {
+		t.Error("got nil error for invalid TOML, want error")
+	}
+}
+
+func TestProtoDecoder(t *testing.T) {
+	want := &durpb.Duration{Seconds: 90, Nanos: 500}
+	bin, err := proto.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		desc   string
+		format runtimevar.ProtoFormat
+		input  []byte
+	}{
+		{"binary", runtimevar.ProtoBinary, bin},
+		{"text", runtimevar.ProtoText, []byte("seconds: 90 nanos: 500")},
+		{"JSON", runtimevar.ProtoJSON, []byte(`"90.000000500s"`)},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			decoder := runtimevar.NewProtoDecoder(&durpb.Duration{}, tc.format)
+			got, err := decoder.Decode(tc.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(got.(*durpb.Duration), want) {
+				t.Errorf("got %v want %v", got, want)
+			}
+			// Each Decode returns a new message.
+			again, err := decoder.Decode(tc.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again.(*durpb.Duration) == got.(*durpb.Duration) {
+				t.Error("got the same message from two Decode calls")
+			}
+		})
+	}
+	if _, err := runtimevar.NewProtoDecoder(&durpb.Duration{}, runtimevar.ProtoText).Decode([]byte("bogus: 1")); err == nil {
+		t.Error("got nil error for invalid text, want error")
+	}
+}
+
+func TestDecoderValidate(t *testing.T) {
+	nonEmpty := func(v interface{}) error {
+		if v.(string) == "" {
+			return errors.New("empty")
+		}
+		return nil
+	}
+	short := func(v interface{}) error {
+		if len(v.(string)) > 3 {
+			return errors.New("too long")
+		}
+		return nil
+	}
+	decoder := runtimevar.NewDecoder("", func(b []byte, obj interface{}) error {
+		*obj.(*string) = string(b)
+		return nil
+	}, nonEmpty, short)
+
+	for _, tc := range []struct {
+		input   string
+		wantErr string
+	}{
+		{input: "abc"},
+		{input: "", wantErr: "empty"},
+		{input: "abcd", wantErr: "too long"},
+	} {
+		got, err := decoder.Decode([]byte(tc.input))
+		if tc.wantErr == "" {
+			if err != nil || got != tc.input {
+				t.Errorf("%q: got %v, %v want %q", tc.input, got, err, tc.input)
+			}
+			continue
+		}
+		verr, ok := err.(*runtimevar.ValidationError)
+		if !ok || verr.Err.Error() != tc.wantErr {
+			t.Errorf("%q: got error %v, want a *ValidationError for %q", tc.input, err, tc.wantErr)
+		}
+	}
+}
+
+func TestJSONSchemaValidator(t *testing.T) {
+	const schema = `{
+		"type": "object",
+		"properties": {
+			"port": {"type": "integer", "minimum": 1}
+		},
+		"required": ["port"]
+	}`
+	validate, err := runtimevar.JSONSchemaValidator([]byte(schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	type Config struct {
+		Port int `json:"port"`
+	}
+	for _, tc := range []struct {
+		desc    string
+		value   interface{}
+		wantErr bool
+	}{
+		{"valid struct", Config{Port: 80}, false},
+		{"invalid struct", Config{}, true},
+		{"valid map", map[string]interface{}{"port": 80.0}, false},
+		{"missing key", map[string]interface{}{"prot": 80.0}, true},
+		{"wrong type", map[string]interface{}{"port": "80"}, true},
+	} {
+		if err := validate(tc.value); (err != nil) != tc.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tc.desc, err, tc.wantErr)
+		}
+	}
+
+	if _, err := runtimevar.JSONSchemaValidator([]byte(`{"type": 1}`)); err == nil {
+		t.Error("got nil error for an invalid schema, want error")
+	}
+}
+
 func TestStringDecoder(t *testing.T) {
 	input := "hello world"
 	got, err := runtimevar.StringDecoder.Decode([]byte(input))
@@ -296,3 +673,98 @@ func TestBytesDecoder(t *testing.T) {
 		t.Errorf("output got %v, want %q", got, input)
 	}
 }
+
+func TestOpen(t *testing.T) {
+	ctx := context.Background()
+	var (
+		gotURL  *url.URL
+		gotOpts *runtimevar.URLOptions
+	)
+	runtimevar.Register("foo", func(_ context.Context, u *url.URL, opts *runtimevar.URLOptions) (driver.Watcher, error) {
+		gotURL, gotOpts = u, opts
+		return &fakeWatcher{t: t}, nil
+	})
+	runtimevar.Register("err", func(_ context.Context, u *url.URL, opts *runtimevar.URLOptions) (driver.Watcher, error) {
+		return nil, errors.New("fail")
+	})
+
+	for _, tc := range []struct {
+		name        string
+		url         string
+		decoder     *runtimevar.Decoder
+		wantURL     string
+		wantDecoder *runtimevar.Decoder
+		wantWait    time.Duration
+		wantErr     bool
+	}{
+		{name: "empty URL", wantErr: true},
+		{name: "invalid URL no scheme", url: "foo", wantErr: true},
+		{name: "unregistered scheme", url: "bar://myvar", wantErr: true},
+		{name: "func returns error", url: "err://myvar", wantErr: true},
+		{name: "unknown decoder", url: "foo://myvar?decoder=xml", wantErr: true},
+		{name: "two decoders", url: "foo://myvar?decoder=bytes", decoder: runtimevar.StringDecoder, wantErr: true},
+		{name: "invalid wait", url: "foo://myvar?wait=soon", wantErr: true},
+		{
+			name:        "default decoder",
+			url:         "foo://myvar",
+			wantURL:     "foo://myvar",
+			wantDecoder: runtimevar.StringDecoder,
+		},
+		{
+			name:        "decoder argument",
+			url:         "foo:///my/var?x=1",
+			decoder:     runtimevar.BytesDecoder,
+			wantURL:     "foo:///my/var?x=1",
+			wantDecoder: runtimevar.BytesDecoder,
+		},
+		{
+			name:        "common parameters",
+			url:         "foo://myvar?decoder=bytes&wait=5s&x=1",
+			wantURL:     "foo://myvar?x=1",
+			wantDecoder: runtimevar.BytesDecoder,
+			wantWait:    5 * time.Second,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, gotErr := runtimevar.Open(ctx, tc.url, tc.decoder)
+			if (gotErr != nil) != tc.wantErr {
+				t.Fatalf("got err %v, want error %v", gotErr, tc.wantErr)
+			}
+			if gotErr != nil {
+				return
+			}
+			if gotURL.String() != tc.wantURL {
+				t.Errorf("got URL %q want %q", gotURL, tc.wantURL)
+			}
+			if gotOpts.Decoder != tc.wantDecoder {
+				t.Errorf("got decoder %v want %v", gotOpts.Decoder, tc.wantDecoder)
+			}
+			if gotOpts.WaitDuration != tc.wantWait {
+				t.Errorf("got WaitDuration %v want %v", gotOpts.WaitDuration, tc.wantWait)
+			}
+		})
+	}
+
+	// The json decoder decodes into a map.
+	if _, err := runtimevar.Open(ctx, "foo://myvar?decoder=json", nil); err != nil {
+		t.Fatal(err)
+	}
+	v, err := gotOpts.Decoder.Decode([]byte(`{"a": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(v, map[string]interface{}{"a": 1.0}); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	fn := func(context.Context, *url.URL, *runtimevar.URLOptions) (driver.Watcher, error) { return nil, nil }
+	runtimevar.Register("twice", fn)
+	defer func() {
+		if recover() == nil {
+			t.Error("got no panic registering a scheme twice, want panic")
+		}
+	}()
+	runtimevar.Register("twice", fn)
+}
@@ -138,25 +138,14 @@ func newApplication(srv *server.Server, db *sql.DB, bucket *blob.Bucket, motdVar
 		db:     db,
 		bucket: bucket,
 	}
-	go app.watchMOTDVar(motdVar)
-	return app
-}
-
-// watchMOTDVar listens for changes in v and updates the app's message of the
-// day. It is run in a separate goroutine.
-func (app *application) watchMOTDVar(v *runtimevar.Variable) {
-	ctx := context.Background()
-	for {
-		snap, err := v.Watch(ctx)
-		if err != nil {
-			log.Printf("watch MOTD variable: %v", err)
-			continue
-		}
+	// Errors reading the variable keep the last message of the day.
+	motdVar.OnChange(func(snap runtimevar.Snapshot) {
 		log.Println("updated MOTD to", snap.Value)
 		app.mu.Lock()
 		app.motd = snap.Value.(string)
 		app.mu.Unlock()
-	}
+	})
+	return app
 }
 
 // index serves the server's landing page. It lists the 100 most recent